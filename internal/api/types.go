@@ -1,7 +1,11 @@
 package api
 
 import (
+	"fmt"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/replit/upm/internal/util"
 )
@@ -17,13 +21,102 @@ type PkgSpec string
 // "1.0b2.post345.dev456" for Python.
 type PkgVersion string
 
+// PkgVersionInfo represents metadata about one published version of
+// a package. Any of the fields may be zeroed except for Version.
+//
+// Note: like PkgInfo, the PkgVersionInfo struct is parsed with
+// reflection. It must have "json" and "pretty" tags, and the allowed
+// types are the same as for PkgInfo.
+type PkgVersionInfo struct {
+
+	// The version number, e.g. "1.1.1". No particular format is
+	// enforced.
+	Version string `json:"version" pretty:"Version"`
+
+	// When the version was published to the registry.
+	PublishedAt *time.Time `json:"publishedAt,omitempty" pretty:"Published"`
+
+	// Whether the version is a prerelease (alpha, beta, release
+	// candidate, and so on), according to the versioning scheme
+	// of the registry.
+	Prerelease bool `json:"prerelease,omitempty" pretty:"Prerelease"`
+
+	// Whether the version has been withdrawn from the registry
+	// (yanked, retracted, or unlisted, depending on the
+	// registry), so that it should no longer be installed.
+	Yanked bool `json:"yanked,omitempty" pretty:"Yanked"`
+
+	// Whether the maintainers have marked the version as
+	// deprecated.
+	Deprecated bool `json:"deprecated,omitempty" pretty:"Deprecated"`
+
+	// Number of times the version has been downloaded, or zero
+	// if unknown.
+	Downloads int `json:"downloads,omitempty" pretty:"Downloads"`
+}
+
+// PkgVersions is a list of the published versions of a package,
+// ordered from oldest to newest.
+type PkgVersions []PkgVersionInfo
+
+// maxVersionsShown is the number of versions included in the String
+// representation of PkgVersions.
+const maxVersionsShown = 5
+
+// String returns a summary of the versions for human consumption,
+// listing the newest ones first. For example, "4.17.1, 4.17.0,
+// 4.16.4, 4.16.3, 4.16.2 (and 259 more)".
+func (vs PkgVersions) String() string {
+	parts := []string{}
+	for i := len(vs) - 1; i >= 0 && len(parts) < maxVersionsShown; i-- {
+		parts = append(parts, vs[i].Version)
+	}
+	str := strings.Join(parts, ", ")
+	if len(vs) > len(parts) {
+		str += fmt.Sprintf(" (and %d more)", len(vs)-len(parts))
+	}
+	return str
+}
+
+// PkgRepository describes where the source code of a package is
+// kept.
+type PkgRepository struct {
+
+	// The version control system, e.g. "git".
+	Type string `json:"type,omitempty"`
+
+	// URL of the repository, e.g.
+	// "https://github.com/pallets/flask".
+	URL string `json:"url,omitempty"`
+
+	// Subdirectory of the repository containing the package, for
+	// repositories holding more than one package.
+	Directory string `json:"directory,omitempty"`
+}
+
+// String returns a representation of the repository for human
+// consumption, e.g. "git+https://github.com/babel/babel.git
+// (packages/babel-core)".
+func (r PkgRepository) String() string {
+	str := r.URL
+	if r.Type != "" && !strings.HasPrefix(str, r.Type+"+") && !strings.HasPrefix(str, r.Type+":") {
+		str = r.Type + "+" + str
+	}
+	if r.Directory != "" {
+		str += " (" + r.Directory + ")"
+	}
+	return str
+}
+
 // PkgInfo is a general-purpose struct for representing package
 // metadata. Any of the fields may be zeroed except for Name. Which
 // fields are nonzero depends on the context and language backend.
 //
 // Note: the PkgInfo struct is parsed with reflection in several
 // places. It must have "json" and "pretty" tags, and the only allowed
-// types are string and []string.
+// types are string, bool, int, *time.Time, types implementing
+// fmt.Stringer, and slices of these. Zero values must have
+// "omitempty" so that they are left out of the JSON output.
 type PkgInfo struct {
 
 	// The name of the package, e.g. "flask". Package names cannot
@@ -69,6 +162,85 @@ type PkgInfo struct {
 	// no dependencies and a package whose language backend did
 	// not provide dependency information.
 	Dependencies []string `json:"dependencies,omitempty" pretty:"Dependencies"`
+
+	// When Version was published to the registry.
+	PublishedAt *time.Time `json:"publishedAt,omitempty" pretty:"Published"`
+
+	// Whether the maintainers have marked the package (or
+	// Version) as deprecated.
+	Deprecated bool `json:"deprecated,omitempty" pretty:"Deprecated"`
+
+	// Whether Version has been withdrawn from the registry. See
+	// PkgVersionInfo.
+	Yanked bool `json:"yanked,omitempty" pretty:"Yanked"`
+
+	// Number of times the package has been downloaded, or zero if
+	// unknown. Registries differ in what they count (e.g. all
+	// time for RubyGems and crates.io, but only the last week for
+	// NPM), so this should only be compared within a backend.
+	Downloads int `json:"downloads,omitempty" pretty:"Downloads"`
+
+	// Number of packages in the registry that depend on this
+	// one, or zero if unknown.
+	ReverseDependencies int `json:"reverseDependencies,omitempty" pretty:"Reverse dependencies"`
+
+	// Where the package's source code is kept, if the registry
+	// provides more detail than SourceCodeURL.
+	Repository *PkgRepository `json:"repository,omitempty" pretty:"Repository"`
+
+	// All published versions of the package, from oldest to
+	// newest. Backends typically only provide this from Info,
+	// not from Search.
+	Versions PkgVersions `json:"versions,omitempty" pretty:"Versions"`
+}
+
+// Confidence is how sure a language backend is of a guess, from 0 (a
+// wild guess) to 1 (certain).
+type Confidence float64
+
+// String returns the confidence to two decimal places, e.g. "0.75".
+func (c Confidence) String() string {
+	return strconv.FormatFloat(float64(c), 'f', 2, 64)
+}
+
+// GuessReason explains why a package was guessed: which import in
+// which file it comes from, and how the import was mapped to the
+// package. A package may have several reasons.
+//
+// Note: like PkgInfo, the GuessReason struct is parsed with
+// reflection. It must have "json" and "pretty" tags, and the allowed
+// types are the same as for PkgInfo.
+type GuessReason struct {
+
+	// The guessed package, in the same format as returned by
+	// Guess.
+	Package PkgName `json:"package" pretty:"Package"`
+
+	// The file containing the import, relative to the project
+	// directory.
+	File string `json:"file,omitempty" pretty:"File"`
+
+	// The 1-based line of the import in File, or zero if unknown.
+	Line int `json:"line,omitempty" pretty:"Line"`
+
+	// The imported module (or required feature, or whatever is
+	// analogous for the language), e.g. "cv2" for Python.
+	Module string `json:"module,omitempty" pretty:"Module"`
+
+	// How Module was mapped to Package, e.g. "pragma" for a
+	// "#upm package(...)" pragma, "pypi-map" for the generated
+	// PyPI module map, "npm-scope" for a scoped NPM package, or
+	// "epkgs" for the Emacsmirror feature database.
+	Source string `json:"source,omitempty" pretty:"Source"`
+
+	// How sure the backend is that Package provides Module. This
+	// is 1 if nothing else provides it, and lower if other
+	// packages do, e.g. the share of the downloads of all the
+	// packages providing Module that went to Package.
+	Confidence Confidence `json:"confidence" pretty:"Confidence"`
+
+	// The other packages that provide Module, most likely first.
+	Alternatives []string `json:"alternatives,omitempty" pretty:"Alternatives"`
 }
 
 // Quirks is a bitmask enum used to indicate how specific language
@@ -170,6 +342,15 @@ type LanguageBackend struct {
 	// This field is mandatory.
 	FilenamePatterns []string
 
+	// The names of the programs that the language backend runs,
+	// such as its package manager, e.g. "npm" for NPM. When
+	// autodetecting the language, a backend is preferred if
+	// these can be found on the PATH, so that a project with
+	// only a package.json uses Yarn if NPM isn't installed.
+	//
+	// This field is optional.
+	Executables []string
+
 	// QuirksNone if the language backend conforms to the core
 	// abstractions of UPM, and some bitwise disjunction of the
 	// Quirks constant values otherwise.
@@ -190,6 +371,20 @@ type LanguageBackend struct {
 	// which packages are installed. The path need not exist.
 	GetPackageDir func() string
 
+	// Globs, relative to the package dir, matching the metadata
+	// files that the package manager writes for installed
+	// packages, e.g. ".package-lock.json" for NPM. After each
+	// install, UPM records a fingerprint of the entries in the
+	// package dir together with these files, and installs again
+	// if the fingerprint no longer matches (for example, because
+	// the package dir was deleted). The globs may be empty, in
+	// which case only the entries are fingerprinted.
+	//
+	// This field is optional. If it is nil, then the package
+	// dir isn't checked, which is appropriate if it's shared
+	// with other projects or holds build output.
+	PackageDirMarkers []string
+
 	// Search for packages using an online index. The query may
 	// contain any characters, including whitespace. Return a list
 	// of search results, which can be of any length. (It will be
@@ -200,12 +395,34 @@ type LanguageBackend struct {
 	// This field is mandatory.
 	Search func(query string) []PkgInfo
 
+	// Search for packages like Search, but return only up to
+	// limit results, skipping the first offset results in the
+	// order that the online index ranks them. Returning fewer
+	// than limit results means that there are no more. This
+	// allows paging through results without retrieving all of
+	// them. The limit is always positive.
+	//
+	// This field is optional; if it is omitted, then Search is
+	// used instead and the results are paged through by the
+	// command-line interface.
+	SearchPaged func(query string, offset int, limit int) []PkgInfo
+
 	// Retrieve information about a package from an online index.
 	// If the package doesn't exist, return a zero struct.
 	//
 	// This field is mandatory.
 	Info func(PkgName) PkgInfo
 
+	// Retrieve information about a specific version of a package
+	// from an online index, in the same way as Info. The
+	// returned metadata (such as Dependencies and License) must
+	// describe that exact version. If the package or version
+	// doesn't exist, return a zero struct.
+	//
+	// This field is optional; if it is omitted, then info can
+	// only be shown for the latest version.
+	VersionInfo func(PkgName, PkgVersion) PkgInfo
+
 	// Add packages to the specfile. The map is guaranteed to have
 	// at least one package, and all of the packages are
 	// guaranteed to not already be in the specfile (according to
@@ -288,9 +505,19 @@ type LanguageBackend struct {
 	// return value of Guess might change.
 	//
 	// This field is optional; if it is omitted, then Guess will
-	// always be run without recourse to caching.
+	// always be run without recourse to caching. It is ignored if
+	// GuessFileImports is given, since the imports of each file
+	// are then cached instead, so it should be left out.
 	GuessRegexps []*regexp.Regexp
 
+	// Return anything else that the return value of Guess
+	// depends on, besides what GuessRegexps match, such as the
+	// name of the project's own package from the specfile, so
+	// that it is hashed along with the matches.
+	//
+	// This field is optional, and only used with GuessRegexps.
+	GuessExtraInput func() []byte
+
 	// Return a list of packages that are probably needed as
 	// dependencies of the project. It is better to be safe than
 	// sorry: only packages which are *definitely* project
@@ -307,8 +534,48 @@ type LanguageBackend struct {
 	// causing UPM to re-use the existing Guess return value
 	// (which is now wrong).
 	//
-	// This field is mandatory.
+	// This field is mandatory, unless GuessExplain is given, in
+	// which case it defaults to the packages of the reasons that
+	// GuessExplain returns, and the search is always taken to be
+	// successful.
 	Guess func() (map[PkgName]bool, bool)
+
+	// Return the imports (or requires, or whatever is analogous
+	// for the language) of each of the given files, which match
+	// FilenamePatterns and are relative to the project
+	// directory. Imports can be in any format that
+	// GuessFromImports understands, e.g. "flask.ext" for Python.
+	// A file that can't be analyzed, for example because it has
+	// a syntax error, should be left out of the result, so that
+	// it is analyzed again next time.
+	//
+	// Together with GuessFromImports, this lets UPM cache the
+	// imports of each file, and only analyze files that have
+	// changed since the last guess. It should agree with Guess.
+	//
+	// This field is optional, but if it is given then
+	// GuessFromImports must be given too.
+	GuessFileImports func(files []string) map[string][]string
+
+	// Return the packages that are probably needed for the given
+	// imports, which have been returned by GuessFileImports for
+	// some files of the project. The same caveats as for Guess
+	// apply.
+	//
+	// This field is optional, but must be given if
+	// GuessFileImports is.
+	GuessFromImports func(imports []string) map[PkgName]bool
+
+	// Return the reasons for the packages that Guess returns,
+	// for 'upm guess --explain'. Every package returned by Guess
+	// should have at least one reason, and there should be no
+	// reasons for other packages, since the packages are taken
+	// from the reasons instead of calling Guess as well. The
+	// cache is not used, so this may be slower than Guess.
+	//
+	// This field is optional; if it is omitted, then the
+	// packages are shown without reasons.
+	GuessExplain func() []GuessReason
 }
 
 // Setup panics if the given language backend does not specify all of
@@ -335,6 +602,8 @@ func (b *LanguageBackend) Setup() {
 		"missing install":      b.Install == nil,
 		"missing ListSpecfile": b.ListSpecfile == nil,
 		"missing ListLockfile": b.ListLockfile == nil,
+		"missing Guess":        b.Guess == nil && b.GuessExplain == nil,
+		"implement both or neither of GuessFileImports and GuessFromImports": (b.GuessFileImports == nil) != (b.GuessFromImports == nil),
 		// If the backend isn't reproducible, then lock is
 		// unimplemented. So how could it also do
 		// installation?
@@ -354,6 +623,13 @@ func (b *LanguageBackend) Setup() {
 		util.Panicf("language backend %s is incomplete or invalid: %s", b.Name, reasons)
 	}
 
+	if b.Guess == nil {
+		explain := b.GuessExplain
+		b.Guess = func() (map[PkgName]bool, bool) {
+			return GuessedPackages(explain()), true
+		}
+	}
+
 	if b.NormalizePackageName == nil {
 		b.NormalizePackageName = func(name PkgName) PkgName {
 			return name
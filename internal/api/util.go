@@ -1,5 +1,7 @@
 package api
 
+import "strings"
+
 // QuirksIsNotReproducible returns true if the language backend
 // specifies QuirksNotReproducible, i.e. the package manager doesn't
 // support a lockfile and one must be generated after install.
@@ -56,3 +58,122 @@ func (b *LanguageBackend) QuirksDoesLockAlsoInstall() bool {
 func (b *LanguageBackend) QuirksDoesLockNotAlsoInstall() bool {
 	return (b.Quirks & QuirksLockAlsoInstalls) == 0
 }
+
+// GuessedPackages returns the packages that the given reasons are
+// for, as Guess would return them.
+func GuessedPackages(reasons []GuessReason) map[PkgName]bool {
+	pkgs := map[PkgName]bool{}
+	for _, reason := range reasons {
+		pkgs[reason.Package] = true
+	}
+	return pkgs
+}
+
+// ModuleImport is an import of a module (or a require of a path, or
+// whatever is analogous for the language) in a project file, for
+// ExplainImports.
+type ModuleImport struct {
+	File   string
+	Line   int
+	Module string
+
+	// The package given for the module by a "# upm package(...)"
+	// pragma on the import, or the empty string.
+	Pragma string
+}
+
+// ExplainImports implements GuessExplain for backends whose imports
+// can have package pragmas. The modules to guess packages for are the
+// keys of external, whose values are the packages given by pragmas
+// for them in any file, if any. There is a reason for each import of
+// such a module, as returned by lookup, except that if the module has
+// a pragma, then only the imports with that pragma are used, so that
+// the reasons point at the pragma rather than at every import that it
+// applies to.
+func ExplainImports(imports []ModuleImport, external map[string]string, lookup func(module string, pragma string) (GuessReason, bool)) []GuessReason {
+	reasons := []GuessReason{}
+	for _, imp := range imports {
+		pragma, ok := external[imp.Module]
+		if !ok || (pragma != "" && imp.Pragma != pragma) {
+			continue
+		}
+		reason, ok := lookup(imp.Module, pragma)
+		if !ok {
+			continue
+		}
+		reason.File = imp.File
+		reason.Line = imp.Line
+		reasons = append(reasons, reason)
+	}
+	return reasons
+}
+
+// DownloadShare returns the confidence that pkg, rather than one of
+// the other packages providing the same module, is the one a project
+// wants. The others are given as a comma-separated list, as in the
+// candidates of the generated module maps, and are also returned.
+// The confidence is the share of pkg in the downloads of all of them,
+// as given by counts, which may also count something else, such as
+// dependents. If none of them have any, it is split evenly.
+func DownloadShare(pkg string, candidates string, counts map[string]int) (Confidence, []string) {
+	alternatives := []string{}
+	if candidates != "" {
+		alternatives = strings.Split(candidates, ",")
+	}
+	total := counts[pkg]
+	for _, alternative := range alternatives {
+		total += counts[alternative]
+	}
+	if total == 0 {
+		return 1 / Confidence(1+len(alternatives)), alternatives
+	}
+	return Confidence(counts[pkg]) / Confidence(total), alternatives
+}
+
+// FetchPages implements SearchPaged for online indices that only
+// support numbered pages of a fixed size, by retrieving each page
+// that overlaps the requested range of results. The fetch function
+// is called with 1-based page numbers, and should return fewer than
+// pageSize results for the last page.
+func FetchPages(offset int, limit int, pageSize int, fetch func(page int) []PkgInfo) []PkgInfo {
+	skip := offset % pageSize
+	results := []PkgInfo{}
+	for page := offset/pageSize + 1; len(results) < skip+limit; page++ {
+		pkgs := fetch(page)
+		results = append(results, pkgs...)
+		if len(pkgs) < pageSize {
+			break
+		}
+	}
+
+	if skip >= len(results) {
+		return []PkgInfo{}
+	}
+	results = results[skip:]
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// FetchChunks implements SearchPaged for online indices that support
+// arbitrary offsets, but limit how many results can be retrieved in
+// one request, by making as many requests as needed. The fetch
+// function is called with an offset and a size no larger than
+// maxSize, and should return fewer than size results if there are no
+// more.
+func FetchChunks(offset int, limit int, maxSize int, fetch func(offset int, size int) []PkgInfo) []PkgInfo {
+	results := []PkgInfo{}
+	for len(results) < limit {
+		size := limit - len(results)
+		if size > maxSize {
+			size = maxSize
+		}
+		pkgs := fetch(offset+len(results), size)
+		results = append(results, pkgs...)
+		if len(pkgs) < size {
+			break
+		}
+	}
+	return results
+}
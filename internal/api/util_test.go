@@ -0,0 +1,152 @@
+package api
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestFetchPages(t *testing.T) {
+	// An index with 25 results, served in pages of 10.
+	fetched := []int{}
+	fetch := func(page int) []PkgInfo {
+		fetched = append(fetched, page)
+		pkgs := []PkgInfo{}
+		for i := (page - 1) * 10; i < page*10 && i < 25; i++ {
+			pkgs = append(pkgs, PkgInfo{Name: fmt.Sprint(i)})
+		}
+		return pkgs
+	}
+
+	tcs := []struct {
+		scenario string
+		offset   int
+		limit    int
+		expected []string
+		pages    []int
+	}{
+		{
+			scenario: "Within one page",
+			offset:   10,
+			limit:    5,
+			expected: []string{"10", "11", "12", "13", "14"},
+			pages:    []int{2},
+		},
+		{
+			scenario: "Across pages",
+			offset:   8,
+			limit:    4,
+			expected: []string{"8", "9", "10", "11"},
+			pages:    []int{1, 2},
+		},
+		{
+			scenario: "Stops at the last page",
+			offset:   18,
+			limit:    20,
+			expected: []string{"18", "19", "20", "21", "22", "23", "24"},
+			pages:    []int{2, 3},
+		},
+		{
+			scenario: "Past the end",
+			offset:   30,
+			limit:    5,
+			expected: []string{},
+			pages:    []int{4},
+		},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.scenario, func(t *testing.T) {
+			fetched = []int{}
+			actual := []string{}
+			for _, pkg := range FetchPages(tc.offset, tc.limit, 10, fetch) {
+				actual = append(actual, pkg.Name)
+			}
+			if !reflect.DeepEqual(tc.expected, actual) {
+				t.Errorf("expected %v but got %v", tc.expected, actual)
+			}
+			if !reflect.DeepEqual(tc.pages, fetched) {
+				t.Errorf("expected to fetch pages %v but fetched %v", tc.pages, fetched)
+			}
+		})
+	}
+}
+
+func TestFetchChunks(t *testing.T) {
+	// An index with 25 results, which returns at most 10 per
+	// request.
+	requests := [][2]int{}
+	fetch := func(offset int, size int) []PkgInfo {
+		requests = append(requests, [2]int{offset, size})
+		pkgs := []PkgInfo{}
+		for i := offset; i < offset+size && i < 25; i++ {
+			pkgs = append(pkgs, PkgInfo{Name: fmt.Sprint(i)})
+		}
+		return pkgs
+	}
+
+	pkgs := FetchChunks(3, 30, 10, fetch)
+	if len(pkgs) != 22 || pkgs[0].Name != "3" || pkgs[21].Name != "24" {
+		t.Errorf("unexpected results %v", pkgs)
+	}
+	expected := [][2]int{{3, 10}, {13, 10}, {23, 10}}
+	if !reflect.DeepEqual(expected, requests) {
+		t.Errorf("expected requests %v but got %v", expected, requests)
+	}
+}
+
+func TestDownloadShare(t *testing.T) {
+	counts := map[string]int{"a": 30, "b": 10}
+	tcs := []struct {
+		pkg          string
+		candidates   string
+		confidence   Confidence
+		alternatives []string
+	}{
+		{"a", "", 1, []string{}},
+		{"a", "b", 0.75, []string{"b"}},
+		{"b", "a", 0.25, []string{"a"}},
+		{"c", "d", 0.5, []string{"d"}},
+		{"c", "a", 0, []string{"a"}},
+	}
+	for _, tc := range tcs {
+		confidence, alternatives := DownloadShare(tc.pkg, tc.candidates, counts)
+		if confidence != tc.confidence || !reflect.DeepEqual(alternatives, tc.alternatives) {
+			t.Errorf("%s among %q: expected %v %v, got %v %v",
+				tc.pkg, tc.candidates, tc.confidence, tc.alternatives, confidence, alternatives)
+		}
+	}
+}
+
+func TestExplainImports(t *testing.T) {
+	imports := []ModuleImport{
+		{File: "a.rb", Line: 1, Module: "sinatra"},
+		{File: "a.rb", Line: 2, Module: "colorize"},
+		{File: "b.rb", Line: 3, Module: "colorize", Pragma: "colorized-fork"},
+		{File: "b.rb", Line: 4, Module: "app/models"},
+		{File: "b.rb", Line: 5, Module: "unknown"},
+	}
+	external := map[string]string{
+		"sinatra":  "",
+		"colorize": "colorized-fork",
+		"unknown":  "",
+	}
+	lookup := func(module string, pragma string) (GuessReason, bool) {
+		if pragma != "" {
+			return GuessReason{Package: PkgName(pragma), Module: module}, true
+		}
+		if module == "unknown" {
+			return GuessReason{}, false
+		}
+		return GuessReason{Package: PkgName(module), Module: module}, true
+	}
+
+	expected := []GuessReason{
+		{Package: "sinatra", File: "a.rb", Line: 1, Module: "sinatra"},
+		{Package: "colorized-fork", File: "b.rb", Line: 3, Module: "colorize"},
+	}
+	if reasons := ExplainImports(imports, external, lookup); !reflect.DeepEqual(reasons, expected) {
+		t.Errorf("expected %+v, got %+v", expected, reasons)
+	}
+}
@@ -21,8 +21,9 @@ import (
 // languageBackends is a slice of language backends which may be used
 // from the command line.
 //
-// If more than one backend might match the same project, then one
-// that comes first in this list will be used.
+// If more than one backend matches the same project equally well (see
+// scoreBackends), then the one that comes first in this list will be
+// used.
 var languageBackends = []api.LanguageBackend{
 	python.Python3Backend,
 	python.Python2Backend,
@@ -58,6 +59,9 @@ func matchesLanguage(b api.LanguageBackend, language string) bool {
 // GetBackend returns the language backend for a given --lang argument
 // value. If none is applicable, it exits the process.
 func GetBackend(language string) api.LanguageBackend {
+	if strings.Contains(language, ",") {
+		util.Die("only one language can be used with this command: %s", language)
+	}
 	backends := languageBackends
 	if language != "" {
 		filteredBackends := []api.LanguageBackend{}
@@ -76,29 +80,87 @@ func GetBackend(language string) api.LanguageBackend {
 		}
 
 	}
-	for _, b := range backends {
-		if util.Exists(b.Specfile) &&
-			util.Exists(b.Lockfile) {
-			return b
+	if b, ok := detectBackend(backends); ok {
+		return b
+	}
+	if language == "" {
+		util.Die("could not autodetect a language for your project")
+	}
+	return backends[0]
+}
+
+// GetApplicableBackends returns every language backend which applies
+// to the project in the current directory, at most one per language
+// (so a project with a package.json gets either nodejs-npm or
+// nodejs-yarn, chosen as by GetBackend). If any --lang argument
+// values are given, only backends matching one of them are
+// considered.
+func GetApplicableBackends(languages []string) []api.LanguageBackend {
+	// Group the backends by language, in the order of
+	// languageBackends.
+	langs := []string{}
+	backendsByLang := map[string][]api.LanguageBackend{}
+	for _, b := range languageBackends {
+		if len(languages) > 0 {
+			matches := false
+			for _, language := range languages {
+				if matchesLanguage(b, language) {
+					matches = true
+				}
+			}
+			if !matches {
+				continue
+			}
+		}
+		lang := strings.Split(b.Name, "-")[0]
+		if backendsByLang[lang] == nil {
+			langs = append(langs, lang)
 		}
+		backendsByLang[lang] = append(backendsByLang[lang], b)
 	}
-	for _, b := range backends {
-		if util.Exists(b.Specfile) ||
-			util.Exists(b.Lockfile) {
-			return b
+
+	backends := []api.LanguageBackend{}
+	for _, lang := range langs {
+		if b, ok := detectBackend(backendsByLang[lang]); ok {
+			backends = append(backends, b)
 		}
 	}
-	for _, b := range backends {
-		for _, p := range b.FilenamePatterns {
-			if util.PatternExists(p) {
-				return b
-			}
+	return backends
+}
+
+// GetBackends returns the language backends for a given --lang
+// argument value, which may be a comma-separated list of languages.
+// Each one is chosen as by GetBackend. If all is true (for
+// --all-languages), then it instead returns every language backend
+// that applies to the project, as by GetApplicableBackends. If there
+// are none, it exits the process.
+func GetBackends(language string, all bool) []api.LanguageBackend {
+	languages := []string{}
+	if language != "" {
+		languages = strings.Split(language, ",")
+	}
+
+	if all {
+		backends := GetApplicableBackends(languages)
+		if len(backends) == 0 {
+			util.Die("could not autodetect any languages for your project")
 		}
+		return backends
 	}
-	if language == "" {
-		util.Die("could not autodetect a language for your project")
+
+	if len(languages) == 0 {
+		return []api.LanguageBackend{GetBackend("")}
 	}
-	return backends[0]
+	backends := []api.LanguageBackend{}
+	seen := map[string]bool{}
+	for _, language := range languages {
+		b := GetBackend(language)
+		if !seen[b.Name] {
+			seen[b.Name] = true
+			backends = append(backends, b)
+		}
+	}
+	return backends
 }
 
 // GetBackendNames returns a slice of the canonical names (e.g.
@@ -112,6 +174,24 @@ func GetBackendNames() []string {
 	return backendNames
 }
 
+// GetRegistryBackends returns one language backend for each
+// distinct online package index, for commands like search that don't
+// depend on the project. Backends for the same language (such as
+// nodejs-npm and nodejs-yarn) share an index, so only the one that
+// comes first in languageBackends is included.
+func GetRegistryBackends() []api.LanguageBackend {
+	backends := []api.LanguageBackend{}
+	seen := map[string]bool{}
+	for _, b := range languageBackends {
+		lang := strings.Split(b.Name, "-")[0]
+		if !seen[lang] {
+			seen[lang] = true
+			backends = append(backends, b)
+		}
+	}
+	return backends
+}
+
 // SetupAll panics if any registered language backend does not
 // implement its mandatory fields. It also assigns defaults for all
 // registered language backends.
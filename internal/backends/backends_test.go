@@ -1,9 +1,11 @@
 package backends
 
 import (
+	"errors"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -18,6 +20,8 @@ func TestGetBackends(t *testing.T) {
 		"Cargo.toml":     "rust",
 	}
 
+	defer stubLookPath(nil)()
+
 	dir, err := ioutil.TempDir("", "TestGetBackends")
 	if err != nil {
 		t.Errorf("failed to create a temp directory %v", err)
@@ -42,3 +46,176 @@ func TestGetBackends(t *testing.T) {
 		os.Remove(tmpfile)
 	}
 }
+
+func TestGetApplicableBackends(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestGetApplicableBackends")
+	if err != nil {
+		t.Errorf("failed to create a temp directory %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, file := range []string{"package.json", "yarn.lock", "Cargo.toml"} {
+		tmpfile := filepath.Join(dir, file)
+		if err := ioutil.WriteFile(tmpfile, []byte{}, 0666); err != nil {
+			t.Errorf("failed to create empty file: %s err: %v", tmpfile, err)
+		}
+	}
+
+	if err := os.Chdir(dir); err != nil {
+		t.Errorf("failed to change to directory: %s err: %v", dir, err)
+	}
+
+	expected := []string{"nodejs-yarn", "rust"}
+	actual := []string{}
+	for _, b := range GetApplicableBackends(nil) {
+		actual = append(actual, b.Name)
+	}
+	if strings.Join(actual, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected backends: %v but got backends %v", expected, actual)
+	}
+
+	actual = []string{}
+	for _, b := range GetApplicableBackends([]string{"rust"}) {
+		actual = append(actual, b.Name)
+	}
+	if strings.Join(actual, ",") != "rust" {
+		t.Errorf("expected backends: [rust] but got backends %v", actual)
+	}
+}
+
+func TestGetApplicableBackendsWithoutSpecfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestGetApplicableBackendsWithoutSpecfiles")
+	if err != nil {
+		t.Errorf("failed to create a temp directory %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Only source files, for languages with a single backend.
+	for _, file := range []string{"main.rs", "a.R"} {
+		tmpfile := filepath.Join(dir, file)
+		if err := ioutil.WriteFile(tmpfile, []byte{}, 0666); err != nil {
+			t.Errorf("failed to create empty file: %s err: %v", tmpfile, err)
+		}
+	}
+
+	if err := os.Chdir(dir); err != nil {
+		t.Errorf("failed to change to directory: %s err: %v", dir, err)
+	}
+
+	defer stubLookPath(nil)()
+	expected := []string{"rlang", "rust"}
+	actual := []string{}
+	for _, b := range GetApplicableBackends(nil) {
+		actual = append(actual, b.Name)
+	}
+	if strings.Join(actual, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected backends: %v but got backends %v", expected, actual)
+	}
+}
+
+func TestFindWorkspaceProjects(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestFindWorkspaceProjects")
+	if err != nil {
+		t.Errorf("failed to create a temp directory %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := []string{
+		"package.json",
+		"package-lock.json",
+		"api/pyproject.toml",
+		"api/main.py",
+		"web/package.json",
+		"web/node_modules/left-pad/package.json",
+		"tools/cli/Cargo.toml",
+		"tools/App.csproj",
+		"docs/Gemfile",
+	}
+	for _, file := range files {
+		tmpfile := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(tmpfile), 0777); err != nil {
+			t.Errorf("failed to create directory for: %s err: %v", tmpfile, err)
+		}
+		if err := ioutil.WriteFile(tmpfile, []byte{}, 0666); err != nil {
+			t.Errorf("failed to create empty file: %s err: %v", tmpfile, err)
+		}
+	}
+
+	if err := os.Chdir(dir); err != nil {
+		t.Errorf("failed to change to directory: %s err: %v", dir, err)
+	}
+
+	expected := []string{
+		".: package-lock.json package.json",
+		"api: pyproject.toml",
+		"tools: App.csproj",
+		"tools/cli: Cargo.toml",
+		"web: package.json",
+	}
+	actual := []string{}
+	for _, p := range FindWorkspaceProjects() {
+		actual = append(actual, p.Dir+": "+strings.Join(p.Files, " "))
+	}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected projects: %q but got projects %q", expected, actual)
+	}
+}
+
+// stubLookPath makes lookPath find every program except those in
+// missing, and returns a function that restores it.
+func stubLookPath(missing []string) func() {
+	orig := lookPath
+	lookPath = func(file string) (string, error) {
+		for _, m := range missing {
+			if file == m {
+				return "", errors.New("not found")
+			}
+		}
+		return "/usr/bin/" + file, nil
+	}
+	return func() { lookPath = orig }
+}
+
+func TestDetectBackend(t *testing.T) {
+	cases := []struct {
+		files    []string
+		missing  []string
+		expected string
+	}{
+		{[]string{"a.js", "b.js", "lib/c.js", "setup.py"}, nil, "nodejs-npm"},
+		{[]string{"package.json", "scripts/build.py"}, nil, "nodejs-npm"},
+		{[]string{"package.json"}, []string{"npm"}, "nodejs-yarn"},
+		{[]string{"package.json", "yarn.lock"}, []string{"yarn"}, "nodejs-yarn"},
+		{[]string{"main.py", "node_modules/x/a.js", "node_modules/x/b.js"}, nil, "python-python3-poetry"},
+		{[]string{"package.json", "yarn.lock", "a.py", "b.py", "c.py", "d.py", "e.py", "f.py"}, nil, "nodejs-yarn"},
+	}
+
+	for _, c := range cases {
+		dir, err := ioutil.TempDir("", "TestDetectBackend")
+		if err != nil {
+			t.Errorf("failed to create a temp directory %v", err)
+		}
+		for _, file := range c.files {
+			tmpfile := filepath.Join(dir, file)
+			if err := os.MkdirAll(filepath.Dir(tmpfile), 0777); err != nil {
+				t.Errorf("failed to create directory for: %s err: %v", tmpfile, err)
+			}
+			if err := ioutil.WriteFile(tmpfile, []byte{}, 0666); err != nil {
+				t.Errorf("failed to create empty file: %s err: %v", tmpfile, err)
+			}
+		}
+		if err := os.Chdir(dir); err != nil {
+			t.Errorf("failed to change to directory: %s err: %v", dir, err)
+		}
+
+		restore := stubLookPath(c.missing)
+		actual, ok := detectBackend(languageBackends)
+		restore()
+		if !ok {
+			t.Errorf("%v: expected backend: %s but got none", c.files, c.expected)
+		} else if actual.Name != c.expected {
+			t.Errorf("%v: expected backend: %s but got backend %s", c.files, c.expected, actual.Name)
+		}
+		os.RemoveAll(dir)
+	}
+}
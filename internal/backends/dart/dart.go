@@ -11,6 +11,9 @@ import (
 	"os"
 	"path"
 	"runtime"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/replit/upm/internal/api"
 	"github.com/replit/upm/internal/util"
@@ -117,7 +120,24 @@ type pubDevSearchResults struct {
 
 // dartSearch implements Search for Pub.dev.
 func dartSearch(query string) []api.PkgInfo {
-	endpoint := fmt.Sprintf("%s/api/search/?q=%s", getPubBaseURL(), url.QueryEscape(query))
+	return dartSearchPage(query, 1)
+}
+
+// pubDevPageSize is the number of results Pub.dev returns for each
+// page of search results.
+const pubDevPageSize = 10
+
+// dartSearchPaged implements SearchPaged for Pub.dev.
+func dartSearchPaged(query string, offset int, limit int) []api.PkgInfo {
+	return api.FetchPages(offset, limit, pubDevPageSize, func(page int) []api.PkgInfo {
+		return dartSearchPage(query, page)
+	})
+}
+
+// dartSearchPage returns the given page (starting from 1) of search
+// results from Pub.dev.
+func dartSearchPage(query string, page int) []api.PkgInfo {
+	endpoint := fmt.Sprintf("%s/api/search/?q=%s&page=%d", getPubBaseURL(), url.QueryEscape(query), page)
 
 	req, err := http.NewRequest("GET", endpoint, nil)
 	if err != nil {
@@ -156,21 +176,46 @@ func dartSearch(query string) []api.PkgInfo {
 // pubDevInfoResults represents the data we get from Pub.dev when
 // calling /api/packages/[package identifier].
 type pubDevInfoResults struct {
-	Name   string `json:"name"`
-	Latest struct {
-		ArchiveURL string `json:"archive_url"`
-		Pubspec    struct {
-			Version     string `json:"version"`
-			Author      string `json:"author"`
-			Description string `json:"description"`
-			Homepage    string `json:"homepage"`
-		} `json:"pubspec"`
-	} `json:"latest"`
-	Version string `json:"version"`
+	Name           string          `json:"name"`
+	IsDiscontinued bool            `json:"isDiscontinued"`
+	Latest         pubDevVersion   `json:"latest"`
+	Versions       []pubDevVersion `json:"versions"`
+	Version        string          `json:"version"`
 }
 
-// dartInfo implements Info for Pub.dev.
-func dartInfo(name api.PkgName) api.PkgInfo {
+// pubDevVersion represents the data we get from Pub.dev about one
+// version of a package, as part of pubDevInfoResults.
+type pubDevVersion struct {
+	Version    string `json:"version"`
+	ArchiveURL string `json:"archive_url"`
+	Published  string `json:"published"`
+	Retracted  bool   `json:"retracted"`
+	Pubspec    struct {
+		Version      string `json:"version"`
+		Author       string `json:"author"`
+		Description  string `json:"description"`
+		Homepage     string `json:"homepage"`
+		Repository   string `json:"repository"`
+		IssueTracker string `json:"issue_tracker"`
+		// The values are version constraints, which can be
+		// strings or maps.
+		Dependencies map[string]interface{} `json:"dependencies"`
+	} `json:"pubspec"`
+}
+
+// publishedAt parses the publication time of the version, returning
+// nil if it is missing or malformed.
+func (v pubDevVersion) publishedAt() *time.Time {
+	t, err := time.Parse(time.RFC3339, v.Published)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+// dartFetchInfo retrieves the metadata for all versions of a package
+// from Pub.dev.
+func dartFetchInfo(name api.PkgName) pubDevInfoResults {
 	endpoint := fmt.Sprintf("%s/api/packages/%s", getPubBaseURL(), name)
 
 	req, err := http.NewRequest("GET", endpoint, nil)
@@ -196,20 +241,70 @@ func dartInfo(name api.PkgName) api.PkgInfo {
 	if err := json.Unmarshal(body, &pubDevResults); err != nil {
 		util.Die("Pub.dev: %s", err)
 	}
+	return pubDevResults
+}
+
+// toPkgInfo converts the metadata for the given version of the
+// package into the format used by UPM.
+func (pubDevResults *pubDevInfoResults) toPkgInfo(v pubDevVersion) api.PkgInfo {
+	// Pub.dev lists versions from oldest to newest.
+	versions := api.PkgVersions{}
+	for _, other := range pubDevResults.Versions {
+		versions = append(versions, api.PkgVersionInfo{
+			Version:     other.Version,
+			PublishedAt: other.publishedAt(),
+			// Dart uses semver, where prereleases have a
+			// suffix.
+			Prerelease: strings.Contains(strings.SplitN(other.Version, "+", 2)[0], "-"),
+			Yanked:     other.Retracted,
+		})
+	}
+
+	deps := []string{}
+	for dep := range v.Pubspec.Dependencies {
+		deps = append(deps, dep)
+	}
+	sort.Strings(deps)
 
 	return api.PkgInfo{
 		Name:          pubDevResults.Name,
-		Description:   pubDevResults.Latest.Pubspec.Description,
-		Version:       pubDevResults.Version,
-		HomepageURL:   pubDevResults.Latest.Pubspec.Homepage,
-		SourceCodeURL: "",
-		BugTrackerURL: "",
+		Description:   v.Pubspec.Description,
+		Version:       v.Version,
+		HomepageURL:   v.Pubspec.Homepage,
+		SourceCodeURL: v.Pubspec.Repository,
+		BugTrackerURL: v.Pubspec.IssueTracker,
 		Author: util.AuthorInfo{
-			Name:  pubDevResults.Latest.Pubspec.Author,
+			Name:  v.Pubspec.Author,
 			Email: "",
 			URL:   "",
 		}.String(),
-		License: ""}
+		License:      "",
+		Dependencies: deps,
+		PublishedAt:  v.publishedAt(),
+		Deprecated:   pubDevResults.IsDiscontinued,
+		Yanked:       v.Retracted,
+		Versions:     versions,
+	}
+}
+
+// dartInfo implements Info for Pub.dev.
+func dartInfo(name api.PkgName) api.PkgInfo {
+	pubDevResults := dartFetchInfo(name)
+	if pubDevResults.Name == "" {
+		return api.PkgInfo{}
+	}
+	return pubDevResults.toPkgInfo(pubDevResults.Latest)
+}
+
+// dartVersionInfo implements VersionInfo for Pub.dev.
+func dartVersionInfo(name api.PkgName, version api.PkgVersion) api.PkgInfo {
+	pubDevResults := dartFetchInfo(name)
+	for _, v := range pubDevResults.Versions {
+		if v.Version == string(version) {
+			return pubDevResults.toPkgInfo(v)
+		}
+	}
+	return api.PkgInfo{}
 }
 
 func createSpecFile() {
@@ -279,23 +374,19 @@ func dartRemove(pkgs map[api.PkgName]bool) {
 	writeSpecFile(specs)
 }
 
-// dartGuess stub.
-func dartGuess() (map[api.PkgName]bool, bool) {
-	util.Die("Guess not implemented!")
-
-	return nil, false
-}
-
 // DartPubBackend is a UPM backend for Dart that uses Pub.dev.
 var DartPubBackend = api.LanguageBackend{
 	Name:             "dart-pub",
 	Specfile:         "pubspec.yaml",
 	Lockfile:         "pubspec.lock",
-	FilenamePatterns: []string{"*.dart"},
+	FilenamePatterns: dartPatterns,
+	Executables:      []string{"pub"},
 	Quirks:           api.QuirksLockAlsoInstalls,
 	GetPackageDir:    dartGetPackageDir,
 	Search:           dartSearch,
+	SearchPaged:      dartSearchPaged,
 	Info:             dartInfo,
+	VersionInfo:      dartVersionInfo,
 	Add:              dartAdd,
 	Remove:           dartRemove,
 	Lock: func() {
@@ -304,8 +395,9 @@ var DartPubBackend = api.LanguageBackend{
 	Install: func() {
 		util.RunCmd([]string{"pub", "get"})
 	},
-	ListSpecfile: dartListPubspecYaml,
-	ListLockfile: dartListPubspecLock,
-	GuessRegexps: nil,
-	Guess:        dartGuess,
+	ListSpecfile:    dartListPubspecYaml,
+	ListLockfile:    dartListPubspecLock,
+	GuessRegexps:    dartGuessRegexps,
+	GuessExtraInput: dartGuessExtraInput,
+	GuessExplain:    dartGuessExplain,
 }
@@ -0,0 +1,239 @@
+package dart
+
+import (
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// dartPatterns is the FilenamePatterns value for DartPubBackend.
+var dartPatterns = []string{"*.dart"}
+
+// dartGuessRegexps is the GuessRegexps value for DartPubBackend. It
+// matches the package name of every "package:" URI.
+var dartGuessRegexps = util.Regexps([]string{
+	`['"]package:([a-zA-Z_]\w*)/`,
+})
+
+// dartDirectives are the keywords that begin a directive, which must
+// all come before any declaration in a Dart library.
+var dartDirectives = map[string]bool{
+	"library": true,
+	"import":  true,
+	"export":  true,
+	"part":    true,
+}
+
+// dartSDKPackages are packages that come with the Flutter SDK and are
+// depended on with "sdk: flutter" rather than from pub.dev.
+var dartSDKPackages = map[string]bool{
+	"flutter":               true,
+	"flutter_driver":        true,
+	"flutter_localizations": true,
+	"flutter_test":          true,
+	"flutter_web_plugins":   true,
+	"integration_test":      true,
+}
+
+// dartPackageURIRegexp matches a "package:" URI, capturing the package
+// name.
+var dartPackageURIRegexp = regexp.MustCompile(`^package:([a-zA-Z_]\w*)/`)
+
+// dartImport is a package imported by a directive, as found by
+// findPackageImports.
+type dartImport struct {
+	// The package name, e.g. "http".
+	pkg string
+
+	// The URI it was imported with, e.g.
+	// "package:http/http.dart".
+	uri string
+
+	// The 1-based line of the directive.
+	line int
+}
+
+// dartStringLiteral returns the contents of the string literal at the
+// start of src, and its length, or -1 if there isn't one. Escape
+// sequences are left as they are, which doesn't matter for URIs.
+func dartStringLiteral(src string) (string, int) {
+	i := 0
+	raw := false
+	if strings.HasPrefix(src, "r") {
+		raw = true
+		i++
+	}
+	if i == len(src) || (src[i] != '\'' && src[i] != '"') {
+		return "", -1
+	}
+	quote := src[i : i+1]
+	if strings.HasPrefix(src[i:], quote+quote+quote) {
+		quote = quote + quote + quote
+	}
+	i += len(quote)
+	start := i
+	for i < len(src) && !strings.HasPrefix(src[i:], quote) {
+		if src[i] == '\\' && !raw {
+			i++
+		}
+		i++
+	}
+	if i >= len(src) {
+		return src[start:], len(src)
+	}
+	return src[start:i], i + len(quote)
+}
+
+// findPackageImports returns the packages imported by the import,
+// export and part directives of Dart source code, in the order they
+// appear, including those in the alternatives of conditional imports.
+// Only the directives at the start of the file are read, since
+// directives can't come after declarations.
+func findPackageImports(src string) []dartImport {
+	imports := []dartImport{}
+	i := 0
+	if strings.HasPrefix(src, "#!") {
+		if end := strings.IndexByte(src, '\n'); end != -1 {
+			i = end
+		} else {
+			i = len(src)
+		}
+	}
+
+	for {
+		// Block comments nest in Dart.
+		i = util.SkipCTrivia(src, i, true)
+		if i == len(src) {
+			break
+		}
+
+		// Metadata annotations, like @deprecated or
+		// @TestOn('vm'), may come before directives.
+		if src[i] == '@' {
+			i++
+			for i < len(src) && (isDartNameByte(src[i]) || src[i] == '.') {
+				i++
+			}
+			i = util.SkipCTrivia(src, i, true)
+			if i < len(src) && src[i] == '(' {
+				i = skipDartDirective(src, i, ")", nil)
+			}
+			continue
+		}
+
+		start := i
+		for i < len(src) && isDartNameByte(src[i]) {
+			i++
+		}
+		if !dartDirectives[src[start:i]] {
+			break
+		}
+
+		line := 1 + strings.Count(src[:start], "\n")
+		i = skipDartDirective(src, i, ";", func(uri string) {
+			if m := dartPackageURIRegexp.FindStringSubmatch(uri); m != nil {
+				imports = append(imports, dartImport{pkg: m[1], uri: uri, line: line})
+			}
+		})
+	}
+	return imports
+}
+
+// skipDartDirective returns the offset just after the given
+// terminator, starting at i in src, skipping over comments and string
+// literals. If visit is non-nil, it is called with the contents of
+// each string literal.
+func skipDartDirective(src string, i int, terminator string, visit func(string)) int {
+	for i < len(src) {
+		i = util.SkipCTrivia(src, i, true)
+		if i == len(src) {
+			break
+		}
+		if strings.HasPrefix(src[i:], terminator) {
+			return i + len(terminator)
+		}
+		// Don't mistake the end of a name like "bar" for a raw
+		// string prefix.
+		if i == 0 || !isDartNameByte(src[i-1]) {
+			if str, n := dartStringLiteral(src[i:]); n != -1 {
+				if visit != nil {
+					visit(str)
+				}
+				i += n
+				continue
+			}
+		}
+		i++
+	}
+	return i
+}
+
+// isDartNameByte returns true if c can be part of a Dart identifier,
+// which may contain "$". Dart only allows ASCII in identifiers, but
+// other bytes can only be in the strings and comments that the lexer
+// skips, so util.IsIdentByte accepting them doesn't matter.
+func isDartNameByte(c byte) bool {
+	return c == '$' || util.IsIdentByte(c)
+}
+
+// dartGuessExtraInput is the GuessExtraInput value for
+// DartPubBackend, since imports of the project's own package are
+// left out.
+func dartGuessExtraInput() []byte {
+	return []byte(dartOwnPackage())
+}
+
+// dartOwnPackage returns the name of the project's own package from
+// pubspec.yaml, or the empty string if there is none.
+func dartOwnPackage() string {
+	if !util.Exists("pubspec.yaml") {
+		return ""
+	}
+	return readSpecFile().Name
+}
+
+// dartFileImports returns the package imports of each project file,
+// leaving out the project's own package and SDK packages.
+func dartFileImports() (files []string, imports map[string][]dartImport) {
+	own := dartOwnPackage()
+	files = util.ListFilesRecursive(dartPatterns)
+	imports = map[string][]dartImport{}
+	for _, file := range files {
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			util.Die("%s: %s", file, err)
+		}
+		for _, imp := range findPackageImports(string(contents)) {
+			if imp.pkg == own || dartSDKPackages[imp.pkg] {
+				continue
+			}
+			imports[file] = append(imports[file], imp)
+		}
+	}
+	return files, imports
+}
+
+// dartGuessExplain implements GuessExplain for DartPubBackend. There is
+// a reason for every directive that imports a package.
+func dartGuessExplain() []api.GuessReason {
+	files, imports := dartFileImports()
+	reasons := []api.GuessReason{}
+	for _, file := range files {
+		for _, imp := range imports[file] {
+			// Pub resolves a package: URI from the package
+			// named by its first segment and nowhere else.
+			reasons = append(reasons, api.GuessReason{
+				Package:    api.PkgName(imp.pkg),
+				File:       file,
+				Line:       imp.line,
+				Module:     imp.uri,
+				Source:     "import-uri",
+				Confidence: 1,
+			})
+		}
+	}
+	return reasons
+}
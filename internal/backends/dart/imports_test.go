@@ -0,0 +1,78 @@
+package dart
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindPackageImports(t *testing.T) {
+	tcs := []struct {
+		scenario string
+		src      string
+		expected []dartImport
+	}{
+		{
+			scenario: "Returns the packages of import, export and part directives",
+			src: `import 'package:http/http.dart' as http;
+import "package:path/path.dart" show join;
+export 'package:collection/collection.dart';
+part 'src/part.dart';
+import 'dart:io';
+import 'src/local.dart';
+`,
+			expected: []dartImport{
+				{pkg: "http", uri: "package:http/http.dart", line: 1},
+				{pkg: "path", uri: "package:path/path.dart", line: 2},
+				{pkg: "collection", uri: "package:collection/collection.dart", line: 3},
+			},
+		},
+		{
+			scenario: "Returns every alternative of a conditional import",
+			src: `import 'src/stub.dart'
+    if (dart.library.io) 'package:io_impl/io.dart'
+    if (dart.library.html) 'package:web_impl/web.dart';
+`,
+			expected: []dartImport{
+				{pkg: "io_impl", uri: "package:io_impl/io.dart", line: 1},
+				{pkg: "web_impl", uri: "package:web_impl/web.dart", line: 1},
+			},
+		},
+		{
+			scenario: "Skips comments, annotations and the library directive",
+			src: `#!/usr/bin/env dart
+// import 'package:commented/out.dart';
+/* /* nested */ import 'package:also/commented.dart'; */
+@TestOn('vm')
+library my.lib;
+
+import 'package:test/test.dart';
+`,
+			expected: []dartImport{
+				{pkg: "test", uri: "package:test/test.dart", line: 7},
+			},
+		},
+		{
+			scenario: "Stops at the first declaration",
+			src: `import 'package:a/a.dart';
+
+void main() {
+  print("import 'package:b/b.dart';");
+}
+
+import 'package:c/c.dart';
+`,
+			expected: []dartImport{
+				{pkg: "a", uri: "package:a/a.dart", line: 1},
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.scenario, func(t *testing.T) {
+			imports := findPackageImports(tc.src)
+			if !reflect.DeepEqual(imports, tc.expected) {
+				t.Errorf("expected %+v, got %+v", tc.expected, imports)
+			}
+		})
+	}
+}
@@ -0,0 +1,269 @@
+package backends
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// Points awarded to a language backend by scoreBackends for each kind
+// of evidence that it applies to the project. They are chosen so that
+// a specfile or lockfile always outweighs any number of source files,
+// and source files always outweigh which programs are installed.
+const (
+	specfileScore = 10
+	lockfileScore = 10
+
+	// fileScore is awarded for each file matching one of the
+	// FilenamePatterns, up to maxFileScore in total.
+	fileScore    = 1
+	maxFileScore = 5
+
+	// executablesScore is awarded if all the Executables can be
+	// found on the PATH, but only to a backend that has some
+	// other evidence.
+	executablesScore = 2
+)
+
+// lookPath is exec.LookPath. It is a variable so that tests can
+// control which programs appear to be installed.
+var lookPath = exec.LookPath
+
+// Evidence is one reason why a language backend was thought to apply
+// to the project, as reported by 'upm which-language --explain'.
+type Evidence struct {
+	// Description says what was found, e.g. "specfile
+	// package.json exists".
+	Description string
+
+	// Score is the number of points awarded for it.
+	Score int
+}
+
+// Detection is the result of scoring one language backend against the
+// project in the current directory.
+type Detection struct {
+	Backend api.LanguageBackend
+
+	// Score is the total of the scores of the Evidence. A
+	// backend with a score of zero doesn't apply to the project.
+	Score int
+
+	Evidence []Evidence
+}
+
+// errStopWalk is returned from a filepath.WalkFunc to end the walk
+// early.
+var errStopWalk = errors.New("stop walk")
+
+// countFiles returns, for each of the given language backends, the
+// number of files in the current directory and its subdirectories
+// that match its FilenamePatterns, subject to util.IgnoredPaths.
+// Counting stops at maxFileScore / fileScore, since more files
+// wouldn't change the score. Directories that can't be read are
+// skipped.
+func countFiles(backends []api.LanguageBackend) []int {
+	counts := make([]int, len(backends))
+	maxCount := maxFileScore / fileScore
+	filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			if path == "." {
+				return nil
+			}
+			for _, name := range util.IgnoredPaths {
+				if filepath.Base(path) == name {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+
+		done := true
+		for i, b := range backends {
+			if counts[i] < maxCount {
+				for _, pattern := range b.FilenamePatterns {
+					matched, err := filepath.Match(pattern, info.Name())
+					if err != nil {
+						panic(err)
+					}
+					if matched {
+						counts[i]++
+						break
+					}
+				}
+			}
+			if counts[i] < maxCount {
+				done = false
+			}
+		}
+		if done {
+			return errStopWalk
+		}
+		return nil
+	})
+	return counts
+}
+
+// addEvidence adds a piece of evidence to a detection.
+func (d *Detection) addEvidence(score int, format string, a ...interface{}) {
+	d.Evidence = append(d.Evidence, Evidence{
+		Description: fmt.Sprintf(format, a...),
+		Score:       score,
+	})
+	d.Score += score
+}
+
+// scoreSpecfiles scores a language backend by whether its specfile
+// and lockfile exist, which is cheap to find out.
+func scoreSpecfiles(b api.LanguageBackend) Detection {
+	d := Detection{Backend: b}
+	if util.Exists(b.Specfile) {
+		d.addEvidence(specfileScore, "specfile %s exists", b.Specfile)
+	}
+	if b.Lockfile != b.Specfile && util.Exists(b.Lockfile) {
+		d.addEvidence(lockfileScore, "lockfile %s exists", b.Lockfile)
+	}
+	return d
+}
+
+// scoreFiles adds the score for the number of files matching the
+// backend's FilenamePatterns, as counted by countFiles.
+func (d *Detection) scoreFiles(count int) {
+	if count == 0 {
+		return
+	}
+	noun := "files"
+	if count == 1 {
+		noun = "file"
+	}
+	more := ""
+	if count*fileScore >= maxFileScore {
+		more = " or more"
+	}
+	d.addEvidence(count*fileScore, "%d%s %s matching %s",
+		count, more, noun, strings.Join(d.Backend.FilenamePatterns, ", "))
+}
+
+// scoreExecutables adds the score for the backend's Executables being
+// installed, if it already has some other evidence.
+func (d *Detection) scoreExecutables() {
+	if d.Score == 0 || len(d.Backend.Executables) == 0 {
+		return
+	}
+	for _, exe := range d.Backend.Executables {
+		if _, err := lookPath(exe); err != nil {
+			return
+		}
+	}
+	d.addEvidence(executablesScore, "%s found on PATH",
+		strings.Join(d.Backend.Executables, ", "))
+}
+
+// settled returns true if one of the detections scored only by
+// scoreSpecfiles is sure to stay the best, whatever files and
+// executables are found. A best score of zero is never settled, even
+// with a single detection, since the files may be all the evidence
+// there is.
+func settled(detections []Detection) bool {
+	if len(detections) == 0 {
+		return true
+	}
+	best := 0
+	for i, d := range detections {
+		if d.Score > detections[best].Score {
+			best = i
+		}
+	}
+	if detections[best].Score == 0 {
+		return false
+	}
+	for i, d := range detections {
+		if i != best && detections[best].Score <= d.Score+maxFileScore+executablesScore {
+			return false
+		}
+	}
+	return true
+}
+
+// scoreBackends scores each of the given language backends against
+// the project in the current directory, and returns the results in
+// the same order. If all is false, the files in the project are only
+// counted if the specfiles and lockfiles don't settle which backend
+// is best, so the scores may be incomplete.
+func scoreBackends(backends []api.LanguageBackend, all bool) []Detection {
+	detections := []Detection{}
+	for _, b := range backends {
+		detections = append(detections, scoreSpecfiles(b))
+	}
+	if all || !settled(detections) {
+		for i, count := range countFiles(backends) {
+			detections[i].scoreFiles(count)
+		}
+	}
+	for i := range detections {
+		detections[i].scoreExecutables()
+	}
+	return detections
+}
+
+// rankDetections sorts detections by decreasing score. Ties are kept
+// in their original order.
+func rankDetections(detections []Detection) {
+	sort.SliceStable(detections, func(i, j int) bool {
+		return detections[i].Score > detections[j].Score
+	})
+}
+
+// detectBackend returns the one of the given language backends which
+// best applies to the project in the current directory, according to
+// scoreBackends. If there is a tie, the one that comes first wins. The
+// second return value is false if none of them apply.
+func detectBackend(backends []api.LanguageBackend) (api.LanguageBackend, bool) {
+	detections := scoreBackends(backends, false)
+	rankDetections(detections)
+	if len(detections) == 0 || detections[0].Score == 0 {
+		return api.LanguageBackend{}, false
+	}
+	return detections[0].Backend, true
+}
+
+// ExplainDetection returns the scores of every language backend
+// matching a given --lang argument value, which may be a
+// comma-separated list of languages (or of every backend, if it is
+// empty), against the project in the current directory, with the
+// best first. Backends that don't apply at all are left out.
+func ExplainDetection(language string) []Detection {
+	backends := []api.LanguageBackend{}
+	for _, b := range languageBackends {
+		matches := language == ""
+		for _, l := range strings.Split(language, ",") {
+			if l != "" && matchesLanguage(b, l) {
+				matches = true
+			}
+		}
+		if matches {
+			backends = append(backends, b)
+		}
+	}
+	detections := []Detection{}
+	for _, d := range scoreBackends(backends, true) {
+		if d.Score > 0 {
+			detections = append(detections, d)
+		}
+	}
+	rankDetections(detections)
+	return detections
+}
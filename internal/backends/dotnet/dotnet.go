@@ -6,18 +6,25 @@ import (
 	"github.com/replit/upm/internal/util"
 )
 
+// this generates a mapping of namespaces -> nuget packages
+// namespaceToPackage namespaceToPackageCandidates packageToDownloads frameworkNamespaces are provided
+//go:generate go run ./gen_nuget_map -from nuget_index.json -pkg dotnet -out nuget_map.gen.go
+
 // DotNetBackend is the UPM language backend .NET languages with support for C#
 var DotNetBackend = api.LanguageBackend{
 	Name:             "dotnet",
 	Specfile:         findSpecFile(),
 	Lockfile:         lockFileName,
 	FilenamePatterns: []string{"*.cs", "*.csproj", "*.fs", "*.fsproj"},
+	Executables:      []string{"dotnet"},
 	Remove:           func(pkgs map[api.PkgName]bool) { removePackages(pkgs, findSpecFile(), util.RunCmd) },
 	Add: func(pkgs map[api.PkgName]api.PkgSpec, projectName string) {
 		addPackages(pkgs, projectName, util.RunCmd)
 	},
 	Search:       search,
+	SearchPaged:  searchPaged,
 	Info:         info,
+	VersionInfo:  versionInfo,
 	Install:      func() { install(util.RunCmd) },
 	Lock:         func() { lock(util.RunCmd) },
 	ListSpecfile: listSpecfile,
@@ -28,4 +35,6 @@ var DotNetBackend = api.LanguageBackend{
 	Quirks: api.QuirksAddRemoveAlsoLocks |
 		api.QuirksAddRemoveAlsoInstalls |
 		api.QuirksLockAlsoInstalls,
+	GuessRegexps: dotnetGuessRegexps,
+	GuessExplain: dotnetGuessExplain,
 }
@@ -0,0 +1,124 @@
+// This command generates go source holding a mapping of:
+// namespaces -> most likely nuget package
+// namespaces -> other packages providing them
+// packages -> downloads
+// and
+// target frameworks -> the namespaces they provide
+//
+// these are provided as the maps namespaceToPackage,
+// namespaceToPackageCandidates, packageToDownloads and frameworkNamespaces
+// respectively.
+//
+// The input is a NuGet namespace index dump with one JSON object per line.
+// Most lines are packages, e.g.
+// {"p":"Newtonsoft.Json","n":["Newtonsoft.Json","Newtonsoft.Json.Linq"],"d":1000}
+// giving the package ID, the namespaces of the public types in the
+// assemblies of its latest version, and its downloads. The other lines are
+// the reference assemblies of target frameworks, e.g.
+// {"f":"net8.0","n":["System","System.Text.Json"]}
+// and of shared frameworks which can be referenced on top of them, e.g.
+// {"f":"net8.0","r":"Microsoft.AspNetCore.App","n":["Microsoft.AspNetCore.Mvc"]}
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/replit/upm/internal/genmap"
+)
+
+type indexEntry struct {
+	Package    string   `json:"p"`
+	Framework  string   `json:"f"`
+	Reference  string   `json:"r"`
+	Namespaces []string `json:"n"`
+	Downloads  int      `json:"d"`
+}
+
+// exactMatch returns true if the namespace is under the package ID,
+// following the NuGet naming conventions, e.g. "Newtonsoft.Json.Linq" for
+// "Newtonsoft.Json".
+func exactMatch(pkg string, ns string) bool {
+	return strings.EqualFold(ns, pkg) || strings.HasPrefix(strings.ToLower(ns), strings.ToLower(pkg)+".")
+}
+
+func main() {
+	from, pkg, out := genmap.Flags()
+
+	entries := []*indexEntry{}
+	genmap.ReadIndex(from, func() interface{} {
+		e := &indexEntry{}
+		entries = append(entries, e)
+		return e
+	})
+
+	namespaces := genmap.Candidates{}
+	frameworks := map[string][]string{}
+	for _, e := range entries {
+		if e.Framework != "" {
+			key := e.Framework
+			if e.Reference != "" {
+				key += "+" + e.Reference
+			}
+			frameworks[key] = append(frameworks[key], e.Namespaces...)
+			continue
+		}
+
+		p := &genmap.Package{Name: e.Package, Count: e.Downloads}
+		for _, ns := range e.Namespaces {
+			namespaces.Add(ns, p)
+		}
+	}
+	sortedNamespaces := namespaces.Rank()
+
+	w := genmap.NewWriter(pkg)
+
+	chosen := w.ChosenMap("namespaceToPackage", `
+namespaceToPackage holds a map of all known namespaces to their
+corresponding best matching nuget package. This helps us guess which
+packages should be added for the given using directives.
+`, namespaces, sortedNamespaces, genmap.Rules{
+		Match:        exactMatch,
+		MatchComment: "exact match",
+		MinCount:     1000,
+		Noun:         "package",
+		CountNoun:    "download",
+		CountAbbr:    "dls",
+	})
+
+	downloads := w.CandidatesMap("namespaceToPackageCandidates", `
+namespaceToPackageCandidates holds a map of every namespace in
+namespaceToPackage which is provided by more than one package to the other
+packages which provide it, most downloaded first. This is used to work out
+how confident a guess is.
+`, namespaces, sortedNamespaces, chosen)
+
+	w.CountMap("packageToDownloads", `
+packageToDownloads holds a map of every package providing a namespace in
+namespaceToPackageCandidates to the number of times it has been
+downloaded.
+`, downloads)
+
+	sortedFrameworks := []string{}
+	for framework, nss := range frameworks {
+		sort.Strings(nss)
+		sortedFrameworks = append(sortedFrameworks, framework)
+	}
+	sort.Strings(sortedFrameworks)
+
+	w.BeginMap("frameworkNamespaces", "string", `
+frameworkNamespaces holds a map of every known target framework, e.g.
+"net8.0", to the namespaces it provides, and of every shared framework
+which can be referenced on top of it, e.g.
+"net8.0+Microsoft.AspNetCore.App", to the namespaces that provides. These
+never need a package.
+
+The namespaces are comma separated to keep the go compiler happy.
+`)
+	for _, framework := range sortedFrameworks {
+		w.Entry(framework, strings.Join(frameworks[framework], ","), "")
+	}
+	w.EndMap("frameworkNamespaces")
+
+	w.WriteFile(out)
+}
@@ -0,0 +1,581 @@
+package dotnet
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// dotnetSourcePatterns are the patterns of the source files that are
+// searched for using directives and open declarations.
+var dotnetSourcePatterns = []string{"*.cs", "*.fs"}
+
+// dotnetGuessRegexps is the GuessRegexps value for DotNetBackend.
+// Between them, they match everything that findNamespaceRefs looks at,
+// and the parts of the project file that say which frameworks are
+// available.
+var dotnetGuessRegexps = util.Regexps([]string{
+	`(?m)^\s*(?:global\s+)?using\s+(?:static\s+)?(?:\w+\s*=\s*)?([\w.:]+)`,
+	`(?m)^\s*open\s+(?:type\s+)?([\w.]+)`,
+	`(?m)^\s*(?:namespace|module)\s+(?:(?:rec|global|private|internal|public)\s+)*([\w.]+)`,
+	`<TargetFrameworks?>([^<]*)<`,
+	`<FrameworkReference\s+Include="([^"]*)"`,
+	`<Project\s+Sdk="([^"]*)"`,
+})
+
+// dotnetSdkFrameworks maps the project SDKs which reference a shared
+// framework implicitly to that framework.
+var dotnetSdkFrameworks = map[string]string{
+	"Microsoft.NET.Sdk.Web": "Microsoft.AspNetCore.App",
+}
+
+// dotnetImplicitPackages are the packages that the SDK references
+// without them being in the project file.
+var dotnetImplicitPackages = map[string]bool{
+	"FSharp.Core": true,
+}
+
+// frameworkReference is a reference to a shared framework from a .NET
+// project file.
+type frameworkReference struct {
+	Include string `xml:"Include,attr"`
+}
+
+// frameworkProject is the part of a .NET project file that says which
+// frameworks the project is built against.
+type frameworkProject struct {
+	XMLName             xml.Name             `xml:"Project"`
+	Sdk                 string               `xml:"Sdk,attr"`
+	TargetFramework     string               `xml:"PropertyGroup>TargetFramework"`
+	TargetFrameworks    string               `xml:"PropertyGroup>TargetFrameworks"`
+	FrameworkReferences []frameworkReference `xml:"ItemGroup>FrameworkReference"`
+}
+
+// dotnetToken is a token of C# or F# source code, as returned by
+// dotnetTokenize: either an identifier or keyword, or punctuation,
+// with "::" as a single token.
+type dotnetToken struct {
+	text  string
+	ident bool
+	line  int
+}
+
+// dotnetStringLength returns the length of the string or character
+// literal at the start of src, or -1 if there isn't one. Prefixes like
+// "@" and "$" must already have been skipped, and verbatim says
+// whether there was an "@". A quote that doesn't start a character
+// literal is an F# type variable.
+func dotnetStringLength(src string, verbatim bool) int {
+	switch {
+	case strings.HasPrefix(src, `"""`):
+		// A raw or triple-quoted string ends with as many
+		// quotes as it starts with.
+		n := 0
+		for n < len(src) && src[n] == '"' {
+			n++
+		}
+		end := strings.Index(src[n:], strings.Repeat(`"`, n))
+		if end == -1 {
+			return len(src)
+		}
+		return n + end + n
+
+	case strings.HasPrefix(src, `"`):
+		for i := 1; i < len(src); i++ {
+			if verbatim && strings.HasPrefix(src[i:], `""`) {
+				i++
+			} else if !verbatim && src[i] == '\\' {
+				i++
+			} else if src[i] == '"' {
+				return i + 1
+			}
+		}
+		return len(src)
+
+	case strings.HasPrefix(src, "'"):
+		if strings.HasPrefix(src[1:], `\`) {
+			end := strings.IndexByte(src[2:], '\'')
+			if end == -1 {
+				return -1
+			}
+			return 2 + end + 1
+		}
+		_, size := utf8.DecodeRuneInString(src[1:])
+		if size > 0 && strings.HasPrefix(src[1+size:], "'") {
+			return 1 + size + 1
+		}
+	}
+	return -1
+}
+
+// dotnetTokenize splits C# source code, or F# source code if fsharp is
+// true, into tokens, leaving out whitespace, comments, literals and
+// preprocessor directives.
+func dotnetTokenize(src string, fsharp bool) []dotnetToken {
+	tokens := []dotnetToken{}
+	line := 1
+	lineStart := true
+	for i := 0; i < len(src); {
+		c := src[i]
+		if c == '\n' {
+			line++
+			lineStart = true
+			i++
+			continue
+		}
+		if c == ' ' || c == '\t' || c == '\r' || c == '\f' {
+			i++
+			continue
+		}
+		atLineStart := lineStart
+		lineStart = false
+
+		switch {
+		case c == '#' && atLineStart,
+			strings.HasPrefix(src[i:], "//"):
+			end := strings.IndexByte(src[i:], '\n')
+			if end == -1 {
+				end = len(src) - i
+			}
+			i += end
+
+		case !fsharp && strings.HasPrefix(src[i:], "/*"):
+			n := util.SkipBlockComment(src[i:], "/*", "*/", false)
+			line += strings.Count(src[i:i+n], "\n")
+			i += n
+
+		case fsharp && strings.HasPrefix(src[i:], "(*") && !strings.HasPrefix(src[i:], "(*)"):
+			// Block comments nest in F#.
+			n := util.SkipBlockComment(src[i:], "(*", "*)", true)
+			line += strings.Count(src[i:i+n], "\n")
+			i += n
+
+		case fsharp && strings.HasPrefix(src[i:], "``"):
+			end := strings.Index(src[i+2:], "``")
+			if end == -1 {
+				end = len(src) - i - 2
+			}
+			tokens = append(tokens, dotnetToken{text: src[i+2 : i+2+end], ident: true, line: line})
+			i += 2 + end + 2
+
+		case c == '"' || c == '\'' || c == '@' || c == '$':
+			// Skip the prefixes of interpolated and verbatim
+			// strings, and of verbatim identifiers.
+			j := i
+			verbatim := false
+			for j < len(src) && (src[j] == '@' || src[j] == '$') {
+				verbatim = verbatim || src[j] == '@'
+				j++
+			}
+			if n := dotnetStringLength(src[j:], verbatim); n != -1 {
+				line += strings.Count(src[i:j+n], "\n")
+				i = j + n
+			} else if j > i && j < len(src) && util.IsIdentByte(src[j]) {
+				i = j
+			} else {
+				tokens = append(tokens, dotnetToken{text: string(c), line: line})
+				i++
+			}
+
+		case util.IsIdentByte(c):
+			start := i
+			for i < len(src) && util.IsIdentByte(src[i]) {
+				i++
+			}
+			tokens = append(tokens, dotnetToken{text: src[start:i], ident: true, line: line})
+
+		case strings.HasPrefix(src[i:], "::"):
+			tokens = append(tokens, dotnetToken{text: "::", line: line})
+			i += 2
+
+		default:
+			tokens = append(tokens, dotnetToken{text: string(c), line: line})
+			i++
+		}
+	}
+	return tokens
+}
+
+// dotnetUsing is a namespace brought into scope by a using directive
+// or an open declaration, as found by findNamespaceRefs.
+type dotnetUsing struct {
+	// The namespace, or for using static and open type, the type,
+	// e.g. "Newtonsoft.Json.Linq".
+	ns string
+
+	// The 1-based line of the directive.
+	line int
+}
+
+// dotnetFileRefs is the result of findNamespaceRefs.
+type dotnetFileRefs struct {
+	// The using directives or open declarations, in the order
+	// they appear.
+	usings []dotnetUsing
+
+	// The namespaces and F# modules declared.
+	declared []string
+}
+
+// parseQualifiedName returns the dotted name starting at tokens[i],
+// and the index just after it.
+func parseQualifiedName(tokens []dotnetToken, i int) (string, int) {
+	parts := []string{}
+	for i < len(tokens) && tokens[i].ident {
+		parts = append(parts, tokens[i].text)
+		i++
+		if i+1 < len(tokens) && tokens[i].text == "." && tokens[i+1].ident {
+			i++
+			continue
+		}
+		break
+	}
+	return strings.Join(parts, "."), i
+}
+
+// findNamespaceRefs returns the using directives and namespace
+// declarations in C# source code, or the open declarations and
+// namespace and module declarations in F# source code if fsharp is
+// true.
+func findNamespaceRefs(src string, fsharp bool) dotnetFileRefs {
+	tokens := dotnetTokenize(src, fsharp)
+	refs := dotnetFileRefs{usings: []dotnetUsing{}, declared: []string{}}
+	skip := func(i int, words ...string) int {
+		for i < len(tokens) {
+			found := false
+			for _, word := range words {
+				if tokens[i].text == word {
+					found = true
+				}
+			}
+			if !found {
+				break
+			}
+			i++
+		}
+		return i
+	}
+
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		if !tok.ident || (i > 0 && tokens[i-1].text == ".") {
+			continue
+		}
+		switch {
+		case !fsharp && tok.text == "using":
+			j := skip(i+1, "static")
+			if j+1 < len(tokens) && tokens[j].ident && tokens[j+1].text == "=" {
+				j += 2
+			}
+			if j+1 < len(tokens) && tokens[j].text == "global" && tokens[j+1].text == "::" {
+				j += 2
+			}
+			ns, end := parseQualifiedName(tokens, j)
+			// Using statements and declarations, like
+			// "using var x = ...", aren't followed by a
+			// name and a semicolon.
+			if ns == "" || end == len(tokens) || (tokens[end].text != ";" && tokens[end].text != "<") {
+				continue
+			}
+			refs.usings = append(refs.usings, dotnetUsing{ns: ns, line: tok.line})
+			i = end
+
+		case fsharp && tok.text == "open":
+			ns, end := parseQualifiedName(tokens, skip(i+1, "type"))
+			if ns != "" {
+				refs.usings = append(refs.usings, dotnetUsing{ns: ns, line: tok.line})
+				i = end - 1
+			}
+
+		case tok.text == "namespace" || (fsharp && tok.text == "module"):
+			ns, end := parseQualifiedName(tokens, skip(i+1, "rec", "global", "private", "internal", "public"))
+			if ns != "" {
+				refs.declared = append(refs.declared, ns)
+				i = end - 1
+			}
+		}
+	}
+	return refs
+}
+
+// dotnetOwnNamespaces returns a function which says whether a
+// namespace in a using directive belongs to the project itself, given
+// the namespaces that it declares. Since C# resolves using directives
+// relative to the enclosing namespaces too, a namespace also belongs
+// to the project if its first part names one of its namespaces
+// relative to another, e.g. "Models.Requests" with "App.Models".
+func dotnetOwnNamespaces(declared []string) func(string) bool {
+	prefixes := map[string]bool{}
+	for _, ns := range declared {
+		for {
+			prefixes[ns] = true
+			end := strings.LastIndexByte(ns, '.')
+			if end == -1 {
+				break
+			}
+			ns = ns[:end]
+		}
+	}
+	under := func(ns string) bool {
+		for _, parent := range declared {
+			if ns == parent || strings.HasPrefix(ns, parent+".") {
+				return true
+			}
+		}
+		return false
+	}
+	return func(ns string) bool {
+		if under(ns) || prefixes[ns] {
+			return true
+		}
+		first := strings.SplitN(ns, ".", 2)[0]
+		for prefix := range prefixes {
+			if prefixes[prefix+"."+first] {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// parseTargetFramework splits a target framework moniker, e.g.
+// "net8.0-windows", into its family and version. The families are
+// "netcoreapp" (including .NET 5 and later), "netframework" and
+// "netstandard".
+func parseTargetFramework(tfm string) (string, []int) {
+	tfm = strings.ToLower(strings.SplitN(tfm, "-", 2)[0])
+	family := ""
+	version := ""
+	switch {
+	case strings.HasPrefix(tfm, "netstandard"):
+		family, version = "netstandard", strings.TrimPrefix(tfm, "netstandard")
+	case strings.HasPrefix(tfm, "netcoreapp"):
+		family, version = "netcoreapp", strings.TrimPrefix(tfm, "netcoreapp")
+	case strings.HasPrefix(tfm, "net") && strings.Contains(tfm, "."):
+		family, version = "netcoreapp", strings.TrimPrefix(tfm, "net")
+	case strings.HasPrefix(tfm, "net"):
+		// .NET Framework versions have no dots, e.g. net472.
+		family = "netframework"
+		version = strings.Join(strings.Split(strings.TrimPrefix(tfm, "net"), ""), ".")
+	}
+
+	parts := []int{}
+	for _, part := range strings.Split(version, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return "", nil
+		}
+		parts = append(parts, n)
+	}
+	return family, parts
+}
+
+// compareVersions returns a negative number, zero or a positive number
+// if version a is less than, equal to or greater than version b.
+func compareVersions(a []int, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			return x - y
+		}
+	}
+	return 0
+}
+
+// bestTargetFramework returns the target framework in
+// frameworkNamespaces which best matches the given one: the newest of
+// the same family which isn't newer, or failing that, the oldest of
+// the same family. If tfm is empty, the newest .NET is used, as for a
+// new project. It returns the empty string if there is no match.
+func bestTargetFramework(tfm string) string {
+	family, version := "netcoreapp", []int(nil)
+	if tfm != "" {
+		family, version = parseTargetFramework(tfm)
+	}
+
+	candidates := []string{}
+	versions := map[string][]int{}
+	for key := range frameworkNamespaces() {
+		if strings.Contains(key, "+") {
+			continue
+		}
+		if keyFamily, keyVersion := parseTargetFramework(key); keyFamily == family {
+			candidates = append(candidates, key)
+			versions[key] = keyVersion
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return compareVersions(versions[candidates[i]], versions[candidates[j]]) < 0
+	})
+
+	best := candidates[0]
+	for _, key := range candidates {
+		if version == nil || compareVersions(versions[key], version) <= 0 {
+			best = key
+		}
+	}
+	return best
+}
+
+// dotnetFrameworks returns the namespaces provided by each of the
+// project's target frameworks, together with the shared frameworks it
+// references, according to its project file.
+func dotnetFrameworks() []map[string]bool {
+	var proj frameworkProject
+	if contents, err := ioutil.ReadFile(findSpecFile()); err == nil {
+		if err := xml.Unmarshal(contents, &proj); err != nil {
+			util.Die("%s: %s", findSpecFile(), err)
+		}
+	} else if !os.IsNotExist(err) {
+		util.Die("%s: %s", findSpecFile(), err)
+	}
+
+	tfms := []string{}
+	for _, tfm := range strings.Split(proj.TargetFramework+";"+proj.TargetFrameworks, ";") {
+		if tfm = strings.TrimSpace(tfm); tfm != "" {
+			tfms = append(tfms, tfm)
+		}
+	}
+	if len(tfms) == 0 {
+		tfms = []string{""}
+	}
+
+	refs := []string{}
+	if ref, ok := dotnetSdkFrameworks[proj.Sdk]; ok {
+		refs = append(refs, ref)
+	}
+	for _, ref := range proj.FrameworkReferences {
+		refs = append(refs, ref.Include)
+	}
+
+	frameworks := []map[string]bool{}
+	for _, tfm := range tfms {
+		namespaces := map[string]bool{}
+		best := bestTargetFramework(tfm)
+		if best != "" {
+			for _, key := range append([]string{best}, refs...) {
+				if key != best {
+					key = best + "+" + key
+				}
+				for _, ns := range strings.Split(frameworkNamespaces()[key], ",") {
+					namespaces[ns] = true
+				}
+			}
+		}
+		frameworks = append(frameworks, namespaces)
+	}
+	return frameworks
+}
+
+// namespacePackage returns the reason for guessing the package that
+// provides a namespace, given the namespaces provided by each target
+// framework. If the namespace itself isn't known, its enclosing
+// namespaces are tried, since using static names a type and the index
+// may not have every namespace. The second return value is false if
+// the namespace comes with every target framework, or no package is
+// known.
+func namespacePackage(ns string, frameworks []map[string]bool) (api.GuessReason, bool) {
+	for prefix := ns; prefix != ""; {
+		provided := true
+		for _, namespaces := range frameworks {
+			provided = provided && namespaces[prefix]
+		}
+		if provided {
+			return api.GuessReason{}, false
+		}
+
+		if pkg, ok := namespaceToPackage()[prefix]; ok {
+			confidence, alternatives := api.DownloadShare(
+				pkg, namespaceToPackageCandidates()[prefix], packageToDownloads(),
+			)
+			return api.GuessReason{
+				Package:      api.PkgName(pkg),
+				Module:       ns,
+				Source:       "nuget-index",
+				Confidence:   confidence,
+				Alternatives: alternatives,
+			}, true
+		}
+
+		end := strings.LastIndexByte(prefix, '.')
+		if end == -1 {
+			break
+		}
+		prefix = prefix[:end]
+	}
+	return api.GuessReason{}, false
+}
+
+// isDotnetBuildOutput returns true if the file is in a bin or obj
+// directory, where the build puts generated sources.
+func isDotnetBuildOutput(file string) bool {
+	for _, dir := range strings.Split(filepath.ToSlash(filepath.Dir(file)), "/") {
+		if dir == "bin" || dir == "obj" {
+			return true
+		}
+	}
+	return false
+}
+
+// dotnetGuessExplain implements GuessExplain for DotNetBackend. There
+// is a reason for each file that uses a namespace from a package, at
+// the first such using directive or open declaration in the file.
+// Namespaces from the target frameworks and from the project itself
+// are left out.
+func dotnetGuessExplain() []api.GuessReason {
+	files := []string{}
+	fileRefs := map[string]dotnetFileRefs{}
+	declared := []string{}
+	for _, file := range util.ListFilesRecursive(dotnetSourcePatterns) {
+		if isDotnetBuildOutput(file) {
+			continue
+		}
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			util.Die("%s: %s", file, err)
+		}
+		refs := findNamespaceRefs(string(contents), strings.HasSuffix(file, ".fs"))
+		files = append(files, file)
+		fileRefs[file] = refs
+		declared = append(declared, refs.declared...)
+	}
+
+	isOwn := dotnetOwnNamespaces(declared)
+	frameworks := dotnetFrameworks()
+
+	reasons := []api.GuessReason{}
+	for _, file := range files {
+		seen := map[api.PkgName]bool{}
+		for _, using := range fileRefs[file].usings {
+			if isOwn(using.ns) {
+				continue
+			}
+			reason, ok := namespacePackage(using.ns, frameworks)
+			if !ok || seen[reason.Package] || dotnetImplicitPackages[string(reason.Package)] {
+				continue
+			}
+			seen[reason.Package] = true
+			reason.File = file
+			reason.Line = using.line
+			reasons = append(reasons, reason)
+		}
+	}
+	return reasons
+}
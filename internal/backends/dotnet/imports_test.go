@@ -0,0 +1,301 @@
+package dotnet
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestFindNamespaceRefs(t *testing.T) {
+	tcs := []struct {
+		scenario string
+		src      string
+		fsharp   bool
+		expected dotnetFileRefs
+	}{
+		{
+			scenario: "Returns C# using directives and namespace declarations",
+			src: `using System;
+global using Newtonsoft.Json.Linq;
+using static Serilog.Log;
+using Json = Newtonsoft.Json.JsonConvert;
+using Dict = System.Collections.Generic.Dictionary<string, int>;
+using global::Dapper;
+
+namespace App.Models;
+`,
+			expected: dotnetFileRefs{
+				usings: []dotnetUsing{
+					{ns: "System", line: 1},
+					{ns: "Newtonsoft.Json.Linq", line: 2},
+					{ns: "Serilog.Log", line: 3},
+					{ns: "Newtonsoft.Json.JsonConvert", line: 4},
+					{ns: "System.Collections.Generic.Dictionary", line: 5},
+					{ns: "Dapper", line: 6},
+				},
+				declared: []string{"App.Models"},
+			},
+		},
+		{
+			scenario: "Skips C# using statements, comments and strings",
+			src: `namespace App
+{
+    // using Commented.Out;
+    /* using Also.Commented; */
+    #region using Region.Name
+    #endregion
+    class C
+    {
+        void F()
+        {
+            using var reader = new StreamReader("x");
+            using (var x = Open()) { }
+            var s = @"using Verbatim.String;";
+            var t = $"using {Interpolated}.String;";
+            var u = """
+                using Raw.String;
+                """;
+            var c = '"';
+        }
+    }
+}
+`,
+			expected: dotnetFileRefs{
+				usings:   []dotnetUsing{},
+				declared: []string{"App"},
+			},
+		},
+		{
+			scenario: "Returns F# open declarations and namespace and module declarations",
+			src: `namespace rec MyLib.Core
+
+#r "nuget: Ignored.Package"
+open System
+open type System.Math
+(* open Commented.Out (* nested *) *)
+open FSharp.Data
+let f (x: 'T) = (*) x 2
+
+module internal Helpers =
+    let s = "open Quoted.String"
+    open ` + "``Odd Name``" + `
+`,
+			fsharp: true,
+			expected: dotnetFileRefs{
+				usings: []dotnetUsing{
+					{ns: "System", line: 4},
+					{ns: "System.Math", line: 5},
+					{ns: "FSharp.Data", line: 7},
+					{ns: "Odd Name", line: 12},
+				},
+				declared: []string{"MyLib.Core", "Helpers"},
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.scenario, func(t *testing.T) {
+			refs := findNamespaceRefs(tc.src, tc.fsharp)
+			if !reflect.DeepEqual(refs, tc.expected) {
+				t.Errorf("expected %+v, got %+v", tc.expected, refs)
+			}
+		})
+	}
+}
+
+func TestDotnetOwnNamespaces(t *testing.T) {
+	isOwn := dotnetOwnNamespaces([]string{"App.Controllers", "App.Models"})
+	for ns, expected := range map[string]bool{
+		"App.Models":           true,
+		"App.Models.Requests":  true,
+		"App":                  true,
+		"Models":               true,
+		"Newtonsoft.Json":      false,
+		"AppHelpers":           false,
+		"Controllers.Requests": true,
+		"Requests":             false,
+	} {
+		if own := isOwn(ns); own != expected {
+			t.Errorf("expected %v for %s, got %v", expected, ns, own)
+		}
+	}
+}
+
+func TestParseTargetFramework(t *testing.T) {
+	tcs := []struct {
+		tfm     string
+		family  string
+		version []int
+	}{
+		{"net8.0", "netcoreapp", []int{8, 0}},
+		{"net8.0-windows", "netcoreapp", []int{8, 0}},
+		{"netcoreapp3.1", "netcoreapp", []int{3, 1}},
+		{"net472", "netframework", []int{4, 7, 2}},
+		{"netstandard2.0", "netstandard", []int{2, 0}},
+		{"monoandroid", "", nil},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.tfm, func(t *testing.T) {
+			family, version := parseTargetFramework(tc.tfm)
+			if family != tc.family || !reflect.DeepEqual(version, tc.version) {
+				t.Errorf("expected %s %v, got %s %v", tc.family, tc.version, family, version)
+			}
+		})
+	}
+}
+
+// withFrameworks replaces the generated framework and namespace maps,
+// and returns a function that restores them.
+func withFrameworks() func() {
+	frameworks, packages := frameworkNamespacesCached, namespaceToPackageCached
+	restore := func() {
+		frameworkNamespacesCached, namespaceToPackageCached = frameworks, packages
+	}
+
+	frameworkNamespacesCached = map[string]string{
+		"net48":                           "System,System.Configuration,System.Web",
+		"net6.0":                          "System,System.Text.Json",
+		"net6.0+Microsoft.AspNetCore.App": "Microsoft.AspNetCore.Mvc,Microsoft.Extensions.Logging",
+		"net8.0":                          "System,System.Text.Json",
+		"net8.0+Microsoft.AspNetCore.App": "Microsoft.AspNetCore.Mvc,Microsoft.Extensions.Logging",
+		"netstandard2.0":                  "System,System.Configuration",
+	}
+	namespaceToPackageCached = map[string]string{
+		"Microsoft.Extensions.Logging": "Microsoft.Extensions.Logging",
+		"Newtonsoft.Json":              "Newtonsoft.Json",
+		"System.Configuration":         "System.Configuration.ConfigurationManager",
+		"System.Text.Json":             "System.Text.Json",
+	}
+	return restore
+}
+
+func TestBestTargetFramework(t *testing.T) {
+	defer withFrameworks()()
+
+	tcs := []struct {
+		tfm      string
+		expected string
+	}{
+		{"", "net8.0"},
+		{"net8.0", "net8.0"},
+		{"net8.0-windows", "net8.0"},
+		{"net7.0", "net6.0"},
+		{"net9.0", "net8.0"},
+		{"net5.0", "net6.0"},
+		{"netcoreapp3.1", "net6.0"},
+		{"net472", "net48"},
+		{"net481", "net48"},
+		{"netstandard2.1", "netstandard2.0"},
+		{"monoandroid", ""},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.tfm, func(t *testing.T) {
+			if best := bestTargetFramework(tc.tfm); best != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, best)
+			}
+		})
+	}
+}
+
+func TestDotnetFrameworks(t *testing.T) {
+	defer withFrameworks()()
+
+	tcs := []struct {
+		scenario string
+		// The project file, or "" for none.
+		project string
+		ns      string
+		// The package guessed for ns, or "" if the target
+		// frameworks provide it.
+		expected string
+	}{
+		{
+			scenario: "no project file",
+			ns:       "System.Text.Json",
+		},
+		{
+			scenario: "provided namespace",
+			project:  `<Project Sdk="Microsoft.NET.Sdk"><PropertyGroup><TargetFramework>net8.0</TargetFramework></PropertyGroup></Project>`,
+			ns:       "System.Text.Json.Serialization",
+		},
+		{
+			scenario: "package namespace",
+			project:  `<Project Sdk="Microsoft.NET.Sdk"><PropertyGroup><TargetFramework>net8.0</TargetFramework></PropertyGroup></Project>`,
+			ns:       "Newtonsoft.Json.Linq",
+			expected: "Newtonsoft.Json",
+		},
+		{
+			scenario: "namespace missing from older framework",
+			project:  `<Project Sdk="Microsoft.NET.Sdk"><PropertyGroup><TargetFramework>netstandard2.0</TargetFramework></PropertyGroup></Project>`,
+			ns:       "System.Text.Json",
+			expected: "System.Text.Json",
+		},
+		{
+			scenario: "multi-targeting with every framework providing it",
+			project:  `<Project Sdk="Microsoft.NET.Sdk"><PropertyGroup><TargetFrameworks>net6.0;net8.0</TargetFrameworks></PropertyGroup></Project>`,
+			ns:       "System.Text.Json",
+		},
+		{
+			scenario: "multi-targeting with one framework missing it",
+			project:  `<Project Sdk="Microsoft.NET.Sdk"><PropertyGroup><TargetFrameworks>net8.0; netstandard2.0</TargetFrameworks></PropertyGroup></Project>`,
+			ns:       "System.Text.Json",
+			expected: "System.Text.Json",
+		},
+		{
+			scenario: "multi-targeting with .NET Framework",
+			project:  `<Project Sdk="Microsoft.NET.Sdk"><PropertyGroup><TargetFrameworks>net48;net8.0</TargetFrameworks></PropertyGroup></Project>`,
+			ns:       "System.Configuration",
+			expected: "System.Configuration.ConfigurationManager",
+		},
+		{
+			scenario: "shared framework from the SDK",
+			project:  `<Project Sdk="Microsoft.NET.Sdk.Web"><PropertyGroup><TargetFramework>net8.0</TargetFramework></PropertyGroup></Project>`,
+			ns:       "Microsoft.Extensions.Logging",
+		},
+		{
+			scenario: "shared framework from a reference",
+			project:  `<Project Sdk="Microsoft.NET.Sdk"><PropertyGroup><TargetFrameworks>net6.0;net8.0</TargetFrameworks></PropertyGroup><ItemGroup><FrameworkReference Include="Microsoft.AspNetCore.App" /></ItemGroup></Project>`,
+			ns:       "Microsoft.Extensions.Logging",
+		},
+		{
+			scenario: "shared framework not referenced",
+			project:  `<Project Sdk="Microsoft.NET.Sdk"><PropertyGroup><TargetFramework>net8.0</TargetFramework></PropertyGroup></Project>`,
+			ns:       "Microsoft.Extensions.Logging",
+			expected: "Microsoft.Extensions.Logging",
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "TestDotnetFrameworks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+
+	for _, tc := range tcs {
+		t.Run(tc.scenario, func(t *testing.T) {
+			os.Remove("app.csproj")
+			if tc.project != "" {
+				if err := ioutil.WriteFile("app.csproj", []byte(tc.project), 0666); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			reason, ok := namespacePackage(tc.ns, dotnetFrameworks())
+			if string(reason.Package) != tc.expected || ok != (tc.expected != "") {
+				t.Errorf("expected %q, got %q (%v)", tc.expected, reason.Package, ok)
+			}
+		})
+	}
+}
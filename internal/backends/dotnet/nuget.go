@@ -39,6 +39,17 @@ type packageMetadata struct {
 	License     string     `xml:"license"`
 	Repository  repository `xml:"repository"`
 	ProjectURL  string     `xml:"projectUrl"`
+	// dependencies are either listed directly or grouped by
+	// target framework
+	Dependencies []dependency `xml:"dependencies>dependency"`
+	Groups       []struct {
+		Dependencies []dependency `xml:"dependency"`
+	} `xml:"dependencies>group"`
+}
+
+// nuget.org .nuspec file package dependency
+type dependency struct {
+	ID string `xml:"id,attr"`
 }
 
 // nuget.org .nuspec file data
@@ -49,10 +60,11 @@ type nugetPackage struct {
 
 // nuget.org search service result entry
 type searchResultData struct {
-	ID          string
-	Version     string
-	Description string
-	ProjectURL  string
+	ID             string
+	Version        string
+	Description    string
+	ProjectURL     string
+	TotalDownloads int
 }
 
 // nuget.org search service result record
@@ -63,10 +75,25 @@ type searchResult struct {
 
 const searchQueryURL = "https://azuresearch-usnc.nuget.org/query"
 
+// the most results that nuget.org will return for one search request
+const maxTake = 1000
+
 // find the first ten projects that match the query string on nuget.org
 func search(query string) []api.PkgInfo {
+	return searchPage(query, 0, 10)
+}
+
+// find limit projects that match the query string on nuget.org, after skipping offset of them
+func searchPaged(query string, offset int, limit int) []api.PkgInfo {
+	return api.FetchChunks(offset, limit, maxTake, func(skip int, take int) []api.PkgInfo {
+		return searchPage(query, skip, take)
+	})
+}
+
+// find take projects that match the query string on nuget.org, after skipping skip of them
+func searchPage(query string, skip int, take int) []api.PkgInfo {
 	pkgs := []api.PkgInfo{}
-	queryURL := fmt.Sprintf("%s?q=%s&take=10", searchQueryURL, url.QueryEscape(query))
+	queryURL := fmt.Sprintf("%s?q=%s&skip=%d&take=%d", searchQueryURL, url.QueryEscape(query), skip, take)
 
 	res, err := http.Get(queryURL)
 	if err != nil {
@@ -95,15 +122,15 @@ func search(query string) []api.PkgInfo {
 			Version:       data.Version,
 			Description:   data.Description,
 			SourceCodeURL: data.ProjectURL,
+			Downloads:     data.TotalDownloads,
 		})
 	}
 
 	return pkgs
 }
 
-// looks up all the versions of the package and gets retails for the latest version from nuget.org
-func info(pkgName api.PkgName) api.PkgInfo {
-	lowID := url.PathEscape(strings.ToLower(string(pkgName)))
+// looks up all the versions of the package on nuget.org, from oldest to newest
+func fetchVersions(lowID string) []string {
 	infoURL := fmt.Sprintf("https://api.nuget.org/v3-flatcontainer/%s/index.json", lowID)
 
 	res, err := http.Get(infoURL)
@@ -111,6 +138,9 @@ func info(pkgName api.PkgName) api.PkgInfo {
 		util.Die("failed to get the versions: %s", err)
 	}
 	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil
+	}
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		util.Die("could not read response: %s", err)
@@ -120,16 +150,19 @@ func info(pkgName api.PkgName) api.PkgInfo {
 	if err != nil {
 		util.Die("could not read json body: %s", err)
 	}
-	latestVersion := infoResult.Versions[len(infoResult.Versions)-1]
-	util.ProgressMsg(fmt.Sprintf("latest version of %s is %s", pkgName, latestVersion))
-	specURL := fmt.Sprintf("https://api.nuget.org/v3-flatcontainer/%s/%s/%s.nuspec", lowID, url.PathEscape(latestVersion), lowID)
+	return infoResult.Versions
+}
+
+// gets the details for a specific version of the package from nuget.org
+func infoForVersion(lowID string, version string, versions []string) api.PkgInfo {
+	specURL := fmt.Sprintf("https://api.nuget.org/v3-flatcontainer/%s/%s/%s.nuspec", lowID, url.PathEscape(version), lowID)
 	util.ProgressMsg(fmt.Sprintf("Getting spec from %s", specURL))
-	res, err = http.Get(specURL)
+	res, err := http.Get(specURL)
 	if err != nil {
 		util.Die("failed to get the spec: %s", err)
 	}
 	defer res.Body.Close()
-	body, err = ioutil.ReadAll(res.Body)
+	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		util.Die("could not read response: %s", err)
 	}
@@ -148,5 +181,56 @@ func info(pkgName api.PkgName) api.PkgInfo {
 		SourceCodeURL: nugetPackage.Metadata.Repository.URL,
 		HomepageURL:   nugetPackage.Metadata.ProjectURL,
 	}
+	if nugetPackage.Metadata.Repository.URL != "" {
+		pkgInfo.Repository = &api.PkgRepository{
+			Type: nugetPackage.Metadata.Repository.Type,
+			URL:  nugetPackage.Metadata.Repository.URL,
+		}
+	}
+	// the same dependency usually appears once per target framework
+	seen := map[string]bool{}
+	deps := nugetPackage.Metadata.Dependencies
+	for _, group := range nugetPackage.Metadata.Groups {
+		deps = append(deps, group.Dependencies...)
+	}
+	for _, dep := range deps {
+		if !seen[dep.ID] {
+			seen[dep.ID] = true
+			pkgInfo.Dependencies = append(pkgInfo.Dependencies, dep.ID)
+		}
+	}
+	for _, version := range versions {
+		pkgInfo.Versions = append(pkgInfo.Versions, api.PkgVersionInfo{
+			Version: version,
+			// semver 2.0 prerelease versions have a suffix
+			Prerelease: strings.Contains(version, "-"),
+		})
+	}
 	return pkgInfo
 }
+
+// looks up all the versions of the package and gets retails for the latest version from nuget.org
+func info(pkgName api.PkgName) api.PkgInfo {
+	lowID := url.PathEscape(strings.ToLower(string(pkgName)))
+	versions := fetchVersions(lowID)
+	if len(versions) == 0 {
+		return api.PkgInfo{}
+	}
+	latestVersion := versions[len(versions)-1]
+	util.ProgressMsg(fmt.Sprintf("latest version of %s is %s", pkgName, latestVersion))
+	return infoForVersion(lowID, latestVersion, versions)
+}
+
+// gets the details for the given version of the package from nuget.org
+func versionInfo(pkgName api.PkgName, version api.PkgVersion) api.PkgInfo {
+	lowID := url.PathEscape(strings.ToLower(string(pkgName)))
+	versions := fetchVersions(lowID)
+	// the flat container lists normalized, lowercased versions
+	lowVersion := strings.ToLower(string(version))
+	for _, v := range versions {
+		if v == lowVersion {
+			return infoForVersion(lowID, v, versions)
+		}
+	}
+	return api.PkgInfo{}
+}
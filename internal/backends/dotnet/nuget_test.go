@@ -13,10 +13,10 @@ func TestSearchNuget(t *testing.T) {
 
 	for _, pkg := range pkgs {
 		if pkg.Name == "" {
-			t.Errorf("pkg %q has no name", pkg)
+			t.Errorf("pkg %+v has no name", pkg)
 		}
 		if pkg.Version == "" {
-			t.Errorf("pkg %q has no version", pkg)
+			t.Errorf("pkg %+v has no version", pkg)
 		}
 	}
 }
@@ -25,9 +25,9 @@ func TestInfoFromNuget(t *testing.T) {
 	pkg := info("Microsoft.Extensions.Logging")
 
 	if pkg.Name == "" {
-		t.Errorf("pkg %q has no name", pkg)
+		t.Errorf("pkg %+v has no name", pkg)
 	}
 	if pkg.Version == "" {
-		t.Errorf("pkg %q has no version", pkg)
+		t.Errorf("pkg %+v has no version", pkg)
 	}
 }
@@ -17,16 +17,151 @@ import (
 // elispPatterns is the FilenamePatterns value for ElispBackend.
 var elispPatterns = []string{"*.el"}
 
+// elispRequireRegexp matches a require form, capturing the feature.
+var elispRequireRegexp = regexp.MustCompile(
+	`\(\s*require\s*'\s*([^)[:space:]]+)[^)]*\)`,
+)
+
+// elispProvideRegexp matches a provide form, capturing the feature.
+var elispProvideRegexp = regexp.MustCompile(
+	`\(\s*provide\s*'\s*([^)[:space:]]+)[^)]*\)`,
+)
+
+// elispRequire is a require form found by elispRequires.
+type elispRequire struct {
+	file    string
+	line    int
+	feature string
+}
+
+// elispRequires returns the require forms in the project, in a
+// deterministic order.
+func elispRequires() []elispRequire {
+	requires := []elispRequire{}
+	for _, path := range util.ListFilesRecursive(elispPatterns) {
+		contentsB, err := ioutil.ReadFile(path)
+		if err != nil {
+			util.Die("%s: %s", path, err)
+		}
+		contents := string(contentsB)
+		for _, loc := range elispRequireRegexp.FindAllStringSubmatchIndex(contents, -1) {
+			requires = append(requires, elispRequire{
+				file:    path,
+				line:    1 + strings.Count(contents[:loc[0]], "\n"),
+				feature: contents[loc[2]:loc[3]],
+			})
+		}
+	}
+	return requires
+}
+
+// elispFeaturePackages returns a map from each of the given features
+// which isn't provided by the project itself or by Emacs to the
+// packages that provide it, according to the Emacsmirror epkgs
+// database, which is downloaded.
+func elispFeaturePackages(requires []elispRequire) map[string][]api.PkgName {
+	required := map[string]bool{}
+	for _, req := range requires {
+		required[req.feature] = true
+	}
+
+	if len(required) == 0 {
+		return map[string][]api.PkgName{}
+	}
+
+	provided := map[string]bool{}
+	for _, match := range util.SearchRecursive(elispProvideRegexp, elispPatterns) {
+		provided[match[1]] = true
+	}
+
+	clauses := []string{}
+	for feature := range required {
+		if strings.ContainsAny(feature, `\'`) {
+			continue
+		}
+		if provided[feature] {
+			continue
+		}
+		clauses = append(clauses, fmt.Sprintf("feature = '%s'", feature))
+	}
+	if len(clauses) == 0 {
+		return map[string][]api.PkgName{}
+	}
+
+	tempdir, err := ioutil.TempDir("", "epkgs")
+	if err != nil {
+		util.Die("%s", err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	url := "https://github.com/emacsmirror/epkgs/raw/master/epkg.sqlite"
+	epkgs := filepath.Join(tempdir, "epkgs.sqlite")
+	util.DownloadFile(epkgs, url)
+
+	where := strings.Join(clauses, " OR ")
+	query := fmt.Sprintf("SELECT feature, package FROM provided PR WHERE (%s) "+
+		"AND NOT EXISTS (SELECT 1 FROM builtin_libraries B "+
+		"WHERE PR.feature = B.feature) "+
+		"AND NOT EXISTS (SELECT 1 FROM packages PK "+
+		"WHERE PR.package = PK.name AND PK.class = 'builtin') "+
+		"ORDER BY package;",
+		where,
+	)
+	output := string(util.GetCmdOutput([]string{"sqlite3", epkgs, query}))
+
+	// Each line is the feature and the quoted package name,
+	// separated by "|".
+	r := regexp.MustCompile(`(?m)^"?(.+?)"?\|"(.+?)"`)
+	pkgs := map[string][]api.PkgName{}
+	for _, match := range r.FindAllStringSubmatch(output, -1) {
+		pkgs[match[1]] = append(pkgs[match[1]], api.PkgName(match[2]))
+	}
+	return pkgs
+}
+
+// elispGuessExplain implements GuessExplain for ElispBackend. There is
+// a reason for every require form of a feature and every package that
+// provides the feature. If there are several such packages, then the
+// confidence in each is split evenly between them.
+func elispGuessExplain() []api.GuessReason {
+	requires := elispRequires()
+	featurePkgs := elispFeaturePackages(requires)
+	reasons := []api.GuessReason{}
+	for _, req := range requires {
+		pkgs := featurePkgs[req.feature]
+		for _, pkg := range pkgs {
+			alternatives := []string{}
+			for _, other := range pkgs {
+				if other != pkg {
+					alternatives = append(alternatives, string(other))
+				}
+			}
+			reasons = append(reasons, api.GuessReason{
+				Package:      pkg,
+				File:         req.file,
+				Line:         req.line,
+				Module:       req.feature,
+				Source:       "epkgs",
+				Confidence:   1 / api.Confidence(len(pkgs)),
+				Alternatives: alternatives,
+			})
+		}
+	}
+	return reasons
+}
+
 // ElispBackend is the UPM language backend for Emacs Lisp using Cask.
 var ElispBackend = api.LanguageBackend{
 	Name:             "elisp-cask",
 	Specfile:         "Cask",
 	Lockfile:         "packages.txt",
 	FilenamePatterns: elispPatterns,
+	Executables:      []string{"cask"},
 	Quirks:           api.QuirksNotReproducible,
 	GetPackageDir: func() string {
 		return ".cask"
 	},
+	PackageDirMarkers: []string{"*/elpa/*"},
 	Search: func(query string) []api.PkgInfo {
 		tmpdir, err := ioutil.TempDir("", "elpa")
 		if err != nil {
@@ -176,65 +311,5 @@ var ElispBackend = api.LanguageBackend{
 	GuessRegexps: util.Regexps([]string{
 		`\(\s*require\s*'\s*([^)[:space:]]+)[^)]*\)`,
 	}),
-	Guess: func() (map[api.PkgName]bool, bool) {
-		r := regexp.MustCompile(
-			`\(\s*require\s*'\s*([^)[:space:]]+)[^)]*\)`,
-		)
-		required := map[string]bool{}
-		for _, match := range util.SearchRecursive(r, elispPatterns) {
-			required[match[1]] = true
-		}
-
-		if len(required) == 0 {
-			return map[api.PkgName]bool{}, true
-		}
-
-		r = regexp.MustCompile(
-			`\(\s*provide\s*'\s*([^)[:space:]]+)[^)]*\)`,
-		)
-		provided := map[string]bool{}
-		for _, match := range util.SearchRecursive(r, elispPatterns) {
-			provided[match[1]] = true
-		}
-
-		tempdir, err := ioutil.TempDir("", "epkgs")
-		if err != nil {
-			util.Die("%s", err)
-		}
-		defer os.RemoveAll(tempdir)
-
-		url := "https://github.com/emacsmirror/epkgs/raw/master/epkg.sqlite"
-		epkgs := filepath.Join(tempdir, "epkgs.sqlite")
-		util.DownloadFile(epkgs, url)
-
-		clauses := []string{}
-		for feature := range required {
-			if strings.ContainsAny(feature, `\'`) {
-				continue
-			}
-			if provided[feature] {
-				continue
-			}
-			clauses = append(clauses, fmt.Sprintf("feature = '%s'", feature))
-		}
-		if len(clauses) == 0 {
-			return map[api.PkgName]bool{}, true
-		}
-		where := strings.Join(clauses, " OR ")
-		query := fmt.Sprintf("SELECT package FROM provided PR WHERE (%s) "+
-			"AND NOT EXISTS (SELECT 1 FROM builtin_libraries B "+
-			"WHERE PR.feature = B.feature) "+
-			"AND NOT EXISTS (SELECT 1 FROM packages PK "+
-			"WHERE PR.package = PK.name AND PK.class = 'builtin');",
-			where,
-		)
-		output := string(util.GetCmdOutput([]string{"sqlite3", epkgs, query}))
-
-		r = regexp.MustCompile(`"(.+?)"`)
-		names := map[api.PkgName]bool{}
-		for _, match := range r.FindAllStringSubmatch(output, -1) {
-			names[api.PkgName(match[1])] = true
-		}
-		return names, true
-	},
+	GuessExplain: elispGuessExplain,
 }
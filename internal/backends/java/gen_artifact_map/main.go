@@ -0,0 +1,107 @@
+// This command generates go source holding a mapping of:
+// java packages -> most likely maven artifact
+// java packages -> other artifacts providing them
+// and
+// artifacts -> dependents
+//
+// these are provided as the maps packageToArtifact,
+// packageToArtifactCandidates and artifactToDependents respectively.
+// Artifacts are given as groupId:artifactId, like package names in the
+// Java backend.
+//
+// The input is a Maven Central class index dump with one JSON object per
+// line, e.g.
+// {"g":"com.google.code.gson","a":"gson","p":["com.google.gson"],"d":1000}
+// giving the groupId, the artifactId, the packages containing classes in
+// its latest version, and the number of artifacts that depend on it (Maven
+// Central has no download counts).
+package main
+
+import (
+	"strings"
+
+	"github.com/replit/upm/internal/genmap"
+)
+
+type indexEntry struct {
+	GroupId    string   `json:"g"`
+	ArtifactId string   `json:"a"`
+	Packages   []string `json:"p"`
+	Dependents int      `json:"d"`
+}
+
+// isJDKPackage returns true if the package comes with the JDK, as we never
+// want to guess that one of them is provided by an artifact.
+func isJDKPackage(pkg string) bool {
+	for _, prefix := range []string{"java.", "javax.", "jdk."} {
+		if strings.HasPrefix(pkg+".", prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// groupMatch returns true if the package is under the groupId of the
+// artifact, given as groupId:artifactId, following the Maven naming
+// conventions, e.g. "org.slf4j" for the package "org.slf4j.spi".
+// Repackaged copies of a library generally aren't.
+func groupMatch(artifact string, pkg string) bool {
+	group := artifact[:strings.IndexByte(artifact, ':')]
+	return pkg == group || strings.HasPrefix(pkg, group+".")
+}
+
+func main() {
+	from, pkg, out := genmap.Flags()
+
+	entries := []*indexEntry{}
+	genmap.ReadIndex(from, func() interface{} {
+		e := &indexEntry{}
+		entries = append(entries, e)
+		return e
+	})
+
+	pkgs := genmap.Candidates{}
+	for _, e := range entries {
+		artifact := &genmap.Package{
+			Name:  e.GroupId + ":" + e.ArtifactId,
+			Count: e.Dependents,
+		}
+		for _, p := range e.Packages {
+			if isJDKPackage(p) {
+				continue
+			}
+			pkgs.Add(p, artifact)
+		}
+	}
+	sortedPkgs := pkgs.Rank()
+
+	w := genmap.NewWriter(pkg)
+
+	chosen := w.ChosenMap("packageToArtifact", `
+packageToArtifact holds a map of all known java packages to their
+corresponding best matching artifact. This helps us guess which artifacts
+should be added for the given imports.
+`, pkgs, sortedPkgs, genmap.Rules{
+		Match:        groupMatch,
+		MatchComment: "group match",
+		MinCount:     10,
+		Noun:         "artifact",
+		CountNoun:    "dependent",
+		CountAbbr:    "deps",
+	})
+
+	dependents := w.CandidatesMap("packageToArtifactCandidates", `
+packageToArtifactCandidates holds a map of every package in
+packageToArtifact which is provided by more than one artifact to the other
+artifacts which provide it, most depended on first. This is used to work
+out how confident a guess is.
+`, pkgs, sortedPkgs, chosen)
+
+	w.CountMap("artifactToDependents", `
+artifactToDependents holds a map of every artifact providing a package in
+packageToArtifactCandidates to the number of artifacts on Maven Central
+which depend on it.
+`, dependents)
+
+	w.WriteFile(out)
+}
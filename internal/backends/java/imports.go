@@ -0,0 +1,287 @@
+package java
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// javaGuessRegexps is the GuessRegexps value for JavaBackend. It
+// matches the package and import declarations.
+var javaGuessRegexps = util.Regexps([]string{
+	`(?m)^\s*package\s+([\w.]+)`,
+	`(?m)^\s*import\s+(?:static\s+)?([\w.*]+)`,
+})
+
+// javaJDKPackages are the prefixes of the packages that come with the
+// JDK.
+var javaJDKPackages = []string{"java", "javax", "jdk"}
+
+// javaImport is an import declaration found by findJavaImports.
+type javaImport struct {
+	// The imported name, e.g. "com.google.gson.Gson" or
+	// "org.junit.Assert.*".
+	name string
+
+	// Whether it is a static import.
+	static bool
+
+	// The 1-based line of the declaration.
+	line int
+}
+
+// pkg returns the package that the import is from, e.g.
+// "com.google.gson" for "com.google.gson.Gson". Class names are told
+// apart by their initial capital, following the Java naming
+// conventions.
+func (imp javaImport) pkg() string {
+	parts := strings.Split(imp.name, ".")
+	for i, part := range parts {
+		if part != "" && 'A' <= part[0] && part[0] <= 'Z' {
+			return strings.Join(parts[:i], ".")
+		}
+	}
+	// Otherwise, the last part is a class name or "*", and so is
+	// the one before it in a static import.
+	n := len(parts) - 1
+	if imp.static && n > 0 {
+		n--
+	}
+	return strings.Join(parts[:n], ".")
+}
+
+// isJavaNameByte returns true if c can be part of a Java identifier,
+// which may contain "$", as javac uses for the names of nested
+// classes.
+func isJavaNameByte(c byte) bool {
+	return c == '$' || util.IsIdentByte(c)
+}
+
+// skipJavaParens returns the offset just after the parenthesis which
+// closes the one at i in src, skipping over comments and string and
+// character literals.
+func skipJavaParens(src string, i int) int {
+	depth := 0
+	for i < len(src) {
+		i = util.SkipCTrivia(src, i, false)
+		if i == len(src) {
+			break
+		}
+		switch src[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		case '"', '\'':
+			quote := src[i]
+			// Text blocks can't contain unescaped triple
+			// quotes, so it's enough to look for the next
+			// unescaped quote each time.
+			for i++; i < len(src) && src[i] != quote; i++ {
+				if src[i] == '\\' {
+					i++
+				}
+			}
+		}
+		i++
+	}
+	return i
+}
+
+// findJavaImports returns the package declared by Java source code (or
+// the empty string if there is none) and its import declarations, in
+// the order they appear. Only the declarations at the start of the
+// file are read, since they can't come after type declarations.
+func findJavaImports(src string) (string, []javaImport) {
+	pkg := ""
+	imports := []javaImport{}
+	i := 0
+	for {
+		// Block comments don't nest in Java.
+		i = util.SkipCTrivia(src, i, false)
+		if i == len(src) {
+			break
+		}
+
+		// Annotations, like @Deprecated or
+		// @SuppressWarnings("unused"), may come before the
+		// package declaration.
+		if src[i] == '@' {
+			i++
+			for i < len(src) && (isJavaNameByte(src[i]) || src[i] == '.') {
+				i++
+			}
+			i = util.SkipCTrivia(src, i, false)
+			if i < len(src) && src[i] == '(' {
+				i = skipJavaParens(src, i)
+			}
+			continue
+		}
+
+		// Stray semicolons are allowed between imports.
+		if src[i] == ';' {
+			i++
+			continue
+		}
+
+		start := i
+		for i < len(src) && isJavaNameByte(src[i]) {
+			i++
+		}
+		keyword := src[start:i]
+		if keyword != "package" && keyword != "import" {
+			break
+		}
+
+		// Read the rest of the declaration, which is a
+		// qualified name, maybe with a "static" modifier.
+		line := 1 + strings.Count(src[:start], "\n")
+		static := false
+		var name strings.Builder
+		for {
+			i = util.SkipCTrivia(src, i, false)
+			if i == len(src) || src[i] == ';' {
+				break
+			}
+			c := src[i]
+			if isJavaNameByte(c) {
+				wordStart := i
+				for i < len(src) && isJavaNameByte(src[i]) {
+					i++
+				}
+				word := src[wordStart:i]
+				if word == "static" && keyword == "import" && name.Len() == 0 {
+					static = true
+				} else {
+					name.WriteString(word)
+				}
+				continue
+			}
+			if c == '.' || c == '*' {
+				name.WriteByte(c)
+			}
+			i++
+		}
+		if i < len(src) {
+			i++
+		}
+
+		if keyword == "package" {
+			pkg = name.String()
+		} else if name.Len() > 0 {
+			imports = append(imports, javaImport{name: name.String(), static: static, line: line})
+		}
+	}
+	return pkg, imports
+}
+
+// isJavaSubpackage returns true if pkg is the same as parent or is
+// inside it.
+func isJavaSubpackage(pkg string, parent string) bool {
+	return pkg == parent || strings.HasPrefix(pkg, parent+".")
+}
+
+// importArtifact returns the reason for guessing the artifact that
+// provides the package of an import. If the package itself isn't
+// known, its enclosing packages are tried, since the index may not
+// have every subpackage, but only those with at least three segments:
+// a package like "com.google" is shared by too many artifacts to say
+// which one provides its subpackages. The second return value is
+// false if no artifact is known.
+func importArtifact(imp javaImport) (api.GuessReason, bool) {
+	pkg := imp.pkg()
+	artifact := ""
+	for {
+		if a, ok := packageToArtifact()[pkg]; ok {
+			artifact = a
+			break
+		}
+		end := strings.LastIndexByte(pkg, '.')
+		if end == -1 || strings.Count(pkg[:end], ".") < 2 {
+			return api.GuessReason{}, false
+		}
+		pkg = pkg[:end]
+	}
+
+	// The index counts the dependents of artifacts rather than
+	// their downloads.
+	confidence, alternatives := api.DownloadShare(
+		artifact, packageToArtifactCandidates()[pkg], artifactToDependents(),
+	)
+
+	return api.GuessReason{
+		Package:      api.PkgName(artifact),
+		Module:       imp.name,
+		Source:       "class-index",
+		Confidence:   confidence,
+		Alternatives: alternatives,
+	}, true
+}
+
+// isJavaBuildOutput returns true if the file is in a target or build
+// directory, where Maven and Gradle put the build output, including
+// the sources that annotation processors and code generators write.
+func isJavaBuildOutput(file string) bool {
+	for _, dir := range strings.Split(filepath.ToSlash(filepath.Dir(file)), "/") {
+		if dir == "target" || dir == "build" {
+			return true
+		}
+	}
+	return false
+}
+
+// javaGuessExplain implements GuessExplain for JavaBackend. There is a
+// reason for each file that imports from an artifact, at the first
+// such import in the file. Imports from the JDK and from the packages
+// declared by the project itself are left out.
+func javaGuessExplain() []api.GuessReason {
+	files := []string{}
+	fileImports := map[string][]javaImport{}
+	own := []string{}
+	for _, file := range util.ListFilesRecursive(javaPatterns) {
+		if isJavaBuildOutput(file) {
+			continue
+		}
+		files = append(files, file)
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			util.Die("%s: %s", file, err)
+		}
+		pkg, imports := findJavaImports(string(contents))
+		if pkg != "" {
+			own = append(own, pkg)
+		}
+		fileImports[file] = imports
+	}
+
+	excluded := append(own, javaJDKPackages...)
+
+	reasons := []api.GuessReason{}
+	for _, file := range files {
+		seen := map[api.PkgName]bool{}
+	nextImport:
+		for _, imp := range fileImports[file] {
+			pkg := imp.pkg()
+			for _, parent := range excluded {
+				if isJavaSubpackage(pkg, parent) {
+					continue nextImport
+				}
+			}
+			reason, ok := importArtifact(imp)
+			if !ok || seen[reason.Package] {
+				continue
+			}
+			seen[reason.Package] = true
+			reason.File = file
+			reason.Line = imp.line
+			reasons = append(reasons, reason)
+		}
+	}
+	return reasons
+}
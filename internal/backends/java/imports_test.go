@@ -0,0 +1,201 @@
+package java
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFindJavaImports(t *testing.T) {
+	tcs := []struct {
+		scenario string
+		src      string
+		pkg      string
+		expected []javaImport
+	}{
+		{
+			scenario: "Returns the package and import declarations",
+			src: `package com.example.app;
+
+import com.google.gson.Gson;
+import static org.junit.Assert.assertEquals;
+import org.slf4j.*;
+import java.util.List;
+
+public class App {}
+`,
+			pkg: "com.example.app",
+			expected: []javaImport{
+				{name: "com.google.gson.Gson", line: 3},
+				{name: "org.junit.Assert.assertEquals", static: true, line: 4},
+				{name: "org.slf4j.*", line: 5},
+				{name: "java.util.List", line: 6},
+			},
+		},
+		{
+			scenario: "Skips comments and annotations",
+			src: `// import commented.out.Thing;
+/* import also.commented.Thing; */
+@Deprecated
+@SuppressWarnings("unchecked (really)")
+package com.example;
+
+import com.google /* split */ .gson.Gson;;
+`,
+			pkg: "com.example",
+			expected: []javaImport{
+				{name: "com.google.gson.Gson", line: 7},
+			},
+		},
+		{
+			scenario: "Stops at the first type declaration",
+			src: `import a.b.C;
+
+class Main {
+    String s = "import d.e.F;";
+}
+
+import g.h.I;
+`,
+			expected: []javaImport{
+				{name: "a.b.C", line: 1},
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.scenario, func(t *testing.T) {
+			pkg, imports := findJavaImports(tc.src)
+			if pkg != tc.pkg {
+				t.Errorf("expected package %q, got %q", tc.pkg, pkg)
+			}
+			if !reflect.DeepEqual(imports, tc.expected) {
+				t.Errorf("expected %+v, got %+v", tc.expected, imports)
+			}
+		})
+	}
+}
+
+func TestJavaImportPkg(t *testing.T) {
+	tcs := []struct {
+		imp      javaImport
+		expected string
+	}{
+		{javaImport{name: "com.google.gson.Gson"}, "com.google.gson"},
+		{javaImport{name: "com.google.gson.reflect.TypeToken.Inner"}, "com.google.gson.reflect"},
+		{javaImport{name: "com.google.gson.*"}, "com.google.gson"},
+		{javaImport{name: "org.junit.Assert.*", static: true}, "org.junit"},
+		{javaImport{name: "org.example.util.helpers.run", static: true}, "org.example.util"},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.imp.name, func(t *testing.T) {
+			if pkg := tc.imp.pkg(); pkg != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, pkg)
+			}
+		})
+	}
+}
+
+func TestImportArtifact(t *testing.T) {
+	defer func(artifacts, candidates map[string]string, dependents map[string]int) {
+		packageToArtifactCached = artifacts
+		packageToArtifactCandidatesCached = candidates
+		artifactToDependentsCached = dependents
+	}(packageToArtifactCached, packageToArtifactCandidatesCached, artifactToDependentsCached)
+	packageToArtifactCached = map[string]string{
+		"com.google.gson":        "com.google.code.gson:gson",
+		"com.google.common.base": "com.google.guava:guava",
+		"org.json":               "org.json:json",
+	}
+	packageToArtifactCandidatesCached = map[string]string{
+		"org.json": "com.vaadin.external.google:android-json",
+	}
+	artifactToDependentsCached = map[string]int{
+		"com.vaadin.external.google:android-json": 300,
+		"org.json:json": 9000,
+	}
+
+	tcs := []struct {
+		name     string
+		expected string
+	}{
+		{"com.google.gson.Gson", "com.google.code.gson:gson"},
+		{"com.google.gson.internal.bind.TypeAdapters", "com.google.code.gson:gson"},
+		{"com.google.common.base.Strings", "com.google.guava:guava"},
+		{"org.json.JSONObject", "org.json:json"},
+		// Prefixes with fewer than three segments need an exact
+		// match.
+		{"com.google.common.io.Files", ""},
+		{"org.json.util.Helper", ""},
+		{"org.Example", ""},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			reason, ok := importArtifact(javaImport{name: tc.name})
+			if string(reason.Package) != tc.expected || ok != (tc.expected != "") {
+				t.Errorf("expected %q, got %q (%v)", tc.expected, reason.Package, ok)
+			}
+		})
+	}
+
+	reason, _ := importArtifact(javaImport{name: "org.json.JSONArray"})
+	if len(reason.Alternatives) != 1 || reason.Confidence <= 0 {
+		t.Errorf("expected one alternative and a confidence, got %+v", reason)
+	}
+}
+
+func TestJavaGuessExplainSkipsBuildOutput(t *testing.T) {
+	defer func(artifacts map[string]string) {
+		packageToArtifactCached = artifacts
+	}(packageToArtifactCached)
+	packageToArtifactCached = map[string]string{
+		"com.google.gson": "com.google.code.gson:gson",
+		"org.json":        "org.json:json",
+		"org.slf4j":       "org.slf4j:slf4j-api",
+	}
+
+	dir, err := ioutil.TempDir("", "upm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Generated sources declare packages that would hide the
+	// artifacts, and import artifacts that the project doesn't.
+	files := map[string]string{
+		"src/main/java/com/example/App.java":                     "package com.example;\nimport org.json.JSONObject;\nimport com.google.gson.Gson;\n",
+		"target/generated-sources/annotations/org/json/Gen.java": "package org.json;\nimport org.slf4j.Logger;\n",
+		"build/generated/sources/com/google/gson/Gen.java":       "package com.google.gson;\n",
+	}
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+
+	pkgs := []string{}
+	for _, reason := range javaGuessExplain() {
+		pkgs = append(pkgs, string(reason.Package))
+	}
+	expected := []string{"org.json:json", "com.google.code.gson:gson"}
+	if !reflect.DeepEqual(pkgs, expected) {
+		t.Errorf("expected %v, got %v", expected, pkgs)
+	}
+}
@@ -7,11 +7,16 @@ import (
 	"io/ioutil"
 	"os"
 	"regexp"
+	"time"
 
 	"github.com/replit/upm/internal/api"
 	"github.com/replit/upm/internal/util"
 )
 
+// this generates a mapping of java packages -> maven artifacts
+// packageToArtifact packageToArtifactCandidates artifactToDependents are provided
+//go:generate go run ./gen_artifact_map -from maven_index.json -pkg java -out artifact_map.gen.go
+
 type Dependency struct {
 	XMLName     xml.Name `xml:"dependency"`
 	GroupId     string   `xml:"groupId"`
@@ -260,33 +265,105 @@ func search(query string) []api.PkgInfo {
 	if err != nil {
 		util.Die("error searching maven %s", err)
 	}
+	return searchDocsToPkgInfos(searchDocs)
+}
+
+// maxSearchRows is the most results that Maven Central will return
+// for one search request.
+const maxSearchRows = 200
+
+func searchPaged(query string, offset int, limit int) []api.PkgInfo {
+	return api.FetchChunks(offset, limit, maxSearchRows, func(start int, rows int) []api.PkgInfo {
+		searchDocs, err := SearchPaged(query, start, rows)
+		if err != nil {
+			util.Die("error searching maven %s", err)
+		}
+		return searchDocsToPkgInfos(searchDocs)
+	})
+}
+
+func searchDocsToPkgInfos(searchDocs []SearchDoc) []api.PkgInfo {
 	pkgInfos := []api.PkgInfo{}
 	for _, searchDoc := range searchDocs {
 		pkgInfo := api.PkgInfo{
-			Name:    fmt.Sprintf("%s:%s", searchDoc.Group, searchDoc.Artifact),
-			Version: searchDoc.Version,
+			Name:        fmt.Sprintf("%s:%s", searchDoc.Group, searchDoc.Artifact),
+			Version:     searchDoc.Version,
+			PublishedAt: timestampToTime(searchDoc.Timestamp),
 		}
 		pkgInfos = append(pkgInfos, pkgInfo)
 	}
 	return pkgInfos
 }
 
+// timestampToTime converts a Maven Central timestamp (in
+// milliseconds) to a time, returning nil if it is missing.
+func timestampToTime(timestamp int64) *time.Time {
+	if timestamp == 0 {
+		return nil
+	}
+	t := time.Unix(0, timestamp*int64(time.Millisecond)).UTC()
+	return &t
+}
+
+// mavenPrereleaseRegexp matches the common qualifiers for Maven
+// versions which aren't final releases, like 2.0.0-M1, 5.0.0-beta-1,
+// or 1.0.0.RC1.
+var mavenPrereleaseRegexp = regexp.MustCompile(`(?i)[.-](alpha|beta|rc|cr|m|milestone|snapshot|preview|pre)[.-]?[0-9]*([.-]|$)`)
+
+// toPkgInfo returns the information for the version of an artifact
+// given by searchDoc, where searchDocs are all its versions.
+func toPkgInfo(searchDoc SearchDoc, searchDocs []SearchDoc) api.PkgInfo {
+	pkgInfo := api.PkgInfo{
+		Name:        fmt.Sprintf("%s:%s", searchDoc.Group, searchDoc.Artifact),
+		Version:     searchDoc.CurrentVersion,
+		PublishedAt: timestampToTime(searchDoc.Timestamp),
+	}
+
+	// Maven Central lists versions from newest to oldest. If we
+	// only had an artifact name, the results may include other
+	// groups, which we skip.
+	for i := len(searchDocs) - 1; i >= 0; i-- {
+		doc := searchDocs[i]
+		if doc.Group != searchDoc.Group || doc.Artifact != searchDoc.Artifact {
+			continue
+		}
+		pkgInfo.Versions = append(pkgInfo.Versions, api.PkgVersionInfo{
+			Version:     doc.CurrentVersion,
+			PublishedAt: timestampToTime(doc.Timestamp),
+			Prerelease:  mavenPrereleaseRegexp.MatchString(doc.CurrentVersion),
+		})
+	}
+	return pkgInfo
+}
+
 func info(pkgName api.PkgName) api.PkgInfo {
-	searchDoc, err := Info(string(pkgName))
+	searchDocs, err := Versions(string(pkgName))
 
 	if err != nil {
 		util.Die("error searching maven %s", err)
 	}
 
-	if searchDoc.Artifact == "" {
+	if len(searchDocs) == 0 || searchDocs[0].Artifact == "" {
 		return api.PkgInfo{}
 	}
 
-	pkgInfo := api.PkgInfo{
-		Name:    fmt.Sprintf("%s:%s", searchDoc.Group, searchDoc.Artifact),
-		Version: searchDoc.CurrentVersion,
+	return toPkgInfo(searchDocs[0], searchDocs)
+}
+
+func versionInfo(pkgName api.PkgName, version api.PkgVersion) api.PkgInfo {
+	searchDocs, err := Versions(string(pkgName))
+
+	if err != nil {
+		util.Die("error searching maven %s", err)
 	}
-	return pkgInfo
+
+	for _, searchDoc := range searchDocs {
+		if searchDoc.CurrentVersion == string(version) {
+			return toPkgInfo(searchDoc, searchDocs)
+		}
+	}
+
+	return api.PkgInfo{}
 }
 
 // JavaBackend is the UPM language backend for Java using Maven.
@@ -295,14 +372,18 @@ var JavaBackend = api.LanguageBackend{
 	Specfile:         pomdotxml,
 	Lockfile:         pomdotxml,
 	FilenamePatterns: javaPatterns,
+	Executables:      []string{"mvn"},
 	Quirks:           api.QuirksAddRemoveAlsoLocks,
 	GetPackageDir: func() string {
 		return "target/dependency"
 	},
-	Search: search,
-	Info:   info,
-	Add:    addPackages,
-	Remove: removePackages,
+	PackageDirMarkers: []string{},
+	Search:            search,
+	SearchPaged:       searchPaged,
+	Info:              info,
+	VersionInfo:       versionInfo,
+	Add:               addPackages,
+	Remove:            removePackages,
 	Install: func() {
 		util.RunCmd([]string{
 			"mvn",
@@ -313,4 +394,6 @@ var JavaBackend = api.LanguageBackend{
 	ListSpecfile: listSpecfile,
 	ListLockfile: listLockfile,
 	Lock:         func() {},
+	GuessRegexps: javaGuessRegexps,
+	GuessExplain: javaGuessExplain,
 }
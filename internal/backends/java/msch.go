@@ -42,6 +42,7 @@ type SearchDoc struct {
 	Version        string `json:"latestVersion"`
 	PackageType    string `json:"p"`
 	CurrentVersion string `json:"v"`
+	Timestamp      int64  `json:"timestamp"`
 }
 
 type SearchResult struct {
@@ -78,7 +79,20 @@ func Search(keyword string) ([]SearchDoc, error) {
 	return mavenSearch(searchURL)
 }
 
-func Info(name string) (SearchDoc, error) {
+// SearchPaged is like Search, but returns rows results after
+// skipping the first start results.
+func SearchPaged(keyword string, start int, rows int) ([]SearchDoc, error) {
+	searchURL := fmt.Sprintf("%s%s&start=%d&rows=%d", mavenURL, url.QueryEscape(keyword), start, rows)
+
+	return mavenSearch(searchURL)
+}
+
+// maxVersions is the number of versions requested by Versions.
+const maxVersions = 200
+
+// Versions returns the published versions of the named artifact,
+// newest first.
+func Versions(name string) ([]SearchDoc, error) {
 	parts := strings.Split(string(name), ":")
 
 	var searchURL string
@@ -87,8 +101,13 @@ func Info(name string) (SearchDoc, error) {
 	} else {
 		searchURL = fmt.Sprintf("%sa:%s&core=gav", mavenURL, url.QueryEscape(fmt.Sprintf("%q", parts[0])))
 	}
+	searchURL += fmt.Sprintf("&rows=%d", maxVersions)
 
-	docs, err := mavenSearch(searchURL)
+	return mavenSearch(searchURL)
+}
+
+func Info(name string) (SearchDoc, error) {
+	docs, err := Versions(name)
 
 	if err != nil {
 		return SearchDoc{}, err
@@ -0,0 +1,312 @@
+package nodejs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// tsconfigJSON represents the relevant data in a tsconfig.json or
+// jsconfig.json file.
+type tsconfigJSON struct {
+	// Either a string or, since TypeScript 5.0, an array of
+	// strings.
+	Extends         json.RawMessage `json:"extends"`
+	CompilerOptions struct {
+		BaseURL *string             `json:"baseUrl"`
+		Paths   map[string][]string `json:"paths"`
+	} `json:"compilerOptions"`
+}
+
+// localModules describes the import paths which refer to modules in
+// the project itself, rather than to packages, as read by
+// readLocalModules.
+type localModules struct {
+	// The keys of the TypeScript paths options, e.g. "@/*".
+	paths []string
+
+	// The directories that the TypeScript baseUrl options
+	// resolve non-relative imports against.
+	baseURLs []string
+
+	// The names of the workspace packages, and of the project
+	// itself, which can import itself by name.
+	packages map[api.PkgName]bool
+}
+
+// stripJSONComments returns JSON with comments, as used by
+// tsconfig.json, as plain JSON: without comments or trailing commas.
+func stripJSONComments(src []byte) []byte {
+	out := make([]byte, 0, len(src))
+	// The index in out of a comma which may turn out to be
+	// trailing, or -1.
+	comma := -1
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case c == '"':
+			start := i
+			for i++; i < len(src) && src[i] != '"'; i++ {
+				if src[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(src) {
+				i = len(src) - 1
+			}
+			out = append(out, src[start:i+1]...)
+			comma = -1
+
+		case c == '/' && i+1 < len(src) && src[i+1] == '/':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+			i--
+
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			end := strings.Index(string(src[i+2:]), "*/")
+			if end == -1 {
+				return out
+			}
+			i += 2 + end + 1
+
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			out = append(out, c)
+
+		case (c == '}' || c == ']') && comma != -1:
+			out = append(out[:comma], out[comma+1:]...)
+			out = append(out, c)
+			comma = -1
+
+		default:
+			if c == ',' {
+				comma = len(out)
+			} else {
+				comma = -1
+			}
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// resolveTsconfigExtends returns the path of the config file named by
+// an extends option in the config file in dir, or the empty string if
+// there isn't one.
+func resolveTsconfigExtends(dir string, extends string) string {
+	candidates := []string{}
+	if strings.HasPrefix(extends, ".") || filepath.IsAbs(extends) {
+		candidates = append(candidates, filepath.Join(dir, extends))
+	} else {
+		// A package, which may be installed in any enclosing
+		// node_modules directory.
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return ""
+		}
+		for {
+			candidates = append(candidates, filepath.Join(abs, "node_modules", extends))
+			parent := filepath.Dir(abs)
+			if parent == abs {
+				break
+			}
+			abs = parent
+		}
+	}
+
+	for _, candidate := range candidates {
+		for _, path := range []string{candidate, candidate + ".json", filepath.Join(candidate, "tsconfig.json")} {
+			if info, err := os.Stat(path); err == nil && !info.IsDir() {
+				return path
+			}
+		}
+	}
+	return ""
+}
+
+// readTsconfig reads the paths and baseUrl options from a tsconfig.json
+// or jsconfig.json file, following extends. The baseUrl is resolved
+// relative to the file which sets it, and is the empty string if no
+// file does. Options set by a file replace those from the files it
+// extends.
+//
+// The TypeScript compiler is the judge of whether a tsconfig.json file
+// is valid, not UPM, so a file which can't be read only gets a warning
+// and sets no options.
+func readTsconfig(path string, seen map[string]bool) ([]string, string) {
+	if seen[path] {
+		return nil, ""
+	}
+	seen[path] = true
+
+	contentsB, err := ioutil.ReadFile(path)
+	if err != nil {
+		util.Log(fmt.Sprintf("%s: %s, so its path aliases were ignored", path, err))
+		return nil, ""
+	}
+	var cfg tsconfigJSON
+	if err := json.Unmarshal(stripJSONComments(contentsB), &cfg); err != nil {
+		util.Log(fmt.Sprintf("%s: %s, so its path aliases were ignored", path, err))
+		return nil, ""
+	}
+
+	var extends []string
+	if len(cfg.Extends) > 0 {
+		var single string
+		if err := json.Unmarshal(cfg.Extends, &single); err == nil {
+			extends = []string{single}
+		} else if err := json.Unmarshal(cfg.Extends, &extends); err != nil {
+			util.Log(fmt.Sprintf("%s: extends: %s, so it was ignored", path, err))
+			extends = nil
+		}
+	}
+
+	var paths []string
+	baseURL := ""
+	dir := filepath.Dir(path)
+	for _, parent := range extends {
+		if parentPath := resolveTsconfigExtends(dir, parent); parentPath != "" {
+			parentPaths, parentBaseURL := readTsconfig(parentPath, seen)
+			if parentPaths != nil {
+				paths = parentPaths
+			}
+			if parentBaseURL != "" {
+				baseURL = parentBaseURL
+			}
+		}
+	}
+
+	if cfg.CompilerOptions.Paths != nil {
+		paths = []string{}
+		for pattern := range cfg.CompilerOptions.Paths {
+			paths = append(paths, pattern)
+		}
+	}
+	if cfg.CompilerOptions.BaseURL != nil {
+		baseURL = filepath.Join(dir, *cfg.CompilerOptions.BaseURL)
+	}
+	return paths, baseURL
+}
+
+// readWorkspaces returns the directories of the workspace packages
+// listed in the workspaces field of a package.json file, which is
+// either an array of globs or, for Yarn, an object with such an array
+// in its packages field.
+func readWorkspaces(dir string, workspaces json.RawMessage) []string {
+	if len(workspaces) == 0 {
+		return nil
+	}
+	var patterns []string
+	if err := json.Unmarshal(workspaces, &patterns); err != nil {
+		var yarn struct {
+			Packages []string `json:"packages"`
+		}
+		if err := json.Unmarshal(workspaces, &yarn); err != nil {
+			util.Die("%s: workspaces: %s", filepath.Join(dir, "package.json"), err)
+		}
+		patterns = yarn.Packages
+	}
+
+	dirs := []string{}
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			continue
+		}
+		for _, match := range matches {
+			if util.Exists(filepath.Join(match, "package.json")) {
+				dirs = append(dirs, match)
+			}
+		}
+	}
+	return dirs
+}
+
+// readPackageJSON reads a package.json file.
+func readPackageJSON(path string) packageJSON {
+	contentsB, err := ioutil.ReadFile(path)
+	if err != nil {
+		util.Die("%s: %s", path, err)
+	}
+	var cfg packageJSON
+	if err := json.Unmarshal(contentsB, &cfg); err != nil {
+		util.Die("%s: %s", path, err)
+	}
+	return cfg
+}
+
+// readLocalModules reads the configuration of the project in dir, and
+// of its workspace packages, to work out which import paths refer to
+// the project itself.
+func readLocalModules(dir string) localModules {
+	local := localModules{packages: map[api.PkgName]bool{}}
+
+	dirs := []string{dir}
+	if path := filepath.Join(dir, "package.json"); util.Exists(path) {
+		dirs = append(dirs, readWorkspaces(dir, readPackageJSON(path).Workspaces)...)
+	}
+
+	for _, pkgDir := range dirs {
+		if path := filepath.Join(pkgDir, "package.json"); util.Exists(path) {
+			if name := readPackageJSON(path).Name; name != "" {
+				local.packages[api.PkgName(name)] = true
+			}
+		}
+
+		for _, name := range []string{"tsconfig.json", "jsconfig.json"} {
+			path := filepath.Join(pkgDir, name)
+			if !util.Exists(path) {
+				continue
+			}
+			paths, baseURL := readTsconfig(path, map[string]bool{})
+			local.paths = append(local.paths, paths...)
+			if baseURL != "" {
+				local.baseURLs = append(local.baseURLs, baseURL)
+			}
+		}
+	}
+	return local
+}
+
+// matchesPathsPattern returns true if an import path matches a key of
+// the TypeScript paths option, which may contain one "*" wildcard.
+func matchesPathsPattern(mod string, pattern string) bool {
+	star := strings.IndexByte(pattern, '*')
+	if star == -1 {
+		return mod == pattern
+	}
+	prefix, suffix := pattern[:star], pattern[star+1:]
+	return len(mod) >= len(prefix)+len(suffix) &&
+		strings.HasPrefix(mod, prefix) && strings.HasSuffix(mod, suffix)
+}
+
+// isLocal returns true if an import path, which importPackage says is
+// of the given package, refers to the project itself.
+func (local localModules) isLocal(mod string, pkg api.PkgName) bool {
+	if local.packages[pkg] {
+		return true
+	}
+	for _, pattern := range local.paths {
+		if matchesPathsPattern(mod, pattern) {
+			return true
+		}
+	}
+	for _, baseURL := range local.baseURLs {
+		path := filepath.Join(baseURL, filepath.FromSlash(mod))
+		if util.Exists(path) {
+			return true
+		}
+		for _, ext := range []string{".ts", ".tsx", ".d.ts", ".js", ".jsx", ".json"} {
+			if util.Exists(path + ext) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package nodejs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/replit/upm/internal/api"
+)
+
+func TestStripJSONComments(t *testing.T) {
+	src := `{
+  // A comment
+  "compilerOptions": { /* another */
+    "baseUrl": "./src", // trailing
+    "paths": {"@/*": ["./*"], "http://x": [],},
+  },
+}`
+	var cfg tsconfigJSON
+	if err := json.Unmarshal(stripJSONComments([]byte(src)), &cfg); err != nil {
+		t.Fatalf("%s: %s", err, stripJSONComments([]byte(src)))
+	}
+	if *cfg.CompilerOptions.BaseURL != "./src" || len(cfg.CompilerOptions.Paths) != 2 {
+		t.Errorf("unexpected %+v", cfg.CompilerOptions)
+	}
+}
+
+func TestReadLocalModules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "upm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"package.json": `{"name": "my-app", "workspaces": ["packages/*"]}`,
+		"tsconfig.base.json": `{
+			"compilerOptions": {"baseUrl": ".", "paths": {"@shared/*": ["shared/*"]}}
+		}`,
+		"tsconfig.json": `{
+			// The paths here replace those in the base.
+			"extends": "./tsconfig.base",
+			"compilerOptions": {"paths": {"@/*": ["src/*"], "~utils": ["src/utils"]}},
+		}`,
+		"lib/format.ts":                 ``,
+		"packages/ui/package.json":      `{"name": "@my-app/ui"}`,
+		"packages/ui/jsconfig.json":     `{"compilerOptions": {"paths": {"#ui/*": ["./*"]}}}`,
+		"packages/empty/.gitkeep":       ``,
+		"packages/server/package.json":  `{"name": "server"}`,
+		"packages/server/tsconfig.json": `{"extends": "@tsconfig/node16/tsconfig.json"}`,
+	}
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	local := readLocalModules(dir)
+	for mod, expected := range map[string]bool{
+		"@/components/Button": true,
+		"~utils":              true,
+		"~utils/strings":      false,
+		"@shared/types":       false,
+		"lib/format":          true,
+		"#ui/button":          true,
+		"@my-app/ui":          true,
+		"@my-app/ui/button":   true,
+		"server":              true,
+		"my-app/package.json": true,
+		"react":               false,
+		"@babel/core":         false,
+	} {
+		pkg, _, ok := importPackage(mod)
+		if !ok {
+			pkg = api.PkgName(mod)
+		}
+		if isLocal := local.isLocal(mod, pkg); isLocal != expected {
+			t.Errorf("expected %v for %s, got %v", expected, mod, isLocal)
+		}
+	}
+}
+
+func TestReadLocalModulesMalformedTsconfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "upm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"package.json": `{"name": "my-app"}`,
+		// A stray comma and an unclosed object.
+		"tsconfig.json": `{"compilerOptions": {"paths": {"@/*": ["src/*"],,}`,
+		// Neither a string nor an array of strings.
+		"jsconfig.json": `{"extends": 42, "compilerOptions": {"paths": {"~lib/*": ["lib/*"]}}}`,
+	}
+	for name, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	local := readLocalModules(dir)
+	for mod, expected := range map[string]bool{
+		"my-app":      true,
+		"@/utils":     false,
+		"~lib/format": true,
+		"react":       false,
+	} {
+		pkg, _, ok := importPackage(mod)
+		if !ok {
+			pkg = api.PkgName(mod)
+		}
+		if isLocal := local.isLocal(mod, pkg); isLocal != expected {
+			t.Errorf("expected %v for %s, got %v", expected, mod, isLocal)
+		}
+	}
+}
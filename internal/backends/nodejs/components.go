@@ -0,0 +1,203 @@
+package nodejs
+
+import (
+	"regexp"
+	"strings"
+)
+
+// scriptSource is a piece of JavaScript or TypeScript code extracted
+// from a project file, as returned by extractScripts.
+type scriptSource struct {
+	// The code, preceded by enough newlines that its lines have
+	// the same numbers as in the file.
+	code string
+
+	// True if the code is TypeScript.
+	ts bool
+
+	// True if the imports may be used outside of the code, e.g.
+	// by a component template, so they mustn't be dropped as
+	// unused when parsing TypeScript.
+	keepImports bool
+}
+
+var (
+	// scriptTagRegexp matches a script element, capturing its
+	// attributes and contents.
+	scriptTagRegexp = regexp.MustCompile(`(?is)<script\b([^>]*)>(.*?)</script\s*>`)
+
+	langAttrRegexp = regexp.MustCompile(`(?i)\blang\s*=\s*["']?([\w-]+)`)
+	typeAttrRegexp = regexp.MustCompile(`(?i)\btype\s*=\s*["']?([^"'\s>]+)`)
+
+	// astroFrontmatterRegexp matches the code fence at the start
+	// of an Astro component, capturing the code.
+	astroFrontmatterRegexp = regexp.MustCompile(`(?sm)\A\s*---[ \t]*\r?\n(.*?)^---[ \t]*\r?$`)
+
+	// mdxESMRegexp matches the first line of a block of import
+	// and export statements in MDX.
+	mdxESMRegexp = regexp.MustCompile(`^(?:import|export)(?:\s|[{*]|$)`)
+
+	// mdxFenceRegexp matches the delimiter of a fenced code block
+	// in Markdown.
+	mdxFenceRegexp = regexp.MustCompile("^ {0,3}(```|~~~)")
+
+	importClauseRegexp = regexp.MustCompile(`(?s)\bimport\s+([^'";]+?)\s+from\s*['"]`)
+	identifierRegexp   = regexp.MustCompile(`^[\p{L}_$][\p{L}\p{N}_$]*$`)
+)
+
+// padLines returns code preceded by a newline for each line of the
+// file before the code starts at offset.
+func padLines(contents string, offset int, code string) string {
+	return strings.Repeat("\n", strings.Count(contents[:offset], "\n")) + code
+}
+
+// scriptElements returns the code of the script elements of a Vue,
+// Svelte or Astro component, starting the search at offset. Scripts
+// are TypeScript if they say so with a lang or type attribute, or
+// otherwise if ts is true. Scripts in other languages, and data
+// blocks like JSON, are skipped.
+func scriptElements(contents string, offset int, ts bool) []scriptSource {
+	sources := []scriptSource{}
+	for _, match := range scriptTagRegexp.FindAllStringSubmatchIndex(contents[offset:], -1) {
+		attrs := contents[offset+match[2] : offset+match[3]]
+		isTS := ts
+		if lang := langAttrRegexp.FindStringSubmatch(attrs); lang != nil {
+			switch strings.ToLower(lang[1]) {
+			case "ts", "tsx", "typescript":
+				isTS = true
+			case "js", "jsx", "javascript":
+				isTS = false
+			default:
+				continue
+			}
+		}
+		if typ := typeAttrRegexp.FindStringSubmatch(attrs); typ != nil {
+			switch strings.ToLower(typ[1]) {
+			case "module", "text/javascript", "application/javascript":
+			case "text/typescript", "application/typescript":
+				isTS = true
+			default:
+				continue
+			}
+		}
+
+		start := offset + match[4]
+		sources = append(sources, scriptSource{
+			code:        padLines(contents, start, contents[start:offset+match[5]]),
+			ts:          isTS,
+			keepImports: true,
+		})
+	}
+	return sources
+}
+
+// mdxESM returns the import and export statements of an MDX file.
+// They are the paragraphs that start with import or export, outside
+// of fenced code blocks.
+func mdxESM(contents string) scriptSource {
+	lines := strings.Split(contents, "\n")
+	fence := ""
+	inESM := false
+	prevBlank := true
+	for i, line := range lines {
+		blank := strings.TrimSpace(line) == ""
+		switch {
+		case fence != "":
+			if match := mdxFenceRegexp.FindStringSubmatch(line); match != nil && match[1] == fence {
+				fence = ""
+			}
+			lines[i] = ""
+		case inESM && !blank:
+		case prevBlank && mdxESMRegexp.MatchString(line):
+			inESM = true
+		default:
+			if match := mdxFenceRegexp.FindStringSubmatch(line); match != nil {
+				fence = match[1]
+			}
+			inESM = false
+			lines[i] = ""
+		}
+		if blank {
+			inESM = false
+		}
+		prevBlank = blank
+	}
+	// The code is JavaScript, whose imports are kept even if
+	// unused.
+	return scriptSource{code: strings.Join(lines, "\n")}
+}
+
+// extractScripts returns the code in a project file. JavaScript and
+// TypeScript files are all code, but only parts of component files
+// are.
+func extractScripts(file string, contents string) []scriptSource {
+	switch getExt(file) {
+	case ".vue", ".svelte":
+		return scriptElements(contents, 0, false)
+
+	case ".astro":
+		// The frontmatter and scripts of Astro components
+		// are TypeScript.
+		sources := []scriptSource{}
+		offset := 0
+		if match := astroFrontmatterRegexp.FindStringSubmatchIndex(contents); match != nil {
+			sources = append(sources, scriptSource{
+				code:        padLines(contents, match[2], contents[match[2]:match[3]]),
+				ts:          true,
+				keepImports: true,
+			})
+			offset = match[1]
+		}
+		return append(sources, scriptElements(contents, offset, true)...)
+
+	case ".mdx":
+		return []scriptSource{mdxESM(contents)}
+
+	case ".ts", ".tsx":
+		return []scriptSource{{code: contents, ts: true}}
+
+	default:
+		return []scriptSource{{code: contents}}
+	}
+}
+
+// referenceImports returns a statement that uses every name bound by
+// the import declarations in code, or the empty string if there are
+// none. TypeScript drops imports that look unused, so this is
+// appended to code whose imports may be used elsewhere.
+func referenceImports(code string) string {
+	names := []string{}
+	for _, match := range importClauseRegexp.FindAllStringSubmatch(code, -1) {
+		clause := match[1]
+		if strings.HasPrefix(clause, "type ") || strings.HasPrefix(clause, "type{") {
+			continue
+		}
+		parts := []string{}
+		if open := strings.IndexByte(clause, '{'); open != -1 {
+			end := strings.IndexByte(clause, '}')
+			if end < open {
+				continue
+			}
+			parts = append(parts, strings.Split(clause[open+1:end], ",")...)
+			clause = clause[:open] + clause[end+1:]
+		}
+		parts = append(parts, strings.Split(clause, ",")...)
+
+		for _, part := range parts {
+			// e.g. "Foo", "* as foo", "default as Foo" or
+			// "type Foo".
+			fields := strings.Fields(part)
+			if len(fields) == 0 || fields[0] == "type" {
+				continue
+			}
+			name := fields[len(fields)-1]
+			if identifierRegexp.MatchString(name) {
+				names = append(names, name)
+			}
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	return "\n;[" + strings.Join(names, ", ") + "];\n"
+}
@@ -0,0 +1,116 @@
+package nodejs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/replit/upm/internal/api"
+)
+
+func TestComponentGuessExplain(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "temp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"App.vue": `<template>
+  <DatePicker v-model="date" />
+</template>
+
+<script setup lang="ts">
+import DatePicker from 'vue-datepicker'
+import type { Ref } from 'vue'
+const date: Ref<Date> = ref(new Date())
+</script>
+
+<script>
+import { defineComponent } from 'vue'
+</script>
+`,
+		"Counter.svelte": `<script context="module">
+	export const prerender = true;
+</script>
+
+<script lang="ts">
+	import { spring } from 'svelte-motion';
+	import Icon from '@iconify/svelte';
+</script>
+
+<script type="application/ld+json">{"import": "ignored"}</script>
+
+<Icon icon="mdi:plus" />
+`,
+		"index.astro": `---
+import Layout from '@layouts/Layout.astro';
+import { Image } from 'astro-imagetools/components';
+---
+
+<Layout>
+  <Image src="hero.png" />
+</Layout>
+
+<script>
+  import confetti from 'canvas-confetti';
+  confetti();
+</script>
+`,
+		"post.mdx": `---
+title: import from nowhere
+---
+
+import { Chart } from 'react-chartjs-2'
+export const meta = {
+  author: 'me',
+}
+
+Some text that says import is a word.
+
+` + "```js\nimport fake from 'fake-package'\n```" + `
+
+<Chart />
+`,
+	}
+	for name, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reason := func(file string, line int, mod string, source string) api.GuessReason {
+		pkg, _, _ := importPackage(mod)
+		return api.GuessReason{Package: pkg, File: filepath.Join(dir, file), Line: line, Module: mod, Source: source, Confidence: 1}
+	}
+	expected := []api.GuessReason{
+		reason("App.vue", 6, "vue-datepicker", "import-path"),
+		reason("App.vue", 12, "vue", "import-path"),
+		reason("Counter.svelte", 6, "svelte-motion", "import-path"),
+		reason("Counter.svelte", 7, "@iconify/svelte", "npm-scope"),
+		reason("index.astro", 2, "@layouts/Layout.astro", "npm-scope"),
+		reason("index.astro", 3, "astro-imagetools/components", "import-path"),
+		reason("index.astro", 11, "canvas-confetti", "import-path"),
+		reason("post.mdx", 5, "react-chartjs-2", "import-path"),
+	}
+	if reasons := NodejsNPMBackend.GuessExplain(); !reflect.DeepEqual(reasons, expected) {
+		t.Errorf("expected %+v, got %+v", expected, reasons)
+	}
+}
+
+func TestReferenceImports(t *testing.T) {
+	code := `import a, { b, c as d, type E, default as f } from 'x'
+import * as g from 'y'
+import type { H } from 'z'
+import {
+  i,
+} from 'w'
+import 'side-effect'
+`
+	expected := "\n;[b, d, f, a, g, i];\n"
+	if ref := referenceImports(code); ref != expected {
+		t.Errorf("expected %q, got %q", expected, ref)
+	}
+}
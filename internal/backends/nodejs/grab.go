@@ -1,7 +1,6 @@
 package nodejs
 
 import (
-	"github.com/amasad/esparse/ast"
 	"github.com/amasad/esparse/logging"
 	"github.com/amasad/esparse/parser"
 	"github.com/replit/upm/internal/api"
@@ -9,6 +8,7 @@ import (
 	"io/ioutil"
 	"log"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -64,153 +64,216 @@ func getExt(path string) string {
 }
 
 type parseResult struct {
-	ast ast.AST
-	ok  bool
-}
+	file    string
+	imports []string
 
-func parseFile(source logging.Source, results chan parseResult) {
-	parseOptions := parser.ParseOptions{
-		IsBundling: true,
-	}
+	// The 1-based line of each import.
+	lines []int
 
-	// Always parse jsx
-	parseOptions.JSX.Parse = true
+	ok bool
+}
 
-	ext := getExt(source.AbsolutePath)
+func parseFile(index int, file string, results chan parseResult) {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Fatalln(err)
+	}
 
-	if ext == ".ts" || ext == ".tsx" {
-		parseOptions.TS.Parse = true
+	absPath, err := filepath.Abs(file)
+	if err != nil {
+		log.Fatalln(err)
 	}
-	logo, _ := logging.NewDeferLog()
 
-	ast, ok := parser.Parse(logo, source, parseOptions)
+	result := parseResult{file: file, imports: []string{}, lines: []int{}, ok: true}
+	for _, script := range extractScripts(file, string(contents)) {
+		parseOptions := parser.ParseOptions{
+			IsBundling: true,
+		}
 
-	results <- parseResult{ast, ok}
-}
+		// Always parse jsx
+		parseOptions.JSX.Parse = true
 
-func guessBareImports() map[api.PkgName]bool {
-	pkgs := map[api.PkgName]bool{}
-	results := make(chan parseResult)
-	numParsedFiles := 0
-	var visitDir func(dirName string)
+		parseOptions.TS.Parse = script.ts
 
-	visitDir = func(dirName string) {
-		for _, ignoredPath := range util.IgnoredPaths {
-			if ignoredPath == filepath.Base(dirName) {
-				return
-			}
+		code := script.code
+		if script.ts && script.keepImports {
+			code += referenceImports(code)
 		}
 
-		files, err := ioutil.ReadDir(dirName)
-		if err != nil {
-			log.Fatalln(err)
+		source := logging.Source{
+			Index:        uint32(index),
+			AbsolutePath: absPath,
+			PrettyPath:   absPath,
+			Contents:     code,
 		}
 
-		for i, file := range files {
-			absPath := filepath.Join(dirName, file.Name())
-			if file.IsDir() {
-				visitDir(absPath)
-				continue
-			}
-
-			extension := getExt(absPath)
-			if extension != ".js" &&
-				extension != ".jsx" &&
-				extension != ".tsx" &&
-				extension != ".ts" {
-				continue
-			}
+		logo, _ := logging.NewDeferLog()
 
-			contents, err := ioutil.ReadFile(absPath)
-			if err != nil {
-				log.Fatalln(err)
-			}
+		ast, ok := parser.Parse(logo, source, parseOptions)
+		if !ok {
+			result.ok = false
+		}
 
-			source := logging.Source{
-				Index:        uint32(i),
-				AbsolutePath: absPath,
-				PrettyPath:   absPath,
-				Contents:     string(contents),
+		for _, importPath := range ast.ImportPaths {
+			result.imports = append(result.imports, importPath.Path.Text)
+			start := int(importPath.Path.Loc.Start)
+			if start > len(source.Contents) {
+				start = len(source.Contents)
 			}
-			numParsedFiles++
-			go parseFile(source, results)
+			result.lines = append(result.lines, 1+strings.Count(source.Contents[:start], "\n"))
 		}
-
 	}
 
-	dir, err := filepath.Abs(".")
-	if err != nil {
-		log.Fatalln(err)
-	}
+	results <- result
+}
 
-	visitDir(dir)
+// nodejsGuessFileImports implements GuessFileImports for nodejs-yarn
+// and nodejs-npm. The files are parsed concurrently.
+func nodejsGuessFileImports(files []string) map[string][]string {
+	results := make(chan parseResult)
+	for i, file := range files {
+		go parseFile(i, file, results)
+	}
 
-	for i := 0; i < numParsedFiles; i++ {
+	imports := map[string][]string{}
+	for range files {
 		result := <-results
-		if !result.ok {
-			continue
+		if result.ok {
+			imports[result.file] = result.imports
 		}
+	}
+	return imports
+}
 
-		for _, importPath := range result.ast.ImportPaths {
-			mod := importPath.Path.Text
+// importPackage returns the NPM package that provides the module
+// imported by an import path, and the source of the mapping as for
+// api.GuessReason. The last return value is false if the import
+// isn't of a package.
+func importPackage(mod string) (api.PkgName, string, bool) {
+	// Since Node.js 16, you can prefix the import path with `node:` to denote that the
+	// module is a core module.
+	if strings.HasPrefix(mod, "node:") {
+		return "", "", false
+	}
 
-			// Since Node.js 16, you can prefix the import path with `node:` to denote that the
-			// module is a core module.
-			if strings.HasPrefix(mod, "node:") {
-				continue
-			}
+	for _, internal := range internalModules {
+		if internal == mod {
+			return "", "", false
+		}
+	}
 
-			isInternalMod := false
-			for _, internal := range internalModules {
-				if internal == mod {
-					isInternalMod = true
-					break
-				}
-			}
-			if isInternalMod {
-				continue
-			}
+	// Skip empty imports
+	if mod == "" {
+		return "", "", false
+	}
 
-			// Skip empty imports
-			if mod == "" {
-				continue
-			}
+	// Skip absolute imports
+	if mod[0] == '/' {
+		return "", "", false
+	}
 
-			// Skip absolute imports
-			if mod[0] == '/' {
-				continue
-			}
+	// Skip relative imports
+	if mod[0] == '.' {
+		return "", "", false
+	}
 
-			// Skip relative imports
-			if mod[0] == '.' {
-				continue
-			}
+	// Skip subpath imports, which are mapped to files in the
+	// project by the imports field of package.json. Package names
+	// can't start with "#".
+	if mod[0] == '#' {
+		return "", "", false
+	}
 
-			// Skip external files, don't import from http or https
-			if strings.HasPrefix(mod, "http:") || strings.HasPrefix(mod, "https:") {
-				continue
-			}
+	// Skip external files, don't import from http or https
+	if strings.HasPrefix(mod, "http:") || strings.HasPrefix(mod, "https:") {
+		return "", "", false
+	}
 
-			// Skip script loaders
-			if strings.Contains(mod, "!") {
-				continue
-			}
+	// Skip script loaders
+	if strings.Contains(mod, "!") {
+		return "", "", false
+	}
 
-			// Handle scoped modules
-			if mod[0] == '@' {
-				parts := strings.Split(mod, "/")
-				if len(parts) < 2 {
-					continue
-				}
-				mod = strings.Join(parts[:2], "/")
-			} else {
-				parts := strings.Split(mod, "/")
-				mod = parts[0]
-			}
+	// Handle scoped modules
+	if mod[0] == '@' {
+		parts := strings.Split(mod, "/")
+		if len(parts) < 2 {
+			return "", "", false
+		}
+		return api.PkgName(strings.Join(parts[:2], "/")), "npm-scope", true
+	}
+
+	parts := strings.Split(mod, "/")
+	return api.PkgName(parts[0]), "import-path", true
+}
 
-			pkgs[api.PkgName(mod)] = true
+// nodejsGuessFromImports implements GuessFromImports for nodejs-yarn
+// and nodejs-npm.
+func nodejsGuessFromImports(imports []string) map[api.PkgName]bool {
+	local := readLocalModules(".")
+	pkgs := map[api.PkgName]bool{}
+	for _, mod := range imports {
+		if pkg, _, ok := importPackage(mod); ok && !local.isLocal(mod, pkg) {
+			pkgs[pkg] = true
 		}
 	}
 
 	return pkgs
 }
+
+// nodejsGuessExplain implements GuessExplain for nodejs-yarn and
+// nodejs-npm. There is a reason for every import of a package.
+func nodejsGuessExplain() []api.GuessReason {
+	files := util.ListFilesRecursive(nodejsPatterns)
+	results := make(chan parseResult)
+	for i, file := range files {
+		go parseFile(i, file, results)
+	}
+
+	byFile := map[string]parseResult{}
+	for range files {
+		result := <-results
+		if result.ok {
+			byFile[result.file] = result
+		}
+	}
+
+	local := readLocalModules(".")
+	reasons := []api.GuessReason{}
+	for _, file := range files {
+		result := byFile[file]
+		start := len(reasons)
+		for i, mod := range result.imports {
+			pkg, source, ok := importPackage(mod)
+			if !ok || local.isLocal(mod, pkg) {
+				continue
+			}
+			// Node resolves a bare specifier from the
+			// node_modules directory named by its first
+			// segment (or two, if scoped), so only that
+			// package can provide it.
+			reasons = append(reasons, api.GuessReason{
+				Package:    pkg,
+				File:       file,
+				Line:       result.lines[i],
+				Module:     mod,
+				Source:     source,
+				Confidence: 1,
+			})
+		}
+		// The parser doesn't give the imports in order.
+		fileReasons := reasons[start:]
+		sort.SliceStable(fileReasons, func(i, j int) bool {
+			return fileReasons[i].Line < fileReasons[j].Line
+		})
+	}
+	return reasons
+}
+
+func guessBareImports() map[api.PkgName]bool {
+	imports := []string{}
+	for _, fileImports := range nodejsGuessFileImports(util.ListFilesRecursive(nodejsPatterns)) {
+		imports = append(imports, fileImports...)
+	}
+	return nodejsGuessFromImports(imports)
+}
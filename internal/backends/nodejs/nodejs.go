@@ -8,6 +8,9 @@ import (
 	"net/url"
 	"os"
 	"regexp"
+	"sort"
+	"strconv"
+	"time"
 
 	"github.com/hashicorp/go-version"
 	"github.com/replit/upm/internal/api"
@@ -25,6 +28,7 @@ type npmSearchResults struct {
 			Name        string `json:"name"`
 			Version     string `json:"version"`
 			Description string `json:"description"`
+			Date        string `json:"date"`
 			Links       struct {
 				Homepage   string `json:"homepage"`
 				Repository string `json:"repository"`
@@ -44,8 +48,9 @@ type npmSearchResults struct {
 // See https://github.com/npm/registry/blob/5db1bb329f554454467531a3e1bae5e97da160df/docs/responses/package-metadata.md
 // for documentation on the format.
 type npmInfoResult struct {
-	Name     string                 `json:"name"`
-	Versions map[string]interface{} `json:"versions"`
+	Name     string                    `json:"name"`
+	Versions map[string]npmVersionInfo `json:"versions"`
+	Time     map[string]string         `json:"time"`
 	Author   struct {
 		Name  string `json:"name"`
 		Email string `json:"email"`
@@ -58,15 +63,53 @@ type npmInfoResult struct {
 	Homepage    string `json:"homepage"`
 	License     string `json:"license"`
 	Repository  struct {
-		Type string `json:"type"`
-		URL  string `json:"url"`
+		Type      string `json:"type"`
+		URL       string `json:"url"`
+		Directory string `json:"directory"`
 	} `json:"repository"`
 }
 
+// npmVersionInfo represents the data we get from the NPM API about
+// one version of a package, as part of npmInfoResult.
+type npmVersionInfo struct {
+	// Deprecation message, empty unless the version is
+	// deprecated.
+	Deprecated string `json:"deprecated"`
+
+	// Usually an SPDX expression, but very old packages use an
+	// object instead, which we ignore.
+	License interface{} `json:"license"`
+
+	Dependencies map[string]string `json:"dependencies"`
+
+	// The declaration file bundled with the version, if any.
+	// Typings is an older synonym for Types. Declarations may
+	// also be given per TypeScript version, or as "types"
+	// conditions in the exports, which are left unparsed.
+	Types         string          `json:"types"`
+	Typings       string          `json:"typings"`
+	TypesVersions json.RawMessage `json:"typesVersions"`
+	Exports       json.RawMessage `json:"exports"`
+}
+
+// npmDownloadsResult represents the data we get from the NPM
+// downloads API.
+//
+// See https://github.com/npm/registry/blob/5db1bb329f554454467531a3e1bae5e97da160df/docs/download-counts.md
+// for documentation on the format.
+type npmDownloadsResult struct {
+	Downloads int `json:"downloads"`
+}
+
 // packageJSON represents the relevant data in a package.json file.
 type packageJSON struct {
+	Name            string            `json:"name"`
 	Dependencies    map[string]string `json:"dependencies"`
 	DevDependencies map[string]string `json:"devDependencies"`
+
+	// Either an array of globs or, for Yarn, an object with such
+	// an array in its packages field.
+	Workspaces json.RawMessage `json:"workspaces"`
 }
 
 // packageLockJSON represents the relevant data in a package-lock.json
@@ -78,16 +121,32 @@ type packageLockJSON struct {
 }
 
 // nodejsPatterns is the FilenamePatterns value for NodejsBackend.
-var nodejsPatterns = []string{"*.js", "*.ts", "*.jsx", "*.tsx"}
+// Components and MDX documents have code in them too, as found by
+// extractScripts.
+var nodejsPatterns = []string{"*.js", "*.ts", "*.jsx", "*.tsx", "*.vue", "*.svelte", "*.astro", "*.mdx"}
 
 // nodejsSearch implements Search for nodejs-yarn and nodejs-npm.
 func nodejsSearch(query string) []api.PkgInfo {
+	// The registry returns 20 results by default.
+	return nodejsSearchPaged(query, 0, 20)
+}
+
+// npmMaxSearchSize is the most results that the NPM registry will
+// return for one search request.
+const npmMaxSearchSize = 250
+
+// nodejsSearchPaged implements SearchPaged for nodejs-yarn and
+// nodejs-npm.
+func nodejsSearchPaged(query string, offset int, limit int) []api.PkgInfo {
 	// Special case: if search query is only one character, the
 	// API doesn't return any results. The web interface to NPM
 	// deals with this by just jumping to the package with that
 	// exact name, or returning a 404 if there isn't one. Let's
 	// try to do something similar.
 	if len(query) == 1 {
+		if offset > 0 {
+			return []api.PkgInfo{}
+		}
 		info := nodejsInfo(api.PkgName(query))
 		if info.Name != "" {
 			return []api.PkgInfo{info}
@@ -96,8 +155,18 @@ func nodejsSearch(query string) []api.PkgInfo {
 		}
 	}
 
+	return api.FetchChunks(offset, limit, npmMaxSearchSize, func(from int, size int) []api.PkgInfo {
+		return npmSearchPage(query, from, size)
+	})
+}
+
+// npmSearchPage returns up to size search results from the NPM
+// registry, starting at from.
+func npmSearchPage(query string, from int, size int) []api.PkgInfo {
 	endpoint := "https://registry.npmjs.org/-/v1/search"
-	queryParams := "?text=" + url.QueryEscape(query)
+	queryParams := "?text=" + url.QueryEscape(query) +
+		"&from=" + strconv.Itoa(from) +
+		"&size=" + strconv.Itoa(size)
 
 	resp, err := http.Get(endpoint + queryParams)
 	if err != nil {
@@ -129,13 +198,48 @@ func nodejsSearch(query string) []api.PkgInfo {
 				Name:  p.Author.Username,
 				Email: p.Author.Email,
 			}.String(),
+			PublishedAt: parseNpmTime(p.Date),
 		}
 	}
 	return results
 }
 
-// nodejsInfo implements Info for nodejs-yarn and nodejs-npm.
-func nodejsInfo(name api.PkgName) api.PkgInfo {
+// parseNpmTime parses a timestamp from the NPM API, returning nil if
+// it is missing or malformed.
+func parseNpmTime(str string) *time.Time {
+	t, err := time.Parse(time.RFC3339, str)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+// npmDownloads returns the number of times the given package was
+// downloaded from the NPM registry in the last week. Download counts
+// are supplementary, so on error it just returns zero.
+func npmDownloads(name api.PkgName) int {
+	endpoint := "https://api.npmjs.org/downloads/point/last-week/"
+	resp, err := http.Get(endpoint + string(name))
+	if err != nil {
+		return 0
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return 0
+	}
+
+	var downloads npmDownloadsResult
+	if err := json.NewDecoder(resp.Body).Decode(&downloads); err != nil {
+		return 0
+	}
+	return downloads.Downloads
+}
+
+// nodejsFetchInfo retrieves the metadata for all versions of a
+// package from the NPM registry. It returns false if there is no such
+// package.
+func nodejsFetchInfo(name api.PkgName) (npmInfoResult, bool) {
 	endpoint := "https://registry.npmjs.org"
 	path := "/" + url.QueryEscape(string(name))
 
@@ -149,7 +253,7 @@ func nodejsInfo(name api.PkgName) api.PkgInfo {
 	case 200:
 		break
 	case 404:
-		return api.PkgInfo{}
+		return npmInfoResult{}, false
 	default:
 		util.Die("NPM registry: HTTP status %d", resp.StatusCode)
 	}
@@ -159,33 +263,60 @@ func nodejsInfo(name api.PkgName) api.PkgInfo {
 	if err := json.Unmarshal(body, &npmInfo); err != nil {
 		util.Die("NPM registry: %s", err)
 	}
+	return npmInfo, true
+}
 
-	lastVersionStr := ""
-	if len(npmInfo.Versions) > 0 {
-		var lastVersion *version.Version = nil
-		for versionStr := range npmInfo.Versions {
-			version, err := version.NewVersion(versionStr)
-			if err != nil {
-				continue
-			}
+// versions returns all the valid semver versions of the package, from
+// oldest to newest.
+func (npmInfo *npmInfoResult) versions() api.PkgVersions {
+	type parsedVersion struct {
+		str     string
+		version *version.Version
+	}
+	parsed := []parsedVersion{}
+	for versionStr := range npmInfo.Versions {
+		v, err := version.NewVersion(versionStr)
+		if err != nil {
+			continue
+		}
+		parsed = append(parsed, parsedVersion{str: versionStr, version: v})
+	}
+	sort.Slice(parsed, func(i, j int) bool {
+		return parsed[i].version.LessThan(parsed[j].version)
+	})
 
-			if version.Prerelease() != "" {
-				continue
-			}
+	versions := api.PkgVersions{}
+	for _, p := range parsed {
+		versions = append(versions, api.PkgVersionInfo{
+			Version:     p.str,
+			PublishedAt: parseNpmTime(npmInfo.Time[p.str]),
+			Prerelease:  p.version.Prerelease() != "",
+			Deprecated:  npmInfo.Versions[p.str].Deprecated != "",
+		})
+	}
+	return versions
+}
 
-			if lastVersion == nil || version.GreaterThan(lastVersion) {
-				lastVersion = version
-			}
-		}
-		if lastVersion != nil {
-			lastVersionStr = lastVersion.String()
+// latestVersion returns the newest version of the package which
+// isn't a prerelease, or the empty string if there isn't one.
+func (npmInfo *npmInfoResult) latestVersion() string {
+	lastVersionStr := ""
+	for _, version := range npmInfo.versions() {
+		if !version.Prerelease {
+			lastVersionStr = version.Version
 		}
 	}
+	return lastVersionStr
+}
 
-	return api.PkgInfo{
+// toPkgInfo converts the metadata for the given version of the
+// package into the format used by UPM. Package-level fields like the
+// description come from the latest version.
+func (npmInfo *npmInfoResult) toPkgInfo(versionStr string) api.PkgInfo {
+	info := api.PkgInfo{
 		Name:          npmInfo.Name,
 		Description:   npmInfo.Description,
-		Version:       lastVersionStr,
+		Version:       versionStr,
 		HomepageURL:   npmInfo.Homepage,
 		SourceCodeURL: npmInfo.Repository.URL,
 		BugTrackerURL: npmInfo.Bugs.URL,
@@ -194,8 +325,57 @@ func nodejsInfo(name api.PkgName) api.PkgInfo {
 			Email: npmInfo.Author.Email,
 			URL:   npmInfo.Author.URL,
 		}.String(),
-		License: npmInfo.License,
+		License:  npmInfo.License,
+		Versions: npmInfo.versions(),
 	}
+	if npmInfo.Repository.URL != "" {
+		info.Repository = &api.PkgRepository{
+			Type:      npmInfo.Repository.Type,
+			URL:       npmInfo.Repository.URL,
+			Directory: npmInfo.Repository.Directory,
+		}
+	}
+
+	if manifest, ok := npmInfo.Versions[versionStr]; ok {
+		info.PublishedAt = parseNpmTime(npmInfo.Time[versionStr])
+		info.Deprecated = manifest.Deprecated != ""
+		if license, ok := manifest.License.(string); ok {
+			info.License = license
+		}
+		for dep := range manifest.Dependencies {
+			info.Dependencies = append(info.Dependencies, dep)
+		}
+		sort.Strings(info.Dependencies)
+	}
+
+	return info
+}
+
+// nodejsInfo implements Info for nodejs-yarn and nodejs-npm.
+func nodejsInfo(name api.PkgName) api.PkgInfo {
+	npmInfo, ok := nodejsFetchInfo(name)
+	if !ok {
+		return api.PkgInfo{}
+	}
+
+	info := npmInfo.toPkgInfo(npmInfo.latestVersion())
+	info.Downloads = npmDownloads(name)
+	return info
+}
+
+// nodejsVersionInfo implements VersionInfo for nodejs-yarn and
+// nodejs-npm.
+func nodejsVersionInfo(name api.PkgName, version api.PkgVersion) api.PkgInfo {
+	npmInfo, ok := nodejsFetchInfo(name)
+	if !ok {
+		return api.PkgInfo{}
+	}
+
+	if _, ok := npmInfo.Versions[string(version)]; !ok {
+		return api.PkgInfo{}
+	}
+
+	return npmInfo.toPkgInfo(string(version))
 }
 
 // nodejsListSpecfile implements ListSpecfile for nodejs-yarn and
@@ -219,20 +399,6 @@ func nodejsListSpecfile() map[api.PkgName]api.PkgSpec {
 	return pkgs
 }
 
-// nodejsGuessRegexps is the value of GuessRegexps for nodejs-yarn and
-// nodejs-npm.
-var nodejsGuessRegexps = util.Regexps([]string{
-	// import defaultExport from "module-name";
-	// import * as name from "module-name";
-	// import { export } from "module-name";
-	`(?m)from\s*['"]([^'"]+)['"]\s*;?\s*$`,
-	// import "module-name";
-	`(?m)import\s*['"]([^'"]+)['"]\s*;?\s*$`,
-	// const mod = import("module-name")
-	// const mod = require("module-name")
-	`(?m)(?:require|import)\s*\(\s*['"]([^'"{}]+)['"]\s*\)`,
-})
-
 // nodejsGuess implements Guess for nodejs-yarn and nodejs-npm.
 func nodejsGuess() (map[api.PkgName]bool, bool) {
 	tempdir := util.TempDir()
@@ -248,14 +414,18 @@ var NodejsYarnBackend = api.LanguageBackend{
 	Specfile:         "package.json",
 	Lockfile:         "yarn.lock",
 	FilenamePatterns: nodejsPatterns,
+	Executables:      []string{"yarn"},
 	Quirks: api.QuirksAddRemoveAlsoLocks |
 		api.QuirksAddRemoveAlsoInstalls |
 		api.QuirksLockAlsoInstalls,
 	GetPackageDir: func() string {
 		return "node_modules"
 	},
-	Search: nodejsSearch,
-	Info:   nodejsInfo,
+	PackageDirMarkers: []string{".yarn-integrity"},
+	Search:            nodejsSearch,
+	SearchPaged:       nodejsSearchPaged,
+	Info:              nodejsInfo,
+	VersionInfo:       nodejsVersionInfo,
 	Add: func(pkgs map[api.PkgName]api.PkgSpec, projectName string) {
 		if !util.Exists("package.json") {
 			util.RunCmd([]string{"yarn", "init", "-y"})
@@ -269,6 +439,9 @@ var NodejsYarnBackend = api.LanguageBackend{
 			cmd = append(cmd, arg)
 		}
 		util.RunCmd(cmd)
+		if types := typesPackages(pkgs); len(types) > 0 {
+			util.RunCmd(append([]string{"yarn", "add", "--dev"}, types...))
+		}
 	},
 	Remove: func(pkgs map[api.PkgName]bool) {
 		cmd := []string{"yarn", "remove"}
@@ -300,8 +473,10 @@ var NodejsYarnBackend = api.LanguageBackend{
 		return pkgs
 	},
 	// https://developer.mozilla.org/en-US/docs/Web/JavaScript/Reference/Statements/import
-	GuessRegexps: nodejsGuessRegexps,
-	Guess:        nodejsGuess,
+	Guess:            nodejsGuess,
+	GuessFileImports: nodejsGuessFileImports,
+	GuessFromImports: nodejsGuessFromImports,
+	GuessExplain:     nodejsGuessExplain,
 }
 
 // NodejsNPMBackend is a UPM backend for Node.js that uses NPM.
@@ -310,14 +485,18 @@ var NodejsNPMBackend = api.LanguageBackend{
 	Specfile:         "package.json",
 	Lockfile:         "package-lock.json",
 	FilenamePatterns: nodejsPatterns,
+	Executables:      []string{"npm"},
 	Quirks: api.QuirksAddRemoveAlsoLocks |
 		api.QuirksAddRemoveAlsoInstalls |
 		api.QuirksLockAlsoInstalls,
 	GetPackageDir: func() string {
 		return "node_modules"
 	},
-	Search: nodejsSearch,
-	Info:   nodejsInfo,
+	PackageDirMarkers: []string{".package-lock.json"},
+	Search:            nodejsSearch,
+	SearchPaged:       nodejsSearchPaged,
+	Info:              nodejsInfo,
+	VersionInfo:       nodejsVersionInfo,
 	Add: func(pkgs map[api.PkgName]api.PkgSpec, projectName string) {
 		if !util.Exists("package.json") {
 			util.RunCmd([]string{"npm", "init", "-y"})
@@ -331,6 +510,9 @@ var NodejsNPMBackend = api.LanguageBackend{
 			cmd = append(cmd, arg)
 		}
 		util.RunCmd(cmd)
+		if types := typesPackages(pkgs); len(types) > 0 {
+			util.RunCmd(append([]string{"npm", "install", "--save-dev"}, types...))
+		}
 	},
 	Remove: func(pkgs map[api.PkgName]bool) {
 		cmd := []string{"npm", "uninstall"}
@@ -362,6 +544,8 @@ var NodejsNPMBackend = api.LanguageBackend{
 		return pkgs
 	},
 	// https://developer.mozilla.org/en-US/docs/Web/JavaScript/Reference/Statements/import
-	GuessRegexps: nodejsGuessRegexps,
-	Guess:        nodejsGuess,
+	Guess:            nodejsGuess,
+	GuessFileImports: nodejsGuessFileImports,
+	GuessFromImports: nodejsGuessFromImports,
+	GuessExplain:     nodejsGuessExplain,
 }
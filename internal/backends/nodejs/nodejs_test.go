@@ -3,6 +3,8 @@ package nodejs
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 
 	"github.com/replit/upm/internal/api"
@@ -111,3 +113,29 @@ func TestNodejsYarnBackend_Guess(t *testing.T) {
 		})
 	}
 }
+
+func TestNodejsGuessExplain(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "temp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "index.js")
+	err = ioutil.WriteFile(file, []byte(`import React from 'react';
+import fs from 'fs';
+import './local';
+const core = require('@babel/core/lib/index');
+`), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []api.GuessReason{
+		{Package: "react", File: file, Line: 1, Module: "react", Source: "import-path", Confidence: 1},
+		{Package: "@babel/core", File: file, Line: 4, Module: "@babel/core/lib/index", Source: "npm-scope", Confidence: 1},
+	}
+	if reasons := NodejsNPMBackend.GuessExplain(); !reflect.DeepEqual(reasons, expected) {
+		t.Errorf("expected %+v, got %+v", expected, reasons)
+	}
+}
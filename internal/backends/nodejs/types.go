@@ -0,0 +1,177 @@
+package nodejs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// usesTypeScript returns true if the project in the current directory
+// is written in TypeScript, and so needs type declarations for its
+// dependencies.
+func usesTypeScript() bool {
+	if util.Exists("tsconfig.json") {
+		return true
+	}
+	return len(util.ListFilesRecursive([]string{"*.ts", "*.tsx"})) > 0
+}
+
+// typesPackageName returns the name of the DefinitelyTyped package
+// which declares the types of a package. For scoped packages the
+// scope is folded into the name, e.g. @babel/core is declared by
+// @types/babel__core.
+func typesPackageName(name api.PkgName) api.PkgName {
+	str := string(name)
+	if strings.HasPrefix(str, "@") {
+		str = strings.Replace(str[1:], "/", "__", 1)
+	}
+	return api.PkgName("@types/" + str)
+}
+
+// exactVersionRegexp matches a spec naming a single version, possibly
+// with a range operator in front which is ignored.
+var exactVersionRegexp = regexp.MustCompile(`^[\^~=v]*(\d+\.\d+\.\d+(?:[-+][0-9A-Za-z.+-]*)?)$`)
+
+// distTagRegexp matches a spec naming a dist-tag, e.g. "next".
+var distTagRegexp = regexp.MustCompile(`^[A-Za-z][0-9A-Za-z._-]*$`)
+
+// registryVersion returns the version or dist-tag of a package that
+// the registry should be asked about for the given spec. The registry
+// can't resolve other ranges, so for those, and for specs that aren't
+// from the registry at all, the latest version stands in.
+func registryVersion(spec api.PkgSpec) string {
+	if match := exactVersionRegexp.FindStringSubmatch(string(spec)); match != nil {
+		return match[1]
+	}
+	if distTagRegexp.MatchString(string(spec)) {
+		return string(spec)
+	}
+	return "latest"
+}
+
+// fetchManifest retrieves the metadata for one version or dist-tag of
+// a package from the NPM registry, which is much smaller than the
+// metadata for all of them. It is a variable so that tests can
+// replace it.
+var fetchManifest = func(name api.PkgName, version string) (npmVersionInfo, error) {
+	endpoint := "https://registry.npmjs.org"
+	resp, err := http.Get(endpoint + "/" + string(name) + "/" + url.PathEscape(version))
+	if err != nil {
+		return npmVersionInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return npmVersionInfo{}, fmt.Errorf("HTTP status %d", resp.StatusCode)
+	}
+
+	var manifest npmVersionInfo
+	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		return npmVersionInfo{}, err
+	}
+	return manifest, nil
+}
+
+// exportsTypes returns true if the exports of a package, as found in
+// its package.json, have a "types" condition anywhere within them.
+func exportsTypes(exports json.RawMessage) bool {
+	var conditions map[string]json.RawMessage
+	if err := json.Unmarshal(exports, &conditions); err != nil {
+		// A string or an array of fallbacks.
+		var fallbacks []json.RawMessage
+		if err := json.Unmarshal(exports, &fallbacks); err != nil {
+			return false
+		}
+		for _, fallback := range fallbacks {
+			if exportsTypes(fallback) {
+				return true
+			}
+		}
+		return false
+	}
+	for condition, target := range conditions {
+		if condition == "types" || exportsTypes(target) {
+			return true
+		}
+	}
+	return false
+}
+
+// bundlesTypes returns true if the given version of a package ships
+// its own type declarations.
+func bundlesTypes(manifest npmVersionInfo) bool {
+	return manifest.Types != "" || manifest.Typings != "" ||
+		len(manifest.TypesVersions) > 0 || exportsTypes(manifest.Exports)
+}
+
+// needsTypesPackage returns true if the DefinitelyTyped package
+// typesName should be added along with the given spec of a package.
+// If anything can't be fetched from the registry, e.g. when offline
+// or behind a private registry, the package is skipped, as the types
+// are only a convenience.
+func needsTypesPackage(name api.PkgName, spec api.PkgSpec, typesName api.PkgName) bool {
+	manifest, err := fetchManifest(name, registryVersion(spec))
+	if err != nil || bundlesTypes(manifest) {
+		return false
+	}
+
+	// DefinitelyTyped deprecates its packages for libraries that
+	// have started shipping their own types, which also covers
+	// declarations the manifest doesn't mention, like an
+	// index.d.ts next to index.js.
+	types, err := fetchManifest(typesName, "latest")
+	return err == nil && types.Deprecated == ""
+}
+
+// typesPackages returns the DefinitelyTyped packages to add as
+// development dependencies along with the given packages, for a
+// TypeScript project. Packages which bundle their own types, or whose
+// types are already in package.json, are skipped.
+func typesPackages(pkgs map[api.PkgName]api.PkgSpec) []string {
+	if !usesTypeScript() {
+		return nil
+	}
+
+	existing := map[api.PkgName]api.PkgSpec{}
+	if util.Exists("package.json") {
+		existing = nodejsListSpecfile()
+	}
+
+	var barrier sync.WaitGroup
+	var mutex sync.Mutex
+	types := []string{}
+	for name, spec := range pkgs {
+		if strings.HasPrefix(string(name), "@types/") {
+			continue
+		}
+		typesName := typesPackageName(name)
+		if _, ok := existing[typesName]; ok {
+			continue
+		}
+		if _, ok := pkgs[typesName]; ok {
+			continue
+		}
+
+		barrier.Add(1)
+		go func(name api.PkgName, spec api.PkgSpec, typesName api.PkgName) {
+			defer barrier.Done()
+			if needsTypesPackage(name, spec, typesName) {
+				mutex.Lock()
+				types = append(types, string(typesName))
+				mutex.Unlock()
+			}
+		}(name, spec, typesName)
+	}
+	barrier.Wait()
+
+	sort.Strings(types)
+	return types
+}
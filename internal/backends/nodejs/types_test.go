@@ -0,0 +1,145 @@
+package nodejs
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/replit/upm/internal/api"
+)
+
+func TestTypesPackageName(t *testing.T) {
+	for name, expected := range map[api.PkgName]api.PkgName{
+		"express":     "@types/express",
+		"lodash.get":  "@types/lodash.get",
+		"@babel/core": "@types/babel__core",
+	} {
+		if typesName := typesPackageName(name); typesName != expected {
+			t.Errorf("expected %s for %s, got %s", expected, name, typesName)
+		}
+	}
+}
+
+func TestRegistryVersion(t *testing.T) {
+	for spec, expected := range map[api.PkgSpec]string{
+		"":                 "latest",
+		"4.18.2":           "4.18.2",
+		"^4.18.2":          "4.18.2",
+		"~1.0.0-beta.1":    "1.0.0-beta.1",
+		"next":             "next",
+		"^4":               "latest",
+		">=1.0.0 <2.0.0":   "latest",
+		"1.x":              "latest",
+		"github:user/repo": "latest",
+	} {
+		if version := registryVersion(spec); version != expected {
+			t.Errorf("expected %q for %q, got %q", expected, spec, version)
+		}
+	}
+}
+
+func TestExportsTypes(t *testing.T) {
+	for exports, expected := range map[string]bool{
+		`"./index.js"`: false,
+		`{".": {"import": "./index.mjs", "require": "./index.cjs"}}`:                false,
+		`{".": {"types": "./index.d.ts", "default": "./index.js"}}`:                 true,
+		`{".": {"import": {"types": "./index.d.mts", "default": "./index.mjs"}}}`:   true,
+		`{".": [{"types": "./index.d.ts", "default": "./index.js"}, "./index.js"]}`: true,
+		`{"types": "./index.d.ts", "default": "./index.js"}`:                        true,
+	} {
+		if ok := exportsTypes(json.RawMessage(exports)); ok != expected {
+			t.Errorf("expected %v for %s, got %v", expected, exports, ok)
+		}
+	}
+	if exportsTypes(nil) {
+		t.Errorf("expected no types without exports")
+	}
+}
+
+func TestTypesPackages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "upm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+
+	// The registry, by name and version. Anything else fails to
+	// fetch.
+	registry := map[string]npmVersionInfo{
+		"express@4.18.2":            {},
+		"@types/express@latest":     {},
+		"express@5.0.0":             {Types: "index.d.ts"},
+		"lodash@latest":             {},
+		"@types/lodash@latest":      {},
+		"axios@latest":              {Typings: "index.d.ts"},
+		"@types/axios@latest":       {},
+		"zod@latest":                {Exports: json.RawMessage(`{".": {"types": "./index.d.ts"}}`)},
+		"@types/zod@latest":         {},
+		"pkg-up@latest":             {},
+		"@types/pkg-up@latest":      {Deprecated: "This is a stub types definition."},
+		"left-pad@latest":           {},
+		"offline@latest":            {},
+		"@babel/core@next":          {},
+		"@types/babel__core@latest": {},
+	}
+	var mutex sync.Mutex
+	defer func(fetch func(api.PkgName, string) (npmVersionInfo, error)) {
+		fetchManifest = fetch
+	}(fetchManifest)
+	fetchManifest = func(name api.PkgName, version string) (npmVersionInfo, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if manifest, ok := registry[string(name)+"@"+version]; ok {
+			return manifest, nil
+		}
+		return npmVersionInfo{}, errors.New("no such package")
+	}
+
+	pkgs := map[api.PkgName]api.PkgSpec{
+		"express":      "^4.18.2",
+		"lodash":       "",
+		"axios":        "",
+		"zod":          "",
+		"pkg-up":       "",
+		"left-pad":     "",
+		"offline":      "",
+		"@babel/core":  "next",
+		"unknown":      "",
+		"@types/react": "",
+	}
+
+	if types := typesPackages(pkgs); types != nil {
+		t.Errorf("expected no types outside a TypeScript project, got %v", types)
+	}
+
+	if err := ioutil.WriteFile("tsconfig.json", []byte(`{}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("package.json", []byte(`{"devDependencies": {"@types/lodash": "^4.0.0"}}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"@types/babel__core", "@types/express"}
+	if types := typesPackages(pkgs); !reflect.DeepEqual(types, expected) {
+		t.Errorf("expected %v, got %v", expected, types)
+	}
+
+	// A newer version of express bundles its types.
+	pkgs = map[api.PkgName]api.PkgSpec{"express": "5.0.0"}
+	if types := typesPackages(pkgs); len(types) != 0 {
+		t.Errorf("expected no types for express 5, got %v", types)
+	}
+}
@@ -4,18 +4,14 @@
 // modules -> most likely package
 //
 // these are provided as the maps pypiPackageToModules and moduleToPypiPackage
-// respectively.
+// respectively, together with moduleToPypiCandidates, the other packages which
+// provide each mapped module.
 package main
 
 import (
-	"encoding/json"
-	"flag"
-	"fmt"
-	"os"
-	"os/exec"
-	"sort"
-	"strconv"
 	"strings"
+
+	"github.com/replit/upm/internal/genmap"
 )
 
 type mapEntry struct {
@@ -24,22 +20,6 @@ type mapEntry struct {
 	Downloads int      `json:"d"`
 }
 
-type downloadSort []*mapEntry
-
-func (dl downloadSort) Len() int {
-	return len(dl)
-}
-
-func (dl downloadSort) Less(i, j int) bool {
-	return dl[i].Downloads > dl[j].Downloads
-}
-
-func (dl downloadSort) Swap(i, j int) {
-	tmp := dl[i]
-	dl[i] = dl[j]
-	dl[j] = tmp
-}
-
 // pythonStdlibModules this build is built from
 // https://docs.python.org/3/py-modindex.htm as we never want to guess a
 // standard library module is provided by a remote package.
@@ -257,185 +237,95 @@ var stdlibMods = map[string]bool{
 }
 
 func main() {
-	from := flag.String("from", "", "a json file to generate the map from")
-	pkg := flag.String("pkg", "", "the pkg name for the output source")
-	out := flag.String("out", "", "the destination file for the generated code")
-	flag.Parse()
-
-	outgo, err := os.Create(*out)
-	if err != nil {
-		panic(err)
-	}
-
-	injson, err := os.Open(*from)
-	if err != nil {
-		panic(err)
-	}
-
-	dec := json.NewDecoder(injson)
+	from, pkg, out := genmap.Flags()
 
 	pkgs := []*mapEntry{}
-	mods := map[string][]*mapEntry{}
-	guessable := map[string]bool{}
-
-	for dec.More() {
-		var m mapEntry
-
-		err := dec.Decode(&m)
-		if err != nil {
-			panic(err)
-		}
-
-		pkgs = append(pkgs, &m)
-
+	genmap.ReadIndex(from, func() interface{} {
+		m := &mapEntry{}
+		pkgs = append(pkgs, m)
+		return m
+	})
+
+	mods := genmap.Candidates{}
+	for _, m := range pkgs {
+		p := &genmap.Package{Name: m.Pkg, Count: m.Downloads}
 		for _, mod := range m.Mods {
-			if _, ok := mods[mod]; ok {
-				mods[mod] = append(mods[mod], &m)
-			} else {
-				mods[mod] = []*mapEntry{&m}
-			}
+			mods.Add(mod, p)
 		}
 	}
 
-	for _, pklist := range mods {
-		sort.Sort(downloadSort(pklist))
-	}
-
-	fmt.Fprintf(outgo, "package %s\n", *pkg)
-
-	fmt.Fprintf(outgo, `
-// moduleToPypiPackage holds a map of all known modules to their corresponding
-// best matching package. This helps us guess which packages should be installed
-// for the given imports.
-var moduleToPypiPackageCached = map[string]string{}
-
-func moduleToPypiPackage() map[string]string {
-    if len(moduleToPypiPackageCached) == 0 {
-        moduleToPypiPackageCached = map[string]string{
-`)
-
-	addMap := func(mod, pkg, comment string) {
-		if stdlibMods[mod] {
-			return
+	guessableMods := []string{}
+	for _, mod := range mods.Rank() {
+		if !stdlibMods[mod] {
+			guessableMods = append(guessableMods, mod)
 		}
-
-		guessable[mod] = true
-
-		fmt.Fprintf(outgo, "\t")
-		fmt.Fprintf(outgo, `%#v: %#v, // %s`, mod, pkg, comment)
-		fmt.Fprintf(outgo, "\n")
 	}
 
-nextpkg:
-	for mod, pkgs := range mods {
-		if len(pkgs) == 0 {
-			continue nextpkg
-		}
-
-		for _, candidate := range pkgs {
-			if strings.Replace(strings.ToLower(candidate.Pkg), "-", "_", -1) ==
-				strings.ToLower(mod) {
-				addMap(mod, candidate.Pkg, "exact match")
-				continue nextpkg
-			}
-		}
-
-		if pkgs[0].Downloads < 100 {
-			continue nextpkg
-		}
-
-		if len(pkgs) == 1 {
-			addMap(
-				mod,
-				pkgs[0].Pkg,
-				"only one pkg matched dls: "+
-					strconv.Itoa(pkgs[0].Downloads),
-			)
-
-			continue nextpkg
-		}
-
-		// if the top package is 10x more popular than the next, we'll go with
-		// it. We've added a cost for every module as well, this seems to get
-		// the best results
-		if pkgs[0].Downloads/len(pkgs[0].Mods) >
-			pkgs[1].Downloads*10/len(pkgs[1].Mods) {
-			addMap(
-				mod,
-				pkgs[0].Pkg,
-				"high download stats dls: "+
-					strconv.Itoa(pkgs[0].Downloads)+
-					" second best: "+
-					strconv.Itoa(pkgs[1].Downloads),
-			)
-			continue nextpkg
-		}
-	}
-
-	fmt.Fprintf(outgo, "}\n}\nreturn moduleToPypiPackageCached }\n")
-
-	fmt.Fprintf(outgo, `
-// pypiPackageToModules holds a map of every known python package to the modules
-// it provides. This helps prevent us from installing packages for modules which
-// are already provided by installed packages. The list of modules is limited to
-// those which could potentially be guessed.
-//
-// The module names are comma separated because go's compiler seems to vomit
-// when you create too many slices.
-var pypiPackageToModulesCached = map[string]string{}
-
-func pypiPackageToModules() map[string]string {
-    if len(pypiPackageToModulesCached) == 0 {
-        pypiPackageToModulesCached = map[string]string{
-		`)
-
-	for _, pkg := range pkgs {
-		guessableMods := []string{}
-
-		for _, mod := range pkg.Mods {
-			if guessable[mod] {
-				guessableMods = append(guessableMods, mod)
+	w := genmap.NewWriter(pkg)
+
+	// We've added a cost for every module to the download stats,
+	// this seems to get the best results
+	chosen := w.ChosenMap("moduleToPypiPackage", `
+moduleToPypiPackage holds a map of all known modules to their corresponding
+best matching package. This helps us guess which packages should be installed
+for the given imports.
+`, mods, guessableMods, genmap.Rules{
+		Match: func(pkg string, mod string) bool {
+			return strings.Replace(strings.ToLower(pkg), "-", "_", -1) ==
+				strings.ToLower(mod)
+		},
+		MatchComment: "exact match",
+		MinCount:     100,
+		PerModule:    true,
+		Noun:         "pkg",
+		CountNoun:    "download",
+		CountAbbr:    "dls",
+	})
+
+	w.BeginMap("pypiPackageToModules", "string", `
+pypiPackageToModules holds a map of every known python package to the modules
+it provides. This helps prevent us from installing packages for modules which
+are already provided by installed packages. The list of modules is limited to
+those which could potentially be guessed.
+
+The module names are comma separated because go's compiler seems to vomit
+when you create too many slices.
+`)
+	for _, m := range pkgs {
+		guessable := []string{}
+		for _, mod := range m.Mods {
+			if _, ok := chosen[mod]; ok {
+				guessable = append(guessable, mod)
 			}
 		}
-
-		if len(guessableMods) == 0 {
+		if len(guessable) == 0 {
 			continue
 		}
 
-		fmt.Fprintf(outgo, "\t")
-
 		// sadly putting these in slices kills the go compiler. Would be nice to
 		// find some other way to intern these though.
-		fmt.Fprintf(outgo, `%#v: %#v,`, pkg.Pkg, strings.Join(guessableMods, ","))
-		fmt.Fprintf(outgo, "\n")
-	}
-
-	fmt.Fprintf(outgo, "}\n}\nreturn pypiPackageToModulesCached\n}")
-
-	fmt.Fprintf(outgo, `
-// pypiPackageToDownloads holds a map of every known python package to the number
-// of times it has been downloaded. This is used for ordering the python search
-// results.
-var pypiPackageToDownloadsCached = map[string]int{}
-
-func pypiPackageToDownloads() map[string]int {
-    if len(pypiPackageToDownloadsCached) == 0 {
-        pypiPackageToDownloadsCached = map[string]int{
-		`)
-
-	for _, pkg := range pkgs {
-		fmt.Fprintf(outgo, "\t\"%v\": %d,\n", pkg.Pkg, pkg.Downloads)
+		w.Entry(m.Pkg, strings.Join(guessable, ","), "")
 	}
-	fmt.Fprintf(outgo, "}\n}\nreturn pypiPackageToDownloadsCached\n}")
-
-	err = outgo.Close()
-	if err != nil {
-		panic(err)
+	w.EndMap("pypiPackageToModules")
+
+	w.CandidatesMap("moduleToPypiCandidates", `
+moduleToPypiCandidates holds a map of every module in moduleToPypiPackage
+which is provided by more than one package to the other packages which
+provide it, most downloaded first. This is used to work out how confident
+a guess is.
+`, mods, guessableMods, chosen)
+
+	// Every package is included, not just those that could be
+	// guessed, for search.
+	w.BeginMap("pypiPackageToDownloads", "int", `
+pypiPackageToDownloads holds a map of every known python package to the number
+of times it has been downloaded. This is used for ordering the python search
+results.
+`)
+	for _, m := range pkgs {
+		w.Entry(m.Pkg, m.Downloads, "")
 	}
+	w.EndMap("pypiPackageToDownloads")
 
-	output, err := exec.Command("gofmt", "-w", "-s", *out).CombinedOutput()
-	if err != nil {
-		fmt.Println(string(output))
-		panic(err)
-	}
+	w.WriteFile(out)
 }
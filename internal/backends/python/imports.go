@@ -0,0 +1,515 @@
+package python
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/replit/upm/internal/util"
+)
+
+// pyTokenKind is the kind of a pyToken.
+type pyTokenKind int
+
+// Values for pyTokenKind.
+const (
+	// A name or keyword, e.g. "import" or "flask".
+	pyName pyTokenKind = iota
+
+	// A string literal. The text of the token is the contents of
+	// the string, without prefix or quotes, and with escape
+	// sequences left as they are.
+	pyString
+
+	// Any other single character, e.g. "." or "(". Numbers come
+	// out as a sequence of names and operators, which doesn't
+	// matter for finding imports.
+	pyOp
+)
+
+// pyToken is a token of Python source code, as returned by
+// pyTokenize.
+type pyToken struct {
+	kind pyTokenKind
+	text string
+
+	// For strings, whether the string had an "f" prefix (which
+	// means it can't be used as a module name).
+	formatted bool
+}
+
+// pyStatement is a sequence of tokens that make up one simple
+// statement, which is how pyTokenize splits up source code. Any
+// compound statement headers ("if x:", "try:", and so on) come
+// before the statement in the same sequence.
+type pyStatement struct {
+	tokens []pyToken
+
+	// The 1-based line on which the first token starts.
+	line int
+
+	// The text of the comments on the physical lines that the
+	// statement spans.
+	comments []string
+}
+
+// pyStringPrefix returns the length of the string prefix (like "rb"
+// or "f") at the start of src, if src starts with a prefixed or
+// unprefixed string literal. Otherwise it returns -1.
+func pyStringPrefix(src string) int {
+	for n := 0; n <= 2 && n < len(src); n++ {
+		if src[n] == '\'' || src[n] == '"' {
+			return n
+		}
+		if !strings.ContainsRune("rRbBuUfF", rune(src[n])) {
+			return -1
+		}
+	}
+	return -1
+}
+
+// pyImportLineRegexp matches the start of a line that begins with an
+// import statement.
+var pyImportLineRegexp = regexp.MustCompile(`^[ \t]*(?:import|from)[ \t]+[A-Za-z_.]`)
+
+// pyTokenize splits Python source code into simple statements. It is
+// tolerant of syntax errors: anything it doesn't understand is
+// passed through as operators, and unterminated strings or brackets
+// just run to the end of the source.
+func pyTokenize(src string) []pyStatement {
+	statements := []pyStatement{}
+	cur := pyStatement{}
+	// Comments belong to every statement on their physical
+	// line, so statements separated by semicolons share them.
+	lineStart := 0
+	depth := 0
+
+	// Tokens are added in order, so the line number of each can
+	// be found by counting newlines from the previous one.
+	line, lineOffset := 1, 0
+	addToken := func(start int, tok pyToken) {
+		line += strings.Count(src[lineOffset:start], "\n")
+		lineOffset = start
+		if len(cur.tokens) == 0 {
+			cur.line = line
+		}
+		cur.tokens = append(cur.tokens, tok)
+	}
+
+	flush := func() {
+		if len(cur.tokens) > 0 {
+			statements = append(statements, cur)
+		}
+		cur = pyStatement{comments: cur.comments}
+	}
+	endLine := func() {
+		flush()
+		for i := lineStart; i < len(statements); i++ {
+			statements[i].comments = cur.comments
+		}
+		lineStart = len(statements)
+		cur = pyStatement{}
+	}
+
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			i++
+			if depth > 0 && pyImportLineRegexp.MatchString(src[i:]) {
+				// An import can't be inside brackets, so
+				// there must be an unclosed bracket
+				// before it. Recover, so that one
+				// syntax error doesn't hide the imports
+				// in the rest of the file.
+				depth = 0
+			}
+			if depth == 0 {
+				endLine()
+			}
+
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f':
+			i++
+
+		case c == '\\' && i+1 < len(src) && (src[i+1] == '\n' || src[i+1] == '\r'):
+			// Explicit line joining.
+			i += 2
+			if src[i-1] == '\r' && i < len(src) && src[i] == '\n' {
+				i++
+			}
+
+		case c == '#':
+			end := strings.IndexByte(src[i:], '\n')
+			if end == -1 {
+				end = len(src) - i
+			}
+			cur.comments = append(cur.comments, src[i:i+end])
+			i += end
+
+		case c == ';' && depth == 0:
+			i++
+			flush()
+
+		case pyStringPrefix(src[i:]) != -1:
+			tokStart := i
+			n := pyStringPrefix(src[i:])
+			prefix := strings.ToLower(src[i : i+n])
+			i += n
+			quote := src[i : i+1]
+			if strings.HasPrefix(src[i:], quote+quote+quote) {
+				quote = quote + quote + quote
+			}
+			i += len(quote)
+			start := i
+			for i < len(src) && !strings.HasPrefix(src[i:], quote) {
+				if src[i] == '\\' {
+					i++
+				} else if src[i] == '\n' && len(quote) == 1 {
+					// Unterminated string.
+					break
+				}
+				i++
+			}
+			if i > len(src) {
+				i = len(src)
+			}
+			addToken(tokStart, pyToken{
+				kind:      pyString,
+				text:      src[start:i],
+				formatted: strings.Contains(prefix, "f"),
+			})
+			if strings.HasPrefix(src[i:], quote) {
+				i += len(quote)
+			}
+
+		case util.IsIdentByte(c):
+			start := i
+			for i < len(src) && util.IsIdentByte(src[i]) {
+				i++
+			}
+			addToken(start, pyToken{kind: pyName, text: src[start:i]})
+
+		default:
+			switch c {
+			case '(', '[', '{':
+				depth++
+			case ')', ']', '}':
+				if depth > 0 {
+					depth--
+				}
+			}
+			addToken(i, pyToken{kind: pyOp, text: string(c)})
+			i++
+		}
+	}
+	endLine()
+
+	return statements
+}
+
+// pyCompoundKeywords are the keywords that begin the header of a
+// compound statement, which may be followed on the same line by a
+// simple statement, as in "try: import json".
+var pyCompoundKeywords = map[string]bool{
+	"async":   true,
+	"class":   true,
+	"def":     true,
+	"elif":    true,
+	"else":    true,
+	"except":  true,
+	"finally": true,
+	"for":     true,
+	"if":      true,
+	"try":     true,
+	"while":   true,
+	"with":    true,
+}
+
+// skipCompoundHeaders returns tokens without any compound statement
+// headers at the start.
+func skipCompoundHeaders(tokens []pyToken) []pyToken {
+	for len(tokens) > 0 && tokens[0].kind == pyName && pyCompoundKeywords[tokens[0].text] {
+		depth := 0
+		colon := -1
+		for i, tok := range tokens {
+			if tok.kind != pyOp {
+				continue
+			}
+			switch tok.text {
+			case "(", "[", "{":
+				depth++
+			case ")", "]", "}":
+				depth--
+			case ":":
+				if depth == 0 && colon == -1 {
+					colon = i
+				}
+			}
+			if colon != -1 {
+				break
+			}
+		}
+		if colon == -1 {
+			return nil
+		}
+		tokens = tokens[colon+1:]
+	}
+	return tokens
+}
+
+// parseDottedName parses a dotted name like "a.b.c" from the start
+// of tokens. It returns the name and the remaining tokens, or the
+// empty string if there is no name.
+func parseDottedName(tokens []pyToken) (string, []pyToken) {
+	parts := []string{}
+	for len(tokens) > 0 && tokens[0].kind == pyName {
+		parts = append(parts, tokens[0].text)
+		tokens = tokens[1:]
+		if len(tokens) > 0 && tokens[0].kind == pyOp && tokens[0].text == "." {
+			tokens = tokens[1:]
+		} else {
+			break
+		}
+	}
+	return strings.Join(parts, "."), tokens
+}
+
+// statementImports returns the names of the modules imported by an
+// import statement, or nothing if the statement is something else.
+// Relative imports are left out, since they are always of modules in
+// the project.
+func statementImports(tokens []pyToken) []string {
+	if len(tokens) == 0 || tokens[0].kind != pyName {
+		return nil
+	}
+	switch tokens[0].text {
+	case "import":
+		// import a.b as c, d
+		mods := []string{}
+		tokens = tokens[1:]
+		for {
+			var mod string
+			mod, tokens = parseDottedName(tokens)
+			if mod == "" {
+				return mods
+			}
+			mods = append(mods, mod)
+			if len(tokens) >= 2 && tokens[0].kind == pyName && tokens[0].text == "as" {
+				tokens = tokens[2:]
+			}
+			if len(tokens) == 0 || tokens[0].kind != pyOp || tokens[0].text != "," {
+				return mods
+			}
+			tokens = tokens[1:]
+		}
+
+	case "from":
+		// from a.b import c
+		tokens = tokens[1:]
+		if len(tokens) > 0 && tokens[0].kind == pyOp && tokens[0].text == "." {
+			return nil
+		}
+		mod, tokens := parseDottedName(tokens)
+		if mod == "" || len(tokens) == 0 || tokens[0].kind != pyName || tokens[0].text != "import" {
+			return nil
+		}
+		return []string{mod}
+	}
+	return nil
+}
+
+// dynamicImports returns the names of the modules imported by calls to
+// __import__ or importlib.import_module in tokens, where the module
+// name is a string literal.
+func dynamicImports(tokens []pyToken) []string {
+	mods := []string{}
+	for i := 0; i+2 < len(tokens); i++ {
+		if tokens[i].kind != pyName ||
+			(tokens[i].text != "__import__" && tokens[i].text != "import_module") {
+			continue
+		}
+		if tokens[i+1].kind != pyOp || tokens[i+1].text != "(" {
+			continue
+		}
+		if tokens[i+2].kind != pyString || tokens[i+2].formatted {
+			continue
+		}
+		mod := tokens[i+2].text
+		if mod == "" || strings.HasPrefix(mod, ".") || strings.ContainsAny(mod, " \t\\{}%") {
+			continue
+		}
+		mods = append(mods, mod)
+	}
+	return mods
+}
+
+// pragmaPackageRegexp matches a pragma in a comment on an import
+// statement which says which package provides the module, e.g.
+// "#upm package(opencv-python)".
+var pragmaPackageRegexp = regexp.MustCompile(`#upm package\((.*)\)`)
+
+// pyImport is an import found by findImports.
+type pyImport struct {
+	// The top-level module, e.g. "django" for "import
+	// django.conf".
+	module string
+
+	// The package given by a "#upm package(...)" pragma on the
+	// import, or the empty string. If the module is empty, this
+	// is instead a package installed by a notebook, which is a
+	// hint that the project needs it.
+	pkg string
+
+	// The line of the first statement that imports the module.
+	// This isn't included in the String form.
+	line int
+}
+
+// String returns the form of the import returned from
+// GuessFileImports: the module, followed by "=" and the package if
+// there is a pragma.
+func (imp pyImport) String() string {
+	if imp.pkg == "" {
+		return imp.module
+	}
+	return imp.module + "=" + imp.pkg
+}
+
+// parsePyImport is the inverse of pyImport.String.
+func parsePyImport(str string) pyImport {
+	parts := strings.SplitN(str, "=", 2)
+	if len(parts) == 2 {
+		return pyImport{module: parts[0], pkg: parts[1]}
+	}
+	return pyImport{module: str}
+}
+
+// findImports returns the imports in Python source code, in the order
+// they appear. Each module is returned once. If a module is imported
+// more than once, the last pragma for it wins.
+func findImports(src string) []pyImport {
+	imports := []pyImport{}
+	index := map[string]int{}
+	for _, stmt := range pyTokenize(src) {
+		tokens := skipCompoundHeaders(stmt.tokens)
+		mods := append(statementImports(tokens), dynamicImports(tokens)...)
+		if len(mods) == 0 {
+			continue
+		}
+
+		pkg := ""
+		for _, comment := range stmt.comments {
+			if m := pragmaPackageRegexp.FindStringSubmatch(comment); m != nil {
+				pkg = m[1]
+			}
+		}
+
+		for _, mod := range mods {
+			mod = strings.SplitN(mod, ".", 2)[0]
+			if i, ok := index[mod]; ok {
+				imports[i].pkg = pkg
+				continue
+			}
+			index[mod] = len(imports)
+			imports = append(imports, pyImport{module: mod, pkg: pkg, line: stmt.line})
+		}
+	}
+	return imports
+}
+
+// fileImports returns the imports in a Python file or Jupyter
+// notebook, followed for a notebook by its package hints. Notebooks
+// which aren't valid JSON have no imports.
+func fileImports(file string) []pyImport {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		util.Die("%s: %s", file, err)
+	}
+	if filepath.Ext(file) != ".ipynb" {
+		return findImports(string(contents))
+	}
+
+	nb, ok := readNotebook(contents)
+	if !ok {
+		return []pyImport{}
+	}
+	imports := findImports(nb.code)
+	for i := range imports {
+		imports[i].line = nb.lines[imports[i].line-1]
+	}
+	return append(imports, nb.hints...)
+}
+
+// guessFileImports implements GuessFileImports for Python. Files are
+// scanned for imports without running Python, so every file can be
+// analyzed even if it has syntax errors.
+func guessFileImports(files []string) map[string][]string {
+	results := map[string][]string{}
+	for _, file := range files {
+		imports := []string{}
+		for _, imp := range fileImports(file) {
+			imports = append(imports, imp.String())
+		}
+		results[file] = imports
+	}
+	return results
+}
+
+// localModules returns the names of the modules which may be provided
+// by the project itself, namely the basenames of the Python files
+// (without extension) and of the subdirectories of the project.
+func localModules() map[string]bool {
+	mods := map[string]bool{}
+	filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			util.Die("%s: %s", path, err)
+		}
+		for _, name := range util.IgnoredPaths {
+			if filepath.Base(path) == name {
+				return filepath.SkipDir
+			}
+		}
+		if info.IsDir() {
+			mods[filepath.Base(path)] = true
+		} else if filepath.Ext(path) == ".py" {
+			mods[strings.TrimSuffix(filepath.Base(path), ".py")] = true
+		}
+		return nil
+	})
+	return mods
+}
+
+// installedPackages returns the packages installed by notebooks,
+// given the imports (as returned by GuessFileImports) of the files of
+// the project.
+func installedPackages(imports []string) []string {
+	pkgs := []string{}
+	for _, str := range imports {
+		if imp := parsePyImport(str); imp.module == "" {
+			pkgs = append(pkgs, imp.pkg)
+		}
+	}
+	return pkgs
+}
+
+// externalImports combines the imports (as returned by
+// GuessFileImports) of the files of the project, and returns a map
+// from each module which isn't provided by the project itself to the
+// package given for it by a pragma, if any. If there is a pragma for
+// a module in any file, it is used.
+func externalImports(imports []string) map[string]string {
+	local := localModules()
+	mods := map[string]string{}
+	for _, str := range imports {
+		imp := parsePyImport(str)
+		if imp.module == "" || local[imp.module] {
+			continue
+		}
+		if pkg, ok := mods[imp.module]; !ok || pkg == "" {
+			mods[imp.module] = imp.pkg
+		}
+	}
+	return mods
+}
@@ -0,0 +1,127 @@
+package python
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/replit/upm/internal/util"
+)
+
+func TestFindImports(t *testing.T) {
+	tcs := []struct {
+		scenario string
+		src      string
+		expected []pyImport
+	}{
+		{
+			scenario: "Returns the top-level module of dotted imports",
+			src:      "import a.b.c as d\nfrom e.f import g",
+			expected: []pyImport{{module: "a", line: 1}, {module: "e", line: 2}},
+		},
+		{
+			scenario: "Returns every module of a multiple import",
+			src:      "import a, b.c as d, e",
+			expected: []pyImport{{module: "a", line: 1}, {module: "b", line: 1}, {module: "e", line: 1}},
+		},
+		{
+			scenario: "Ignores relative imports",
+			src:      "from . import a\nfrom .b import c\nfrom ..d.e import f",
+			expected: []pyImport{},
+		},
+		{
+			scenario: "Finds imports after semicolons and compound statement headers",
+			src:      "x = 1; import a\nif x: import b\nfor y in z: from c import d\nwith e as f: import g",
+			expected: []pyImport{{module: "a", line: 1}, {module: "b", line: 2}, {module: "c", line: 3}, {module: "g", line: 4}},
+		},
+		{
+			scenario: "Finds imports spanning lines",
+			src:      "from a import (\n    b,\n    c,\n)\nimport d \\\n    as e",
+			expected: []pyImport{{module: "a", line: 1}, {module: "d", line: 5}},
+		},
+		{
+			scenario: "Ignores imports in strings and comments",
+			src:      "'''\nimport a\n'''\nb = \"import c\"\n# import d\nimport e",
+			expected: []pyImport{{module: "e", line: 6}},
+		},
+		{
+			scenario: "Finds modules imported by __import__ and importlib",
+			src:      "a = __import__('a.b')\nc = importlib.import_module(\"c\")\nd = import_module(name)\ne = import_module(f'{e}')",
+			expected: []pyImport{{module: "a", line: 1}, {module: "c", line: 2}},
+		},
+		{
+			scenario: "Recovers from unclosed brackets",
+			src:      "x = foo(\nimport a\nfrom b import c",
+			expected: []pyImport{{module: "a", line: 2}, {module: "b", line: 3}},
+		},
+		{
+			scenario: "Reads package pragmas",
+			src:      "import cv2 #upm package(opencv-python)\nfrom sklearn import (  # classifiers #upm package(scikit-learn)\n    svm,\n)",
+			expected: []pyImport{{module: "cv2", pkg: "opencv-python", line: 1}, {module: "sklearn", pkg: "scikit-learn", line: 2}},
+		},
+		{
+			scenario: "Uses the last pragma for a module imported twice",
+			src:      "import a #upm package(b)\nimport a.c #upm package(d)",
+			expected: []pyImport{{module: "a", pkg: "d", line: 1}},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.scenario, func(t *testing.T) {
+			imports := findImports(tc.src)
+			if !reflect.DeepEqual(imports, tc.expected) {
+				t.Errorf("expected %+v, got %+v", tc.expected, imports)
+			}
+		})
+	}
+}
+
+// TestExternalImports checks that the Go scanner agrees with the
+// bare-imports.py script on the corpus in testdata/imports. The
+// expected output was generated by running, in testdata/imports:
+//
+//	PYTHONPATH=../../../../../resources/python python3 ../../../../../resources/python/bare-imports.py ""
+//
+// The script only looks at import statements, so the modules that
+// testdata/imports/dynamic.py imports by name with __import__ and
+// importlib.import_module are added to what it finds.
+func TestExternalImports(t *testing.T) {
+	contents, err := ioutil.ReadFile("testdata/imports.expected.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output struct {
+		Imports map[string]struct {
+			Package string `json:"package"`
+		} `json:"imports"`
+	}
+	if err := json.Unmarshal(contents, &output); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{}
+	for mod, pragmas := range output.Imports {
+		expected[mod] = pragmas.Package
+	}
+	expected["redis"] = ""
+	expected["ruamel"] = ""
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir("testdata/imports"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+
+	imports := []string{}
+	for _, fileImports := range guessFileImports(util.ListFilesRecursive([]string{"*.py"})) {
+		imports = append(imports, fileImports...)
+	}
+
+	if mods := externalImports(imports); !reflect.DeepEqual(mods, expected) {
+		t.Errorf("expected %v, got %v", expected, mods)
+	}
+}
@@ -0,0 +1,271 @@
+package python
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// notebookString is a string in the source of a notebook cell, with
+// the line of the notebook file that it is on.
+type notebookString struct {
+	text string
+	line int
+}
+
+// notebookCell is a cell of a Jupyter notebook, as returned by
+// parseNotebook.
+type notebookCell struct {
+	cellType string
+
+	// The source is either one string, or more often a list of
+	// strings, one for each line.
+	source []notebookString
+}
+
+// parseNotebook returns the cells of a Jupyter notebook, along with
+// the language of its kernel, if the notebook says. The notebook is
+// read token by token, rather than with json.Unmarshal, so that the
+// line of each source string in the file is known.
+func parseNotebook(contents []byte) ([]notebookCell, string, error) {
+	dec := json.NewDecoder(bytes.NewReader(contents))
+	cells := []notebookCell{}
+	language := ""
+
+	// Strings are read in order, so the line of each can be
+	// found by counting newlines from the previous one.
+	line, offset := 1, 0
+	lineAt := func() int {
+		end := int(dec.InputOffset())
+		line += bytes.Count(contents[offset:end], []byte("\n"))
+		offset = end
+		return line
+	}
+
+	// path is like ".cells[].source", where "[]" stands for any
+	// array element.
+	var walk func(path string) error
+	walk = func(path string) error {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'):
+			if path == ".cells[]" {
+				cells = append(cells, notebookCell{})
+			}
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				if err := walk(path + "." + key.(string)); err != nil {
+					return err
+				}
+			}
+			_, err := dec.Token()
+			return err
+
+		case json.Delim('['):
+			for dec.More() {
+				if err := walk(path + "[]"); err != nil {
+					return err
+				}
+			}
+			_, err := dec.Token()
+			return err
+		}
+
+		str, ok := tok.(string)
+		if !ok {
+			return nil
+		}
+		switch path {
+		case ".cells[].cell_type":
+			cells[len(cells)-1].cellType = str
+		case ".cells[].source", ".cells[].source[]":
+			cell := &cells[len(cells)-1]
+			cell.source = append(cell.source, notebookString{text: str, line: lineAt()})
+		case ".metadata.kernelspec.language", ".metadata.language_info.name":
+			language = str
+		}
+		return nil
+	}
+
+	if err := walk(""); err != nil {
+		return nil, "", err
+	}
+	return cells, language, nil
+}
+
+// lines returns the lines of the source of a cell.
+func (cell notebookCell) lines() []notebookString {
+	lines := []notebookString{}
+	cur := notebookString{}
+	started := false
+	for _, str := range cell.source {
+		pieces := strings.Split(str.text, "\n")
+		for i, piece := range pieces {
+			if !started {
+				if i == len(pieces)-1 && piece == "" {
+					// The string ended with a newline.
+					break
+				}
+				cur = notebookString{line: str.line}
+				started = true
+			}
+			cur.text += piece
+			if i < len(pieces)-1 {
+				lines = append(lines, cur)
+				started = false
+			}
+		}
+	}
+	if started {
+		lines = append(lines, cur)
+	}
+	return lines
+}
+
+// pythonCellMagics are the IPython cell magics whose body is Python
+// code.
+var pythonCellMagics = map[string]bool{
+	"capture": true,
+	"prun":    true,
+	"time":    true,
+	"timeit":  true,
+}
+
+// pipInstallRegexp matches a line magic or shell command in a
+// notebook which installs packages with pip, capturing the
+// arguments.
+var pipInstallRegexp = regexp.MustCompile(`^\s*[%!]\s*(?:pip3?|(?:python[0-9.]*|\{sys\.executable\})\s+-m\s+pip)\s+install\b([^#;&|>]*)`)
+
+// pipValueOptions are the options of pip install which take a value
+// as a separate argument.
+var pipValueOptions = map[string]bool{
+	"-r":                 true,
+	"--requirement":      true,
+	"-c":                 true,
+	"--constraint":       true,
+	"-e":                 true,
+	"--editable":         true,
+	"-i":                 true,
+	"--index-url":        true,
+	"--extra-index-url":  true,
+	"-f":                 true,
+	"--find-links":       true,
+	"-t":                 true,
+	"--target":           true,
+	"--prefix":           true,
+	"--root":             true,
+	"--src":              true,
+	"--upgrade-strategy": true,
+	"--platform":         true,
+	"--python-version":   true,
+	"--implementation":   true,
+	"--abi":              true,
+	"--trusted-host":     true,
+	"--cache-dir":        true,
+	"--log":              true,
+	"--proxy":            true,
+}
+
+// pipRequirementRegexp matches a requirement given to pip install,
+// capturing the package name, e.g. "pandas" in "pandas[excel]>=2.0".
+var pipRequirementRegexp = regexp.MustCompile(`^([A-Za-z0-9](?:[A-Za-z0-9._-]*[A-Za-z0-9])?)(?:\[[^\]]*\])?(?:[<>=!~;@,].*)?$`)
+
+// pipInstallPackages returns the packages installed by a pip install
+// command in a notebook, or nothing if the line isn't one. Files,
+// URLs and variables are skipped.
+func pipInstallPackages(line string) []string {
+	match := pipInstallRegexp.FindStringSubmatch(line)
+	if match == nil {
+		return nil
+	}
+	pkgs := []string{}
+	args := strings.Fields(match[1])
+	for i := 0; i < len(args); i++ {
+		arg := strings.Trim(args[i], `'"`)
+		if strings.HasPrefix(arg, "-") {
+			if pipValueOptions[arg] {
+				i++
+			}
+			continue
+		}
+		if strings.ContainsAny(arg, "/\\$") {
+			continue
+		}
+		if m := pipRequirementRegexp.FindStringSubmatch(arg); m != nil {
+			pkgs = append(pkgs, m[1])
+		}
+	}
+	return pkgs
+}
+
+// notebookSource is the Python code of a Jupyter notebook, as
+// returned by readNotebook.
+type notebookSource struct {
+	// The code of the code cells, one after the other, with
+	// IPython magics and shell commands blanked out.
+	code string
+
+	// The line in the notebook file of each line of the code.
+	lines []int
+
+	// The packages installed by pip install commands, with
+	// empty modules, as in the String form of pyImport.
+	hints []pyImport
+}
+
+// readNotebook extracts the Python code from a Jupyter notebook. It
+// returns false if the notebook isn't valid JSON.
+func readNotebook(contents []byte) (notebookSource, bool) {
+	nb := notebookSource{hints: []pyImport{}}
+	cells, language, err := parseNotebook(contents)
+	if err != nil {
+		return nb, false
+	}
+	if language != "" && strings.ToLower(language) != "python" {
+		return nb, true
+	}
+
+	hinted := map[string]bool{}
+	code := strings.Builder{}
+	for _, cell := range cells {
+		if cell.cellType != "code" {
+			continue
+		}
+		lines := cell.lines()
+		skipCell := false
+		for i, line := range lines {
+			text := line.text
+			trimmed := strings.TrimSpace(text)
+			switch {
+			case i == 0 && strings.HasPrefix(trimmed, "%%"):
+				// A cell magic, like %%bash, runs the
+				// rest of the cell itself.
+				name := strings.TrimPrefix(strings.Fields(trimmed)[0], "%%")
+				skipCell = !pythonCellMagics[name]
+				text = ""
+			case skipCell:
+				text = ""
+			case strings.HasPrefix(trimmed, "%") || strings.HasPrefix(trimmed, "!"):
+				for _, pkg := range pipInstallPackages(text) {
+					if !hinted[pkg] {
+						hinted[pkg] = true
+						nb.hints = append(nb.hints, pyImport{pkg: pkg, line: line.line})
+					}
+				}
+				text = ""
+			}
+			code.WriteString(text)
+			code.WriteString("\n")
+			nb.lines = append(nb.lines, line.line)
+		}
+	}
+	nb.code = code.String()
+	return nb, true
+}
@@ -0,0 +1,97 @@
+package python
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReadNotebookImports(t *testing.T) {
+	contents := `{
+ "cells": [
+  {
+   "cell_type": "markdown",
+   "metadata": {},
+   "source": ["import not_code\n"]
+  },
+  {
+   "cell_type": "code",
+   "execution_count": 1,
+   "metadata": {},
+   "outputs": [],
+   "source": [
+    "%pip install -q pandas 'scikit-learn>=1.0' -r requirements.txt\n",
+    "!pip install -U --index-url https://example.com/simple seaborn[stats] # plots\n",
+    "%matplotlib inline\n",
+    "import pandas as pd\n",
+    "from sklearn import svm"
+   ]
+  },
+  {
+   "cell_type": "code",
+   "execution_count": 2,
+   "metadata": {},
+   "outputs": [],
+   "source": "%%bash\nimport not_python\n"
+  },
+  {
+   "cell_type": "code",
+   "execution_count": 3,
+   "metadata": {},
+   "outputs": [],
+   "source": [
+    "%%time\n",
+    "files = !ls\n",
+    "import requests"
+   ]
+  }
+ ],
+ "metadata": {
+  "kernelspec": {"display_name": "Python 3", "language": "python", "name": "python3"}
+ },
+ "nbformat": 4,
+ "nbformat_minor": 5
+}
+`
+	nb, ok := readNotebook([]byte(contents))
+	if !ok {
+		t.Fatal("expected a valid notebook")
+	}
+
+	imports := findImports(nb.code)
+	for i := range imports {
+		imports[i].line = nb.lines[imports[i].line-1]
+	}
+	expected := []pyImport{{module: "pandas", line: 17}, {module: "sklearn", line: 18}, {module: "requests", line: 36}}
+	if !reflect.DeepEqual(imports, expected) {
+		t.Errorf("expected imports %+v, got %+v", expected, imports)
+	}
+
+	expectedHints := []pyImport{{pkg: "pandas", line: 14}, {pkg: "scikit-learn", line: 14}, {pkg: "seaborn", line: 15}}
+	if !reflect.DeepEqual(nb.hints, expectedHints) {
+		t.Errorf("expected hints %+v, got %+v", expectedHints, nb.hints)
+	}
+}
+
+func TestPipInstallPackages(t *testing.T) {
+	tcs := []struct {
+		line     string
+		expected []string
+	}{
+		{"!pip install numpy", []string{"numpy"}},
+		{"%pip install --upgrade numpy==1.26 matplotlib", []string{"numpy", "matplotlib"}},
+		{"!python -m pip install -e . torch", []string{"torch"}},
+		{"!{sys.executable} -m pip install tqdm && echo done", []string{"tqdm"}},
+		{"!pip install git+https://github.com/org/repo.git ./local.whl $PKG", []string{}},
+		{"!pip3 install 'transformers[torch]' \"accelerate>=0.20\"", []string{"transformers", "accelerate"}},
+		{"!pip list", nil},
+		{"%conda install numpy", nil},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.line, func(t *testing.T) {
+			if pkgs := pipInstallPackages(tc.line); !reflect.DeepEqual(pkgs, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, pkgs)
+			}
+		})
+	}
+}
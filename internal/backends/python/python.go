@@ -8,9 +8,11 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/replit/upm/internal/api"
@@ -32,9 +34,22 @@ type pypiEntry struct {
 // pypiEntryInfoResponse is a wrapper around pypiEntryInfo
 // that matches the format of the REST API
 type pypiEntryInfoResponse struct {
-	Info pypiEntryInfo `json:"info"`
+	Info     pypiEntryInfo             `json:"info"`
+	Releases map[string][]pypiFileInfo `json:"releases"`
 }
 
+// pypiFileInfo represents one of the files (sdists and wheels)
+// uploaded for a release, as listed in pypiEntryInfoResponse.
+type pypiFileInfo struct {
+	UploadTime string `json:"upload_time_iso_8601"`
+	Yanked     bool   `json:"yanked"`
+}
+
+// pypiSourceCodeLabels are the (lowercased) labels which projects
+// commonly use in their project_urls metadata to link to their
+// source code.
+var pypiSourceCodeLabels = []string{"source", "source code", "code", "repository", "github"}
+
 // pypiEntryInfo represents the response we get from the
 // PyPI API on doing a single-package lookup.
 type pypiEntryInfo struct {
@@ -50,6 +65,9 @@ type pypiEntryInfo struct {
 	RequiresDist  []string `json:"requires_dist"`
 	Summary       string   `json:"summary"`
 	Version       string   `json:"version"`
+	Yanked        bool     `json:"yanked"`
+
+	ProjectURLs map[string]string `json:"project_urls"`
 }
 
 // pyprojectTOML represents the relevant parts of a pyproject.toml
@@ -75,12 +93,6 @@ type poetryLock struct {
 	} `json:"package"`
 }
 
-// moduleMetadata represents the information that could be associated with
-// a module using a #upm pragma
-type modulePragmas struct {
-	Package string `json:"package"`
-}
-
 // normalizeSpec returns the version string from a Poetry spec, or the
 // empty string. The Poetry spec may be either a string or a
 // map[string]interface{} with a "version" key that is a string. If
@@ -107,71 +119,200 @@ func normalizePackageName(name api.PkgName) api.PkgName {
 	return api.PkgName(nameStr)
 }
 
-// pythonMakeBackend returns a language backend for a given version of
-// Python. name is either "python2" or "python3", and python is the
-// name of an executable (either a full path or just a name like
-// "python3") to use when invoking Python. (This is used to implement
-// UPM_PYTHON2 and UPM_PYTHON3.)
-func pythonMakeBackend(name string, python string) api.LanguageBackend {
-	info_func := func(name api.PkgName) api.PkgInfo {
-		res, err := http.Get(fmt.Sprintf("https://pypi.org/pypi/%s/json", string(name)))
+// pythonPrereleaseRegexp matches PEP 440 versions which are
+// prereleases, such as 2.0a1, 2.0rc2, or 2.0.dev3.
+var pythonPrereleaseRegexp = regexp.MustCompile(`(?i)^v?[0-9]+(\.[0-9]+)*[-_.]?(a|b|c|rc|alpha|beta|pre|preview)[-_.]?[0-9]*|[-_.]dev[0-9]*$`)
+
+// pypiVersions converts the releases listed by the PyPI API into a
+// version list. PyPI doesn't order releases, and not every version
+// string can be parsed, so we order them by when their first file
+// was uploaded. A release is considered yanked if all of its files
+// were yanked.
+func pypiVersions(releases map[string][]pypiFileInfo) api.PkgVersions {
+	versions := api.PkgVersions{}
+	for versionStr, files := range releases {
+		version := api.PkgVersionInfo{
+			Version:    versionStr,
+			Prerelease: pythonPrereleaseRegexp.MatchString(versionStr),
+		}
+		version.Yanked = len(files) > 0
+		for _, file := range files {
+			if !file.Yanked {
+				version.Yanked = false
+			}
+
+			uploadTime, err := time.Parse(time.RFC3339, file.UploadTime)
+			if err != nil {
+				continue
+			}
+			if version.PublishedAt == nil || uploadTime.Before(*version.PublishedAt) {
+				version.PublishedAt = &uploadTime
+			}
+		}
+		versions = append(versions, version)
+	}
 
-		if err != nil {
-			util.Die("HTTP Request failed with error: %s", err)
+	sort.Slice(versions, func(i, j int) bool {
+		ti, tj := versions[i].PublishedAt, versions[j].PublishedAt
+		if ti == nil || tj == nil || ti.Equal(*tj) {
+			// Releases without files go first.
+			if (ti == nil) != (tj == nil) {
+				return ti == nil
+			}
+			return versions[i].Version < versions[j].Version
 		}
+		return ti.Before(*tj)
+	})
+	return versions
+}
+
+// fetchPypiInfo retrieves package information from the given PyPI
+// JSON API endpoint, which is either for the latest version of a
+// package or for a specific version. It returns a zero struct if the
+// package or version doesn't exist.
+func fetchPypiInfo(endpoint string) api.PkgInfo {
+	res, err := http.Get(endpoint)
+
+	if err != nil {
+		util.Die("HTTP Request failed with error: %s", err)
+	}
 
-		defer res.Body.Close()
+	defer res.Body.Close()
 
-		if res.StatusCode == 404 {
-			return api.PkgInfo{}
+	if res.StatusCode == 404 {
+		return api.PkgInfo{}
+	}
+
+	if res.StatusCode != 200 {
+		util.Die("Received status code: %d", res.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		util.Die("Res body read failed with error: %s", err)
+	}
+
+	var output pypiEntryInfoResponse
+	if err := json.Unmarshal(body, &output); err != nil {
+		util.Die("PyPI response: %s", err)
+	}
+
+	info := api.PkgInfo{
+		Name:             output.Info.Name,
+		Description:      output.Info.Summary,
+		Version:          output.Info.Version,
+		HomepageURL:      output.Info.HomePage,
+		DocumentationURL: output.Info.DocsURL,
+		BugTrackerURL:    output.Info.BugTrackerURL,
+		Author: util.AuthorInfo{
+			Name:  output.Info.Author,
+			Email: output.Info.AuthorEmail,
+		}.String(),
+		License:   output.Info.License,
+		Yanked:    output.Info.Yanked,
+		Downloads: pypiPackageToDownloads()[output.Info.Name],
+	}
+
+	for label, url := range output.Info.ProjectURLs {
+		for _, sourceLabel := range pypiSourceCodeLabels {
+			if strings.ToLower(label) == sourceLabel {
+				info.SourceCodeURL = url
+			}
 		}
+	}
 
-		if res.StatusCode != 200 {
-			util.Die("Received status code: %d", res.StatusCode)
+	info.Versions = pypiVersions(output.Releases)
+	for _, version := range info.Versions {
+		if version.Version == info.Version {
+			info.PublishedAt = version.PublishedAt
 		}
+	}
 
-		body, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			util.Die("Res body read failed with error: %s", err)
+	deps := []string{}
+	for _, line := range output.Info.RequiresDist {
+		if strings.Contains(line, "extra ==") {
+			continue
 		}
 
-		var output pypiEntryInfoResponse
-		if err := json.Unmarshal(body, &output); err != nil {
-			util.Die("PyPI response: %s", err)
+		deps = append(deps, strings.Fields(line)[0])
+	}
+	info.Dependencies = deps
+
+	return info
+}
+
+// pythonPatterns is the FilenamePatterns value for the Python
+// backends. The code cells of Jupyter notebooks are read by
+// readNotebook.
+var pythonPatterns = []string{"*.py", "*.ipynb"}
+
+// pythonMakeBackend returns a language backend for a given version of
+// Python. name is either "python2" or "python3", and python is the
+// name of an executable (either a full path or just a name like
+// "python3") to use when invoking Python. (This is used to implement
+// UPM_PYTHON2 and UPM_PYTHON3.)
+func pythonMakeBackend(name string, python string) api.LanguageBackend {
+	info_func := func(name api.PkgName) api.PkgInfo {
+		return fetchPypiInfo(fmt.Sprintf("https://pypi.org/pypi/%s/json", string(name)))
+	}
+
+	// A negative limit means no limit.
+	search_func := func(query string, offset int, limit int) []api.PkgInfo {
+		// Do a search on pypiPackageToModules, ranking the
+		// results by downloads so that we only have to look
+		// up the ones on the requested page
+		var packages []string
+		for p, _ := range pypiPackageToModules() {
+			if strings.Contains(p, query) {
+				packages = append(packages, p)
+			}
+		}
+		downloads := pypiPackageToDownloads()
+		sort.Slice(packages, func(i, j int) bool {
+			if downloads[packages[i]] != downloads[packages[j]] {
+				return downloads[packages[i]] > downloads[packages[j]]
+			}
+			return packages[i] < packages[j]
+		})
+		if offset >= len(packages) {
+			return []api.PkgInfo{}
+		}
+		packages = packages[offset:]
+		if limit >= 0 && len(packages) > limit {
+			packages = packages[:limit]
 		}
 
-		info := api.PkgInfo{
-			Name:             output.Info.Name,
-			Description:      output.Info.Summary,
-			Version:          output.Info.Version,
-			HomepageURL:      output.Info.HomePage,
-			DocumentationURL: output.Info.DocsURL,
-			BugTrackerURL:    output.Info.BugTrackerURL,
-			Author: util.AuthorInfo{
-				Name:  output.Info.Author,
-				Email: output.Info.AuthorEmail,
-			}.String(),
-			License: output.Info.License,
+		// Lookup the package info for each result
+		var barrier sync.WaitGroup
+		infos := make([]api.PkgInfo, len(packages))
+		for i, p := range packages {
+			barrier.Add(1)
+			go func(i int, name api.PkgName) {
+				infos[i] = info_func(name)
+				barrier.Done()
+			}(i, api.PkgName(p))
 		}
+		barrier.Wait()
 
-		deps := []string{}
-		for _, line := range output.Info.RequiresDist {
-			if strings.Contains(line, "extra ==") {
+		results := []api.PkgInfo{}
+		for _, pkg := range infos {
+			if pkg.Name == "" {
 				continue
 			}
-
-			deps = append(deps, strings.Fields(line)[0])
+			// The full version history is too much detail
+			// for search results.
+			pkg.Versions = nil
+			results = append(results, pkg)
 		}
-		info.Dependencies = deps
-
-		return info
+		return results
 	}
 
 	return api.LanguageBackend{
 		Name:             "python-" + name + "-poetry",
 		Specfile:         "pyproject.toml",
 		Lockfile:         "poetry.lock",
-		FilenamePatterns: []string{"*.py"},
+		FilenamePatterns: pythonPatterns,
+		Executables:      []string{python},
 		Quirks: api.QuirksAddRemoveAlsoLocks |
 			api.QuirksAddRemoveAlsoInstalls,
 		NormalizePackageName: normalizePackageName,
@@ -231,40 +372,19 @@ func pythonMakeBackend(name string, python string) api.LanguageBackend {
 
 			return filepath.Join(path, base+"-py"+version)
 		},
+		PackageDirMarkers: []string{
+			"lib/python*/site-packages/*.dist-info",
+			// Virtualenvs on Windows.
+			"Lib/site-packages/*.dist-info",
+		},
 		Search: func(query string) []api.PkgInfo {
-			// Do a search on pypiPackageToModules
-			var packages []string
-			for p, _ := range pypiPackageToModules() {
-				if strings.Contains(p, query) {
-					packages = append(packages, p)
-				}
-			}
-
-			// Lookup the package info for each result
-			var barrier sync.WaitGroup
-			packageQueries := make(chan api.PkgInfo, len(packages))
-			for _, p := range packages {
-				barrier.Add(1)
-				go func(name api.PkgName) {
-					packageQueries <- info_func(name)
-					barrier.Done()
-				}(api.PkgName(p))
-			}
-			barrier.Wait()
-			close(packageQueries)
-
-			results := []api.PkgInfo{}
-			for pkg := range packageQueries {
-				results = append(results, pkg)
-			}
-
-			sort.Slice(results, func(i, j int) bool {
-				return pypiPackageToDownloads()[results[i].Name] > pypiPackageToDownloads()[results[j].Name]
-			})
-
-			return results
+			return search_func(query, 0, -1)
+		},
+		SearchPaged: search_func,
+		Info:        info_func,
+		VersionInfo: func(name api.PkgName, version api.PkgVersion) api.PkgInfo {
+			return fetchPypiInfo(fmt.Sprintf("https://pypi.org/pypi/%s/%s/json", string(name), string(version)))
 		},
-		Info: info_func,
 		Add: func(pkgs map[api.PkgName]api.PkgSpec, projectName string) {
 			// Initalize the specfile if it doesnt exist
 			if !util.Exists("pyproject.toml") {
@@ -334,15 +454,10 @@ func pythonMakeBackend(name string, python string) api.LanguageBackend {
 			}
 			return pkgs
 		},
-		GuessRegexps: util.Regexps([]string{
-			// The (?:.|\\\n) subexpression allows us to
-			// match match multiple lines if
-			// backslash-escapes are used on the newlines.
-			`from (?:.|\\\n) import`,
-			`import ((?:.|\\\n)*) as`,
-			`import ((?:.|\\\n)*)`,
-		}),
-		Guess: func() (map[api.PkgName]bool, bool) { return guess(python) },
+		Guess:            guess,
+		GuessFileImports: guessFileImports,
+		GuessFromImports: guessFromImports,
+		GuessExplain:     guessExplain,
 	}
 }
 
@@ -378,63 +493,156 @@ func listSpecfile() (map[api.PkgName]api.PkgSpec, error) {
 	return pkgs, nil
 }
 
-func guess(python string) (map[api.PkgName]bool, bool) {
-	tempdir := util.TempDir()
-	defer os.RemoveAll(tempdir)
+// availableModules returns the modules provided by the packages in
+// the specfile, and by the given packages installed by notebooks, so
+// far as the generated PyPI map knows.
+func availableModules(installed []string) map[string]bool {
+	availMods := map[string]bool{}
 
-	util.WriteResource("/python/pipreqs.py", tempdir)
-	script := util.WriteResource("/python/bare-imports.py", tempdir)
+	pkgNames := installed
+	if knownPkgs, err := listSpecfile(); err == nil {
+		for pkgName := range knownPkgs {
+			pkgNames = append(pkgNames, string(pkgName))
+		}
+	}
+	for _, pkgName := range pkgNames {
+		mods, ok := pypiPackageToModules()[pkgName]
+		if ok {
+			for _, mod := range strings.Split(mods, ",") {
+				availMods[mod] = true
+			}
+		}
+	}
 
-	outputB := util.GetCmdOutput([]string{
-		python, script, strings.Join(util.IgnoredPaths, " "),
-	})
+	return availMods
+}
 
-	var output struct {
-		Imports map[string]modulePragmas `json:"imports"`
-		Success bool                     `json:"success"`
+// installedPackage returns the reason for guessing a package which
+// is installed by a notebook.
+func installedPackage(pkg string) api.GuessReason {
+	return api.GuessReason{
+		Package:    normalizePackageName(api.PkgName(pkg)),
+		Source:     "pip-install",
+		Confidence: 1,
 	}
+}
 
-	if err := json.Unmarshal(outputB, &output); err != nil {
-		util.Die("pipreqs: %s", err)
+// modulePackage returns the reason for guessing the package that
+// provides a module, given the package from a pragma for the module
+// (or the empty string). The second return value is false if the
+// package is unknown.
+func modulePackage(modname string, pragmaPkg string) (api.GuessReason, bool) {
+	// If this module has a package pragma, use that
+	if pragmaPkg != "" {
+		return api.GuessReason{
+			Package:    normalizePackageName(api.PkgName(pragmaPkg)),
+			Module:     modname,
+			Source:     "pragma",
+			Confidence: 1,
+		}, true
 	}
 
-	availMods := map[string]bool{}
-
-	if knownPkgs, err := listSpecfile(); err == nil {
-		for pkgName := range knownPkgs {
-			mods, ok := pypiPackageToModules()[string(pkgName)]
-			if ok {
-				for _, mod := range strings.Split(mods, ",") {
-					availMods[mod] = true
-				}
-			}
-		}
+	// Otherwise, try and look it up in Pypi
+	pkg, ok := moduleToPypiPackage()[modname]
+	if !ok {
+		return api.GuessReason{}, false
 	}
 
+	confidence, alternatives := api.DownloadShare(
+		pkg, moduleToPypiCandidates()[modname], pypiPackageToDownloads(),
+	)
+
+	return api.GuessReason{
+		Package:      normalizePackageName(api.PkgName(pkg)),
+		Module:       modname,
+		Source:       "pypi-map",
+		Confidence:   confidence,
+		Alternatives: alternatives,
+	}, true
+}
+
+// guessFromImports implements GuessFromImports for Python.
+func guessFromImports(imports []string) map[api.PkgName]bool {
+	installed := installedPackages(imports)
+	availMods := availableModules(installed)
 	pkgs := map[api.PkgName]bool{}
 
-	for modname, pragmas := range output.Imports {
+	for _, pkg := range installed {
+		pkgs[installedPackage(pkg).Package] = true
+	}
+
+	for modname, pragmaPkg := range externalImports(imports) {
 		// provided by an existing package or perhaps by the system
 		if availMods[modname] {
 			continue
 		}
 
-		// If this module has a package pragma, use that
-		if pragmas.Package != "" {
-			name := api.PkgName(pragmas.Package)
-			pkgs[normalizePackageName(name)] = true
-
-		} else {
-			// Otherwise, try and look it up in Pypi
-			pkg, ok := moduleToPypiPackage()[modname]
-			if ok {
-				name := api.PkgName(pkg)
-				pkgs[normalizePackageName(name)] = true
-			}
+		if reason, ok := modulePackage(modname, pragmaPkg); ok {
+			pkgs[reason.Package] = true
+		}
+	}
+
+	return pkgs
+}
+
+// guess implements Guess for Python.
+func guess() (map[api.PkgName]bool, bool) {
+	imports := []string{}
+	for _, fileImports := range guessFileImports(util.ListFilesRecursive(pythonPatterns)) {
+		imports = append(imports, fileImports...)
+	}
+	return guessFromImports(imports), true
+}
+
+// guessExplain implements GuessExplain for Python. There is a reason
+// for each file that imports a module, at the first import of the
+// module in the file, unless the module has a pragma, in which case
+// there is a reason for each file with the pragma. There is also a
+// reason for each notebook that installs a package.
+func guessExplain() []api.GuessReason {
+	importsByFile := map[string][]pyImport{}
+	files := util.ListFilesRecursive(pythonPatterns)
+	imports := []string{}
+	for _, file := range files {
+		importsByFile[file] = fileImports(file)
+		for _, imp := range importsByFile[file] {
+			imports = append(imports, imp.String())
 		}
 	}
 
-	return pkgs, output.Success
+	// A pragma in any file applies to every import of the
+	// module, as for guessFromImports.
+	external := externalImports(imports)
+	availMods := availableModules(installedPackages(imports))
+
+	reasons := []api.GuessReason{}
+	modImports := []api.ModuleImport{}
+	for _, file := range files {
+		for _, imp := range importsByFile[file] {
+			if imp.module == "" {
+				reason := installedPackage(imp.pkg)
+				reason.File = file
+				reason.Line = imp.line
+				reasons = append(reasons, reason)
+				continue
+			}
+			modImports = append(modImports, api.ModuleImport{
+				File:   file,
+				Line:   imp.line,
+				Module: imp.module,
+				Pragma: imp.pkg,
+			})
+		}
+	}
+	// Modules provided by an installed package, or perhaps by
+	// the system, are left out.
+	lookup := func(modname string, pragmaPkg string) (api.GuessReason, bool) {
+		if availMods[modname] {
+			return api.GuessReason{}, false
+		}
+		return modulePackage(modname, pragmaPkg)
+	}
+	return append(reasons, api.ExplainImports(modImports, external, lookup)...)
 }
 
 // getPython2 returns either "python2" or the value of the UPM_PYTHON2
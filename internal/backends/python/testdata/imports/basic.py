@@ -0,0 +1,13 @@
+import os
+import sys as system
+import numpy as np
+import xml.etree.ElementTree as ET
+from flask import Flask, request
+from django.conf import settings
+from __future__ import print_function
+from requests.adapters import (
+    HTTPAdapter,
+    Retry,
+)
+import matplotlib.pyplot \
+    as plt
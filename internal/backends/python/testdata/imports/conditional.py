@@ -0,0 +1,30 @@
+"""A module docstring that mentions
+import notapackage
+from alsonot import anything
+"""
+
+try:
+    import ujson as json
+except ImportError:
+    import simplejson as json
+
+if sys.version_info[0] < 3:
+    from urllib2 import urlopen
+else:
+    from urllib.request import urlopen
+
+
+def load():
+    import yaml
+    s = "import notastring"
+    t = 'from nope import nothing'
+    return yaml
+
+
+class Plot:
+    def draw(self):
+        from bokeh.plotting import figure  # import comment
+        return figure()
+
+
+if True: import toml
@@ -0,0 +1,31 @@
+import importlib
+
+# Modules imported by name at run time.
+redis = __import__("redis")
+yaml = importlib.import_module("ruamel.yaml")
+sibling = importlib.import_module(".sibling", "mypkg")
+name = "notapackage"
+plugin = importlib.import_module(name)
+fmt = __import__(f"{name}_fmt")
+
+try:
+    import lxml.etree as etree
+except ImportError:
+    try:
+        import xml.etree.cElementTree as etree
+    except ImportError:
+        etree = None
+finally:
+    import logging
+
+with open("data.csv") as f:
+    import csv
+
+while etree is None:
+    from tqdm import tqdm
+    break
+
+if __name__ == "__main__":
+    from rich import print
+elif False:
+    import colorama
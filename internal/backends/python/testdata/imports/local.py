@@ -0,0 +1,6 @@
+import mypkg
+from mypkg.util import helper
+from basic import np
+from . import sibling
+from .helpers import thing
+import helpers
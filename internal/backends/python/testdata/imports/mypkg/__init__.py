@@ -0,0 +1,2 @@
+from mypkg.util import helper
+import boto3
@@ -0,0 +1,5 @@
+import pandas
+
+
+def helper():
+    return pandas.DataFrame()
@@ -0,0 +1,4 @@
+import cv2  #upm package(opencv-python)
+from PIL import Image #upm package(Pillow)
+from sklearn import svm  # classifiers #upm package(scikit-learn)
+import dotenv
@@ -0,0 +1,394 @@
+package rlang
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// rPatterns is the FilenamePatterns value for RlangBackend.
+var rPatterns = []string{"*.r", "*.R", "*.Rmd", "*.rmd"}
+
+// rGuessRegexps is the GuessRegexps value for RlangBackend. Between
+// them, they match everything that findPackageUses looks at.
+var rGuessRegexps = util.Regexps([]string{
+	`\b(?:library|require|requireNamespace|loadNamespace)\s*\(\s*['"]?([a-zA-Z][\w.]*)`,
+	"`?([a-zA-Z][\\w.]*)`?\\s*:::?",
+})
+
+// rBasePackages are the base and recommended packages, which come with
+// R.
+var rBasePackages = map[string]bool{
+	"base":      true,
+	"compiler":  true,
+	"datasets":  true,
+	"grDevices": true,
+	"graphics":  true,
+	"grid":      true,
+	"methods":   true,
+	"parallel":  true,
+	"splines":   true,
+	"stats":     true,
+	"stats4":    true,
+	"tcltk":     true,
+	"tools":     true,
+	"utils":     true,
+
+	"KernSmooth": true,
+	"MASS":       true,
+	"Matrix":     true,
+	"boot":       true,
+	"class":      true,
+	"cluster":    true,
+	"codetools":  true,
+	"foreign":    true,
+	"lattice":    true,
+	"mgcv":       true,
+	"nlme":       true,
+	"nnet":       true,
+	"rpart":      true,
+	"spatial":    true,
+	"survival":   true,
+}
+
+// rLoadFunctions are the functions which load a package given as
+// their first argument. Those which are true take the package name
+// unquoted, unless character.only is set.
+var rLoadFunctions = map[string]bool{
+	"library":          true,
+	"require":          true,
+	"requireNamespace": false,
+	"loadNamespace":    false,
+}
+
+// rPackageNameRegexp matches a valid R package name.
+var rPackageNameRegexp = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9.]*[a-zA-Z0-9]$`)
+
+// rmdChunkStartRegexp matches the start of an R code chunk in R
+// Markdown, e.g. "```{r setup, include=FALSE}".
+var rmdChunkStartRegexp = regexp.MustCompile("^\\s*```+\\s*\\{\\s*[rR]\\s*[,}\\s]")
+
+// rmdChunkEndRegexp matches the end of a code chunk in R Markdown.
+var rmdChunkEndRegexp = regexp.MustCompile("^\\s*```+\\s*$")
+
+// rTokenKind is the kind of an rToken.
+type rTokenKind int
+
+const (
+	rIdent rTokenKind = iota
+	rString
+	rOther
+)
+
+// rToken is a token of R source code, as returned by rTokenize.
+type rToken struct {
+	text string
+	kind rTokenKind
+	line int
+}
+
+// isRNameByte returns true if c can be part of an R name, which may
+// contain dots, as in data.table, or of a number, which the lexer
+// reads the same way.
+func isRNameByte(c byte) bool {
+	return c == '.' || util.IsIdentByte(c)
+}
+
+// rRawStringRegexp matches the start of a raw string, e.g. r"(" or
+// R'---[', capturing the quote, the dashes and the opening bracket.
+var rRawStringRegexp = regexp.MustCompile(`^[rR](["'])(-*)([(\[{])`)
+
+// rTokenize splits R source code into tokens, leaving out whitespace
+// and comments. The contents of string literals are returned without
+// the quotes, and escape sequences are left as they are, which doesn't
+// matter for package names. Backquoted names are returned as names.
+func rTokenize(src string) []rToken {
+	closing := map[byte]byte{'(': ')', '[': ']', '{': '}'}
+	tokens := []rToken{}
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f':
+			i++
+
+		case c == '#':
+			end := strings.IndexByte(src[i:], '\n')
+			if end == -1 {
+				end = len(src) - i
+			}
+			i += end
+
+		case rRawStringRegexp.MatchString(src[i:]) && (i == 0 || !isRNameByte(src[i-1])):
+			m := rRawStringRegexp.FindStringSubmatch(src[i:])
+			start := i + len(m[0])
+			terminator := string(closing[m[3][0]]) + m[2] + m[1]
+			end := strings.Index(src[start:], terminator)
+			if end == -1 {
+				end = len(src) - start
+			}
+			tokens = append(tokens, rToken{text: src[start : start+end], kind: rString, line: line})
+			line += strings.Count(src[i:start+end], "\n")
+			i = start + end + len(terminator)
+
+		case c == '"' || c == '\'' || c == '`':
+			j := i + 1
+			for j < len(src) && src[j] != c {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j > len(src) {
+				j = len(src)
+			}
+			kind := rString
+			if c == '`' {
+				kind = rIdent
+			}
+			tokens = append(tokens, rToken{text: src[i+1 : j], kind: kind, line: line})
+			line += strings.Count(src[i:j], "\n")
+			i = j + 1
+
+		case isRNameByte(c):
+			start := i
+			for i < len(src) && isRNameByte(src[i]) {
+				i++
+			}
+			word := src[start:i]
+			kind := rIdent
+			if '0' <= word[0] && word[0] <= '9' ||
+				word[0] == '.' && len(word) > 1 && '0' <= word[1] && word[1] <= '9' {
+				kind = rOther
+			}
+			tokens = append(tokens, rToken{text: word, kind: kind, line: line})
+
+		default:
+			op := src[i : i+1]
+			for _, long := range []string{":::", "::", "=="} {
+				if strings.HasPrefix(src[i:], long) {
+					op = long
+					break
+				}
+			}
+			tokens = append(tokens, rToken{text: op, kind: rOther, line: line})
+			i += len(op)
+		}
+	}
+	return tokens
+}
+
+// rmdCode returns the R code in the code chunks of R Markdown, with
+// everything else blanked out so that line numbers are unchanged.
+func rmdCode(src string) string {
+	lines := strings.Split(src, "\n")
+	inChunk := false
+	for i, line := range lines {
+		if inChunk && rmdChunkEndRegexp.MatchString(line) {
+			inChunk = false
+			lines[i] = ""
+		} else if !inChunk && rmdChunkStartRegexp.MatchString(line+"\n") {
+			inChunk = true
+			lines[i] = ""
+		} else if !inChunk {
+			lines[i] = ""
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// rPackageUse is a use of a package found by findPackageUses.
+type rPackageUse struct {
+	// The package name, e.g. "dplyr".
+	pkg string
+
+	// How it is used: the name of the loading function, or the
+	// namespace operator.
+	via string
+
+	// The 1-based line of the use.
+	line int
+}
+
+// rCallArgs returns the arguments of the call whose opening
+// parenthesis is at tokens[i], and the index just after the closing
+// parenthesis.
+func rCallArgs(tokens []rToken, i int) ([][]rToken, int) {
+	args := [][]rToken{}
+	arg := []rToken{}
+	depth := 0
+	for ; i < len(tokens); i++ {
+		tok := tokens[i]
+		if tok.kind == rOther {
+			switch tok.text {
+			case "(", "[", "{":
+				depth++
+				if depth == 1 {
+					continue
+				}
+			case ")", "]", "}":
+				depth--
+				if depth == 0 {
+					return append(args, arg), i + 1
+				}
+			case ",":
+				if depth == 1 {
+					args = append(args, arg)
+					arg = []rToken{}
+					continue
+				}
+			}
+		}
+		arg = append(arg, tok)
+	}
+	return append(args, arg), i
+}
+
+// rLoadedPackage returns the package loaded by a call to one of the
+// rLoadFunctions with the given arguments, or the empty string if it
+// can't be worked out without running the code.
+func rLoadedPackage(function string, args [][]rToken) string {
+	var pkgArg []rToken
+	positional := 0
+	characterOnly := false
+	for _, arg := range args {
+		if len(arg) >= 2 && arg[0].kind != rOther && arg[1].text == "=" {
+			switch arg[0].text {
+			case "package":
+				pkgArg = arg[2:]
+			case "character.only":
+				characterOnly = len(arg) == 3 && (arg[2].text == "TRUE" || arg[2].text == "T")
+			}
+			continue
+		}
+		if positional == 0 && pkgArg == nil {
+			pkgArg = arg
+		}
+		positional++
+	}
+
+	if len(pkgArg) != 1 {
+		return ""
+	}
+	switch {
+	case pkgArg[0].kind == rString:
+		return pkgArg[0].text
+	case pkgArg[0].kind == rIdent && rLoadFunctions[function] && !characterOnly:
+		return pkgArg[0].text
+	}
+	return ""
+}
+
+// findPackageUses returns the packages used by R source code, in the
+// order they appear: those loaded by library, require,
+// requireNamespace and loadNamespace, and those whose namespace is
+// used with :: or :::. Each package is returned once, at its first
+// use.
+func findPackageUses(src string) []rPackageUse {
+	tokens := rTokenize(src)
+	uses := []rPackageUse{}
+	seen := map[string]bool{}
+	add := func(pkg string, via string, line int) {
+		if seen[pkg] || !rPackageNameRegexp.MatchString(pkg) {
+			return
+		}
+		seen[pkg] = true
+		uses = append(uses, rPackageUse{pkg: pkg, via: via, line: line})
+	}
+
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		if tok.kind != rIdent || i+1 == len(tokens) {
+			continue
+		}
+		next := tokens[i+1]
+		// Don't mistake x$library(...) or obj@pkg for a call
+		// or a namespace.
+		if i > 0 && (tokens[i-1].text == "$" || tokens[i-1].text == "@") {
+			continue
+		}
+		switch {
+		case next.text == "::" || next.text == ":::":
+			add(tok.text, next.text, tok.line)
+
+		case next.text == "(":
+			if _, ok := rLoadFunctions[tok.text]; !ok {
+				continue
+			}
+			args, _ := rCallArgs(tokens, i+1)
+			if pkg := rLoadedPackage(tok.text, args); pkg != "" {
+				add(pkg, tok.text, tok.line)
+			}
+		}
+	}
+	return uses
+}
+
+// rGuessExtraInput is the GuessExtraInput value for RlangBackend,
+// since uses of the project's own package are left out.
+func rGuessExtraInput() []byte {
+	return []byte(rOwnPackage())
+}
+
+// rOwnPackage returns the name of the project's own package from its
+// DESCRIPTION file, or the empty string if there is none.
+func rOwnPackage() string {
+	file, err := os.Open("DESCRIPTION")
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if name := strings.TrimPrefix(scanner.Text(), "Package:"); name != scanner.Text() {
+			return strings.TrimSpace(name)
+		}
+	}
+	return ""
+}
+
+// rGuessExplain implements GuessExplain for RlangBackend. There is a
+// reason for each file that uses a package, at the first use in the
+// file. Packages which come with R, and the project's own package, are
+// left out.
+func rGuessExplain() []api.GuessReason {
+	own := rOwnPackage()
+	reasons := []api.GuessReason{}
+	for _, file := range util.ListFilesRecursive(rPatterns) {
+		contentsB, err := ioutil.ReadFile(file)
+		if err != nil {
+			util.Die("%s: %s", file, err)
+		}
+		contents := string(contentsB)
+		if strings.HasSuffix(strings.ToLower(file), ".rmd") {
+			contents = rmdCode(contents)
+		}
+		for _, use := range findPackageUses(contents) {
+			if rBasePackages[use.pkg] || use.pkg == own {
+				continue
+			}
+			// library(), loadNamespace() and the like, and
+			// ::, take the name of the package itself
+			// rather than of something in it.
+			reasons = append(reasons, api.GuessReason{
+				Package:    api.PkgName(use.pkg),
+				File:       file,
+				Line:       use.line,
+				Module:     use.pkg,
+				Source:     use.via,
+				Confidence: 1,
+			})
+		}
+	}
+	return reasons
+}
@@ -0,0 +1,153 @@
+package rlang
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/replit/upm/internal/api"
+)
+
+func TestFindPackageUses(t *testing.T) {
+	tcs := []struct {
+		scenario string
+		src      string
+		expected []rPackageUse
+	}{
+		{
+			scenario: "Returns packages loaded by library and friends",
+			src: `library(dplyr)
+require("ggplot2")
+suppressPackageStartupMessages(library(tidyr, quietly = TRUE))
+if (!requireNamespace('jsonlite', quietly = TRUE)) stop()
+loadNamespace("data.table")
+library(package = stringr)
+`,
+			expected: []rPackageUse{
+				{pkg: "dplyr", via: "library", line: 1},
+				{pkg: "ggplot2", via: "require", line: 2},
+				{pkg: "tidyr", via: "library", line: 3},
+				{pkg: "jsonlite", via: "requireNamespace", line: 4},
+				{pkg: "data.table", via: "loadNamespace", line: 5},
+				{pkg: "stringr", via: "library", line: 6},
+			},
+		},
+		{
+			scenario: "Returns packages used with :: and :::",
+			src: `x <- readr::read_csv("a.csv")
+y <- ` + "`purrr`" + `::map(x, f)
+z <- rlang:::abort_internal()
+w <- readr::read_tsv("b.tsv")
+`,
+			expected: []rPackageUse{
+				{pkg: "readr", via: "::", line: 1},
+				{pkg: "purrr", via: "::", line: 2},
+				{pkg: "rlang", via: ":::", line: 3},
+			},
+		},
+		{
+			scenario: "Skips computed package names",
+			src: `pkg <- "shiny"
+library(pkg, character.only = TRUE)
+requireNamespace(pkg)
+for (p in pkgs) library(p, character.only = TRUE)
+library("httr", character.only = TRUE)
+x$library(foo)
+`,
+			expected: []rPackageUse{
+				{pkg: "httr", via: "library", line: 5},
+			},
+		},
+		{
+			scenario: "Skips comments and strings",
+			src: `# library(commented)
+s <- "library(quoted); quoted::fn()"
+r <- r"(library(raw); raw::fn())"
+r2 <- R"--[library(raw2)]--"
+library(real)
+`,
+			expected: []rPackageUse{
+				{pkg: "real", via: "library", line: 5},
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.scenario, func(t *testing.T) {
+			uses := findPackageUses(tc.src)
+			if !reflect.DeepEqual(uses, tc.expected) {
+				t.Errorf("expected %+v, got %+v", tc.expected, uses)
+			}
+		})
+	}
+}
+
+func TestRmdCode(t *testing.T) {
+	src := "---\n" +
+		"title: library(notcode)\n" +
+		"---\n" +
+		"\n" +
+		"```{r setup, include=FALSE}\n" +
+		"library(knitr)\n" +
+		"```\n" +
+		"\n" +
+		"Some text about dplyr::filter.\n" +
+		"\n" +
+		"```{python}\n" +
+		"import pandas\n" +
+		"```\n" +
+		"\n" +
+		"```{r}\n" +
+		"ggplot2::qplot(x)\n" +
+		"```\n"
+
+	expected := []rPackageUse{
+		{pkg: "knitr", via: "library", line: 6},
+		{pkg: "ggplot2", via: "::", line: 16},
+	}
+	uses := findPackageUses(rmdCode(src))
+	if !reflect.DeepEqual(uses, expected) {
+		t.Errorf("expected %+v, got %+v", expected, uses)
+	}
+}
+
+func TestRGuessOwnPackage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "upm-rlang")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile("main.R", []byte("library(mypkg)\nlibrary(dplyr)\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if input := string(rGuessExtraInput()); input != "" {
+		t.Errorf("expected no extra input without DESCRIPTION, got %q", input)
+	}
+
+	desc := "Package: mypkg\nVersion: 0.1.0\n"
+	if err := ioutil.WriteFile("DESCRIPTION", []byte(desc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if input := string(rGuessExtraInput()); input != "mypkg" {
+		t.Errorf("expected extra input %q, got %q", "mypkg", input)
+	}
+	pkgs := []api.PkgName{}
+	for _, reason := range rGuessExplain() {
+		pkgs = append(pkgs, reason.Package)
+	}
+	expected := []api.PkgName{"dplyr"}
+	if !reflect.DeepEqual(pkgs, expected) {
+		t.Errorf("expected %v, got %v", expected, pkgs)
+	}
+}
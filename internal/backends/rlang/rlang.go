@@ -74,49 +74,44 @@ func normalizePkgName(name string) string {
 	return name
 }
 
+// hitToPkgInfo converts a package search result into the format
+// used by UPM.
+func hitToPkgInfo(hit CranHit) api.PkgInfo {
+	return api.PkgInfo{
+		Name:                hit.Source.Package,
+		Description:         hit.Source.Title,
+		Version:             hit.Source.Version,
+		HomepageURL:         hit.Source.URL,
+		DocumentationURL:    "",
+		SourceCodeURL:       hit.Source.Repository,
+		BugTrackerURL:       hit.Source.BugReports,
+		Author:              hit.Source.Author,
+		License:             hit.Source.License,
+		Dependencies:        getImports(hit.Source.Imports),
+		PublishedAt:         hit.Source.PublishedAt(),
+		ReverseDependencies: hit.Source.RevDeps,
+	}
+}
+
 // RlangBackend is a custom UPM backend for R
 var RlangBackend = api.LanguageBackend{
 	Name:             "rlang",
 	Specfile:         "Rconfig.json",
 	Lockfile:         "Rconfig.lock.json",
-	FilenamePatterns: []string{"*.r", "*.R"},
+	FilenamePatterns: rPatterns,
+	Executables:      []string{"R"},
 	Quirks:           api.QuirksNone,
 	GetPackageDir:    getRPkgDir,
 	Search: func(query string) []api.PkgInfo {
 		pkgs := []api.PkgInfo{}
 		for _, hit := range SearchPackages(query) {
-			pkg := api.PkgInfo{
-				Name:             hit.Source.Package,
-				Description:      hit.Source.Title,
-				Version:          hit.Source.Version,
-				HomepageURL:      hit.Source.URL,
-				DocumentationURL: "",
-				SourceCodeURL:    hit.Source.Repository,
-				BugTrackerURL:    hit.Source.BugReports,
-				Author:           hit.Source.Author,
-				License:          hit.Source.License,
-				Dependencies:     getImports(hit.Source.Imports),
-			}
-
-			pkgs = append(pkgs, pkg)
+			pkgs = append(pkgs, hitToPkgInfo(hit))
 		}
 		return pkgs
 	},
 	Info: func(name api.PkgName) api.PkgInfo {
 		if pkg := SearchPackage(string(name)); pkg != nil {
-			hit := *pkg
-			return api.PkgInfo{
-				Name:             hit.Source.Package,
-				Description:      hit.Source.Title,
-				Version:          hit.Source.Version,
-				HomepageURL:      hit.Source.URL,
-				DocumentationURL: "",
-				SourceCodeURL:    hit.Source.Repository,
-				BugTrackerURL:    hit.Source.BugReports,
-				Author:           hit.Source.Author,
-				License:          hit.Source.License,
-				Dependencies:     getImports(hit.Source.Imports),
-			}
+			return hitToPkgInfo(*pkg)
 		}
 
 		return api.PkgInfo{}
@@ -166,10 +161,7 @@ var RlangBackend = api.LanguageBackend{
 		}
 		return pkgs
 	},
-	//GuessRegexps: []*regexp.Regexp {regexp.MustCompile(`\brequire[ \t]*\(\s*([a-zA-Z_]\w*)\s*`)},
-	Guess: func() (map[api.PkgName]bool, bool) {
-		util.NotImplemented()
-
-		return nil, false
-	},
+	GuessRegexps:    rGuessRegexps,
+	GuessExtraInput: rGuessExtraInput,
+	GuessExplain:    rGuessExplain,
 }
@@ -7,6 +7,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // CranHitSource represents the JSON we get about the information for a single package from a package search
@@ -39,6 +40,25 @@ type CranHitSource struct {
 	Repository       string `json:"Repository"`
 }
 
+// cranTimeLayouts are the formats in which CRAN packages are seen to
+// give their Date/Publication field.
+var cranTimeLayouts = []string{
+	"2006-01-02 15:04:05 MST",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// PublishedAt parses the Date/Publication field, returning nil if it
+// is missing or malformed.
+func (source CranHitSource) PublishedAt() *time.Time {
+	for _, layout := range cranTimeLayouts {
+		if t, err := time.Parse(layout, source.DatePublished); err == nil {
+			return &t
+		}
+	}
+	return nil
+}
+
 // CranHit represents the JSON we get about a single package from a package search
 type CranHit struct {
 	Index  string        `json:"_index"`
@@ -0,0 +1,235 @@
+// This command generates go source holding a mapping of:
+// require paths -> most likely gem
+// require paths -> other gems providing them
+// and
+// gems -> downloads
+//
+// these are provided as the maps requireToGem, requireToGemCandidates and
+// gemToDownloads respectively.
+//
+// The input is a RubyGems file index dump with one JSON object per line,
+// e.g. {"g":"sinatra","f":["lib/sinatra.rb","lib/sinatra/base.rb"],"d":1000}
+// giving the gem name, the files in its latest version, and its downloads.
+// If the gem's require paths aren't just "lib", they are given as "r".
+package main
+
+import (
+	"strings"
+
+	"github.com/replit/upm/internal/genmap"
+)
+
+type indexEntry struct {
+	Gem          string   `json:"g"`
+	Files        []string `json:"f"`
+	RequirePaths []string `json:"r"`
+	Downloads    int      `json:"d"`
+}
+
+// requires returns the paths that can be passed to require to load the
+// Ruby files of the gem, e.g. "sinatra/base" for "lib/sinatra/base.rb".
+func (e *indexEntry) requires() []string {
+	dirs := e.RequirePaths
+	if len(dirs) == 0 {
+		dirs = []string{"lib"}
+	}
+
+	reqs := []string{}
+	for _, file := range e.Files {
+		if !strings.HasSuffix(file, ".rb") {
+			continue
+		}
+		for _, dir := range dirs {
+			if strings.HasPrefix(file, dir+"/") {
+				req := strings.TrimSuffix(strings.TrimPrefix(file, dir+"/"), ".rb")
+				reqs = append(reqs, req)
+				break
+			}
+		}
+	}
+	return reqs
+}
+
+// stdlibRequires are the libraries that come with Ruby without being
+// gems, from https://docs.ruby-lang.org/en/master/standard_library_rdoc.html,
+// as we never want to guess that one of them is provided by a remote gem.
+var stdlibRequires = map[string]bool{
+	"English":      true,
+	"continuation": true,
+	"coverage":     true,
+	"enumerator":   true,
+	"etc":          true,
+	"fiber":        true,
+	"io/console":   true,
+	"io/nonblock":  true,
+	"io/wait":      true,
+	"mkmf":         true,
+	"monitor":      true,
+	"nkf":          true,
+	"objspace":     true,
+	"pty":          true,
+	"rbconfig":     true,
+	"ripper":       true,
+	"rubygems":     true,
+	"socket":       true,
+	"thread":       true,
+	"expect":       true,
+	"win32ole":     true,
+}
+
+// defaultGems are the gems that come with Ruby and can be required
+// without being in the Gemfile, from
+// https://stdgems.org/ (bundled gems, like minitest, are not included
+// because Bundler only loads them if they are in the Gemfile).
+var defaultGems = map[string]bool{
+	"base64":          true,
+	"benchmark":       true,
+	"bigdecimal":      true,
+	"bundler":         true,
+	"cgi":             true,
+	"csv":             true,
+	"date":            true,
+	"delegate":        true,
+	"did_you_mean":    true,
+	"digest":          true,
+	"drb":             true,
+	"english":         true,
+	"erb":             true,
+	"error_highlight": true,
+	"etc":             true,
+	"fcntl":           true,
+	"fiddle":          true,
+	"fileutils":       true,
+	"find":            true,
+	"forwardable":     true,
+	"getoptlong":      true,
+	"io-console":      true,
+	"io-nonblock":     true,
+	"io-wait":         true,
+	"ipaddr":          true,
+	"irb":             true,
+	"json":            true,
+	"logger":          true,
+	"mutex_m":         true,
+	"net-http":        true,
+	"net-protocol":    true,
+	"observer":        true,
+	"open-uri":        true,
+	"open3":           true,
+	"openssl":         true,
+	"optparse":        true,
+	"ostruct":         true,
+	"pathname":        true,
+	"pp":              true,
+	"prettyprint":     true,
+	"pstore":          true,
+	"psych":           true,
+	"racc":            true,
+	"rdoc":            true,
+	"readline":        true,
+	"readline-ext":    true,
+	"reline":          true,
+	"resolv":          true,
+	"resolv-replace":  true,
+	"rinda":           true,
+	"ruby2_keywords":  true,
+	"securerandom":    true,
+	"set":             true,
+	"shellwords":      true,
+	"singleton":       true,
+	"stringio":        true,
+	"strscan":         true,
+	"syntax_suggest":  true,
+	"syslog":          true,
+	"tempfile":        true,
+	"time":            true,
+	"timeout":         true,
+	"tmpdir":          true,
+	"tsort":           true,
+	"un":              true,
+	"uri":             true,
+	"weakref":         true,
+	"win32ole":        true,
+	"yaml":            true,
+	"zlib":            true,
+}
+
+// exactMatch returns true if the gem is named after the require path,
+// following the RubyGems naming conventions, e.g. "net-ssh" for
+// "net/ssh" or "activesupport" for "active_support".
+func exactMatch(gem string, req string) bool {
+	gem = strings.ToLower(gem)
+	req = strings.ToLower(req)
+	if gem == req || gem == strings.Replace(req, "/", "-", -1) {
+		return true
+	}
+	return strings.Replace(gem, "_", "", -1) == strings.Replace(req, "_", "", -1)
+}
+
+func main() {
+	from, pkg, out := genmap.Flags()
+
+	entries := []*indexEntry{}
+	genmap.ReadIndex(from, func() interface{} {
+		e := &indexEntry{}
+		entries = append(entries, e)
+		return e
+	})
+
+	// The paths that default gems provide are loaded from Ruby
+	// itself, even if other gems provide them too, e.g.
+	// json_pure has lib/json.rb, so they are excluded from every
+	// gem, not just from the default gems.
+	defaultRequires := map[string]bool{}
+	for _, e := range entries {
+		if defaultGems[e.Gem] {
+			for _, req := range e.requires() {
+				defaultRequires[req] = true
+			}
+		}
+	}
+
+	reqs := genmap.Candidates{}
+	for _, e := range entries {
+		if defaultGems[e.Gem] {
+			continue
+		}
+
+		gem := &genmap.Package{Name: e.Gem, Count: e.Downloads}
+		for _, req := range e.requires() {
+			if stdlibRequires[req] || defaultRequires[req] {
+				continue
+			}
+			reqs.Add(req, gem)
+		}
+	}
+	sortedReqs := reqs.Rank()
+
+	w := genmap.NewWriter(pkg)
+
+	chosen := w.ChosenMap("requireToGem", `
+requireToGem holds a map of all known require paths to their corresponding
+best matching gem. This helps us guess which gems should be installed for
+the given requires.
+`, reqs, sortedReqs, genmap.Rules{
+		Match:        exactMatch,
+		MatchComment: "exact match",
+		MinCount:     100,
+		Noun:         "gem",
+		CountNoun:    "download",
+		CountAbbr:    "dls",
+	})
+
+	downloads := w.CandidatesMap("requireToGemCandidates", `
+requireToGemCandidates holds a map of every require path in requireToGem
+which is provided by more than one gem to the other gems which provide it,
+most downloaded first. This is used to work out how confident a guess is.
+`, reqs, sortedReqs, chosen)
+
+	w.CountMap("gemToDownloads", `
+gemToDownloads holds a map of every gem in requireToGemCandidates, and of
+the gems chosen over them, to the number of times it has been downloaded.
+`, downloads)
+
+	w.WriteFile(out)
+}
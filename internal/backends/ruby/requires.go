@@ -0,0 +1,323 @@
+package ruby
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// rubyPatterns is the FilenamePatterns value for RubyBackend.
+var rubyPatterns = []string{"*.rb"}
+
+// pragmaPackageRegexp matches a pragma in a comment on a require which
+// says which gem provides it, e.g. "# upm package(activesupport)".
+var pragmaPackageRegexp = regexp.MustCompile(`#\s*upm package\(([^)]*)\)`)
+
+// heredocRegexp matches the start of a heredoc, capturing the
+// terminator. A bare "<<" must be followed by an uppercase
+// identifier, so as not to be confused with a shift or append.
+var heredocRegexp = regexp.MustCompile(`^<<(?:[-~](['"]?)([A-Za-z_]\w*)|(['"]?)([A-Z_][A-Z0-9_]*))`)
+
+// rubyRequire is a require found by findRequires.
+type rubyRequire struct {
+	// The required path, e.g. "sinatra/base".
+	path string
+
+	// The gem given by a "# upm package(...)" pragma on the
+	// require, or the empty string.
+	gem string
+
+	// The line of the first require of the path.
+	// This isn't included in the String form.
+	line int
+}
+
+// String returns the form of the require returned from
+// GuessFileImports: the path, followed by "=" and the gem if there is
+// a pragma.
+func (req rubyRequire) String() string {
+	if req.gem == "" {
+		return req.path
+	}
+	return req.path + "=" + req.gem
+}
+
+// parseRubyRequire is the inverse of rubyRequire.String.
+func parseRubyRequire(str string) rubyRequire {
+	parts := strings.SplitN(str, "=", 2)
+	if len(parts) == 2 {
+		return rubyRequire{path: parts[0], gem: parts[1]}
+	}
+	return rubyRequire{path: str}
+}
+
+// requireArgument parses the argument of a require call at the start
+// of code, which must be a plain string literal, optionally in
+// parentheses. It returns the path and the length of the code up to
+// the end of the literal, or -1 if the argument is something else.
+func requireArgument(code string) (string, int) {
+	i := 0
+	skipSpace := func() {
+		for i < len(code) && (code[i] == ' ' || code[i] == '\t') {
+			i++
+		}
+	}
+	skipSpace()
+	if i < len(code) && code[i] == '(' {
+		i++
+		skipSpace()
+	}
+	if i == len(code) || (code[i] != '\'' && code[i] != '"') {
+		return "", -1
+	}
+	quote := code[i]
+	end := strings.IndexByte(code[i+1:], quote)
+	if end == -1 {
+		return "", -1
+	}
+	path := code[i+1 : i+1+end]
+	if path == "" || strings.ContainsAny(path, `\`) || strings.Contains(path, "#{") {
+		return "", -1
+	}
+	return path, i + 1 + end + 1
+}
+
+// findRequires returns the requires in Ruby source code, in the order
+// they appear. Each path is returned once. If a path is required more
+// than once, the last pragma for it wins. Requires inside strings,
+// comments, heredocs and =begin/=end blocks are ignored, as are
+// requires of computed paths.
+func findRequires(src string) []rubyRequire {
+	requires := []rubyRequire{}
+	index := map[string]int{}
+
+	// The quote character of a string literal that continues
+	// onto the next line, if any.
+	var inString byte
+	// The terminators of heredocs that start on the current
+	// line, whose bodies begin on the next.
+	heredocs := []string{}
+	inComment := false
+
+	for lineNum, line := range strings.Split(src, "\n") {
+		lineNum++
+
+		if len(heredocs) > 0 {
+			if strings.TrimSpace(line) == heredocs[0] {
+				heredocs = heredocs[1:]
+			}
+			continue
+		}
+		if inComment {
+			if strings.HasPrefix(line, "=end") {
+				inComment = false
+			}
+			continue
+		}
+		if inString == 0 && strings.HasPrefix(line, "=begin") {
+			inComment = true
+			continue
+		}
+
+		paths := []string{}
+		comment := ""
+		for i := 0; i < len(line); {
+			c := line[i]
+			switch {
+			case inString != 0:
+				if c == '\\' {
+					i++
+				} else if c == inString {
+					inString = 0
+				}
+				i++
+
+			case c == '#':
+				comment = line[i:]
+				i = len(line)
+
+			case c == '\'' || c == '"' || c == '`':
+				inString = c
+				i++
+
+			case c == '<' && heredocRegexp.MatchString(line[i:]):
+				m := heredocRegexp.FindStringSubmatch(line[i:])
+				heredocs = append(heredocs, m[2]+m[4])
+				i += len(m[0])
+
+			case util.IsIdentByte(c):
+				start := i
+				for i < len(line) && util.IsIdentByte(line[i]) {
+					i++
+				}
+				// A method call on some other object
+				// isn't Kernel#require.
+				if line[start:i] != "require" || (start > 0 && (line[start-1] == '.' || line[start-1] == ':')) {
+					continue
+				}
+				if i < len(line) && (line[i] == '?' || line[i] == '!' || line[i] == '=') {
+					continue
+				}
+				if path, n := requireArgument(line[i:]); n != -1 {
+					paths = append(paths, path)
+					i += n
+				}
+
+			default:
+				i++
+			}
+		}
+
+		gem := ""
+		if m := pragmaPackageRegexp.FindStringSubmatch(comment); m != nil {
+			gem = strings.TrimSpace(m[1])
+		}
+
+		for _, path := range paths {
+			if i, ok := index[path]; ok {
+				requires[i].gem = gem
+				continue
+			}
+			index[path] = len(requires)
+			requires = append(requires, rubyRequire{path: path, gem: gem, line: lineNum})
+		}
+	}
+	return requires
+}
+
+// rubyGuessFileImports implements GuessFileImports for RubyBackend.
+func rubyGuessFileImports(files []string) map[string][]string {
+	results := map[string][]string{}
+	for _, file := range files {
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			util.Die("%s: %s", file, err)
+		}
+		requires := []string{}
+		for _, req := range findRequires(string(contents)) {
+			requires = append(requires, req.String())
+		}
+		results[file] = requires
+	}
+	return results
+}
+
+// localRequires returns the paths which may be required from the
+// project itself, namely every trailing part of the path of each Ruby
+// file (without extension), e.g. "lib/app/models", "app/models" and
+// "models" for "lib/app/models.rb", since any directory might be on
+// the load path.
+func localRequires() map[string]bool {
+	paths := map[string]bool{}
+	for _, file := range util.ListFilesRecursive(rubyPatterns) {
+		parts := strings.Split(strings.TrimSuffix(filepath.ToSlash(file), ".rb"), "/")
+		for i := range parts {
+			paths[strings.Join(parts[i:], "/")] = true
+		}
+	}
+	return paths
+}
+
+// externalRequires combines the requires (as returned by
+// GuessFileImports) of the files of the project, and returns a map
+// from each path which isn't provided by the project itself to the
+// gem given for it by a pragma, if any. If there is a pragma for a
+// path in any file, it is used.
+func externalRequires(imports []string) map[string]string {
+	local := localRequires()
+	paths := map[string]string{}
+	for _, str := range imports {
+		req := parseRubyRequire(str)
+		if local[req.path] || strings.HasPrefix(req.path, ".") || strings.HasPrefix(req.path, "/") {
+			continue
+		}
+		if gem, ok := paths[req.path]; !ok || gem == "" {
+			paths[req.path] = req.gem
+		}
+	}
+	return paths
+}
+
+// requireGem returns the reason for guessing the gem that provides a
+// require path, given the gem from a pragma for the path (or the
+// empty string). The second return value is false if the gem is
+// unknown.
+func requireGem(path string, pragmaGem string) (api.GuessReason, bool) {
+	// If this require has a package pragma, use that
+	if pragmaGem != "" {
+		return api.GuessReason{
+			Package:    api.PkgName(pragmaGem),
+			Module:     path,
+			Source:     "pragma",
+			Confidence: 1,
+		}, true
+	}
+
+	// Otherwise, try and look it up in the map generated from
+	// the RubyGems index
+	gem, ok := requireToGem()[path]
+	if !ok {
+		return api.GuessReason{}, false
+	}
+
+	confidence, alternatives := api.DownloadShare(
+		gem, requireToGemCandidates()[path], gemToDownloads(),
+	)
+
+	return api.GuessReason{
+		Package:      api.PkgName(gem),
+		Module:       path,
+		Source:       "gem-map",
+		Confidence:   confidence,
+		Alternatives: alternatives,
+	}, true
+}
+
+// rubyGuessFromImports implements GuessFromImports for RubyBackend.
+func rubyGuessFromImports(imports []string) map[api.PkgName]bool {
+	pkgs := map[api.PkgName]bool{}
+	for path, pragmaGem := range externalRequires(imports) {
+		if reason, ok := requireGem(path, pragmaGem); ok {
+			pkgs[reason.Package] = true
+		}
+	}
+	return pkgs
+}
+
+// rubyGuess implements Guess for RubyBackend.
+func rubyGuess() (map[api.PkgName]bool, bool) {
+	imports := []string{}
+	for _, fileImports := range rubyGuessFileImports(util.ListFilesRecursive(rubyPatterns)) {
+		imports = append(imports, fileImports...)
+	}
+	return rubyGuessFromImports(imports), true
+}
+
+// rubyGuessExplain implements GuessExplain for RubyBackend. There is a
+// reason for each file that requires a path, at the first require of
+// the path in the file, unless the path has a pragma, in which case
+// there is a reason for each file with the pragma.
+func rubyGuessExplain() []api.GuessReason {
+	imports := []string{}
+	requires := []api.ModuleImport{}
+	for _, file := range util.ListFilesRecursive(rubyPatterns) {
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			util.Die("%s: %s", file, err)
+		}
+		for _, req := range findRequires(string(contents)) {
+			imports = append(imports, req.String())
+			requires = append(requires, api.ModuleImport{
+				File:   file,
+				Line:   req.line,
+				Module: req.path,
+				Pragma: req.gem,
+			})
+		}
+	}
+	return api.ExplainImports(requires, externalRequires(imports), requireGem)
+}
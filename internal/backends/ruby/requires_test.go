@@ -0,0 +1,163 @@
+package ruby
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/replit/upm/internal/api"
+)
+
+func TestFindRequires(t *testing.T) {
+	tcs := []struct {
+		scenario string
+		src      string
+		expected []rubyRequire
+	}{
+		{
+			scenario: "Returns required paths with either quote and parentheses",
+			src:      "require 'sinatra'\nrequire \"sinatra/base\"\nrequire('stripe')\nrequire ( \"pry\" )",
+			expected: []rubyRequire{
+				{path: "sinatra", line: 1},
+				{path: "sinatra/base", line: 2},
+				{path: "stripe", line: 3},
+				{path: "pry", line: 4},
+			},
+		},
+		{
+			scenario: "Finds requires after other statements and modifiers",
+			src:      "x = 1; require 'a'\nrequire 'b' if ENV['B']\nbegin\n  require 'c'\nrescue LoadError\nend",
+			expected: []rubyRequire{
+				{path: "a", line: 1},
+				{path: "b", line: 2},
+				{path: "c", line: 4},
+			},
+		},
+		{
+			scenario: "Ignores require_relative, computed paths and other methods",
+			src:      "require_relative 'a'\nrequire \"#{dir}/b\"\nrequire name\nfoo.require 'c'\nrequire? 'd'",
+			expected: []rubyRequire{},
+		},
+		{
+			scenario: "Ignores requires in strings, comments and heredocs",
+			src:      "s = \"require 'a'\"\n# require 'b'\n=begin\nrequire 'c'\n=end\nt = <<~EOS\n  require 'd'\nEOS\nu = 'multi\nrequire \"e\"'\nrequire 'f'",
+			expected: []rubyRequire{{path: "f", line: 11}},
+		},
+		{
+			scenario: "Reads package pragmas",
+			src:      "require 'active_support/all' # upm package(activesupport)\nrequire 'colorize' #upm package(colorize)",
+			expected: []rubyRequire{
+				{path: "active_support/all", gem: "activesupport", line: 1},
+				{path: "colorize", gem: "colorize", line: 2},
+			},
+		},
+		{
+			scenario: "Uses the last pragma for a path required twice",
+			src:      "require 'a' # upm package(b)\nrequire 'a' # upm package(c)",
+			expected: []rubyRequire{{path: "a", gem: "c", line: 1}},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.scenario, func(t *testing.T) {
+			requires := findRequires(tc.src)
+			if !reflect.DeepEqual(requires, tc.expected) {
+				t.Errorf("expected %+v, got %+v", tc.expected, requires)
+			}
+		})
+	}
+}
+
+func TestExternalRequires(t *testing.T) {
+	dir, err := ioutil.TempDir("", "upm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "lib", "app"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"main.rb", "lib/app/models.rb"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, file), []byte{}, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+
+	imports := []string{
+		"sinatra",
+		"app/models",
+		"models",
+		"./helpers",
+		"/etc/config",
+		"colorize",
+		"colorize=colorized-fork",
+		"active_support/all=activesupport",
+	}
+	expected := map[string]string{
+		"sinatra":            "",
+		"colorize":           "colorized-fork",
+		"active_support/all": "activesupport",
+	}
+	if paths := externalRequires(imports); !reflect.DeepEqual(paths, expected) {
+		t.Errorf("expected %v, got %v", expected, paths)
+	}
+}
+
+func TestRequireGem(t *testing.T) {
+	tcs := []struct {
+		scenario  string
+		path      string
+		pragmaGem string
+		expected  api.PkgName
+		source    string
+		ok        bool
+	}{
+		{
+			scenario: "Maps a path to the gem named after it",
+			path:     "sinatra/base",
+			expected: "sinatra",
+			source:   "gem-map",
+			ok:       true,
+		},
+		{
+			scenario:  "Prefers the pragma to the gem map",
+			path:      "colorize",
+			pragmaGem: "colorized-fork",
+			expected:  "colorized-fork",
+			source:    "pragma",
+			ok:        true,
+		},
+		{
+			scenario: "Skips paths provided by default gems, even if other gems provide them",
+			path:     "json",
+		},
+		{
+			scenario: "Skips paths provided by Ruby itself",
+			path:     "socket",
+		},
+		{
+			scenario: "Skips unknown paths",
+			path:     "no/such/gem",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.scenario, func(t *testing.T) {
+			reason, ok := requireGem(tc.path, tc.pragmaGem)
+			if ok != tc.ok || reason.Package != tc.expected || reason.Source != tc.source {
+				t.Errorf("expected %v %s from %s, got %v %+v", tc.ok, tc.expected, tc.source, ok, reason)
+			}
+		})
+	}
+}
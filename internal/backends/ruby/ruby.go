@@ -7,12 +7,18 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/replit/upm/internal/api"
 	"github.com/replit/upm/internal/util"
 )
 
+// this generates a mapping of require paths -> gems
+// requireToGem requireToGemCandidates gemToDownloads are provided
+//go:generate go run ./gen_gem_map -from rubygems_files.json -pkg ruby -out gem_map.gen.go
+
 // rubygemsInfo represents the information we get from Gems.search (a
 // list of maps) or Gems.info (just one map) in JSON format.
 type rubygemsInfo struct {
@@ -29,6 +35,160 @@ type rubygemsInfo struct {
 	Name             string   `json:"name"`
 	SourceCodeURI    string   `json:"source_code_uri"`
 	Version          string   `json:"version"`
+	VersionCreatedAt string   `json:"version_created_at"`
+	Downloads        int      `json:"downloads"`
+}
+
+// rubygemsVersion represents one element of the list we get from
+// the RubyGems versions API.
+type rubygemsVersion struct {
+	Number         string `json:"number"`
+	CreatedAt      string `json:"created_at"`
+	DownloadsCount int    `json:"downloads_count"`
+	Prerelease     bool   `json:"prerelease"`
+}
+
+// parseRubygemsTime parses a timestamp from the RubyGems API,
+// returning nil if it is missing or malformed.
+func parseRubygemsTime(str string) *time.Time {
+	t, err := time.Parse(time.RFC3339, str)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+// toPkgInfo converts the information we get from the RubyGems API
+// into the format used by UPM.
+func (s *rubygemsInfo) toPkgInfo() api.PkgInfo {
+	deps := []string{}
+	for _, group := range s.Dependencies {
+		for _, dep := range group {
+			deps = append(deps, dep.Name)
+		}
+	}
+	return api.PkgInfo{
+		Name:             s.Name,
+		Description:      s.Info,
+		Version:          s.Version,
+		HomepageURL:      s.HomepageURI,
+		DocumentationURL: s.DocumentationURI,
+		SourceCodeURL:    s.SourceCodeURI,
+		BugTrackerURL:    s.BugTrackerURI,
+		Author:           s.Authors,
+		License:          strings.Join(s.Licenses, ", "),
+		Dependencies:     deps,
+		PublishedAt:      parseRubygemsTime(s.VersionCreatedAt),
+		Downloads:        s.Downloads,
+	}
+}
+
+// getInfo fetches information about a gem from the given RubyGems API
+// URL, which is either for the latest version or for a specific
+// version. If the gem doesn't exist, it returns a zero struct.
+func getInfo(endpoint string) api.PkgInfo {
+	resp, err := http.Get(endpoint)
+	if err != nil {
+		util.Die("RubyGems: %s", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case 200:
+		break
+	case 404:
+		return api.PkgInfo{}
+	default:
+		util.Die("RubyGems: HTTP status %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		util.Die("RubyGems: %s", err)
+	}
+
+	var s rubygemsInfo
+	if err := json.Unmarshal(body, &s); err != nil {
+		util.Die("RubyGems response: %s", err)
+	}
+	return s.toPkgInfo()
+}
+
+// getVersions returns all the versions of the given gem, from oldest
+// to newest. RubyGems lists a version once per platform it was built
+// for, so these are merged. The version list is supplementary, so on
+// error it just returns nil.
+func getVersions(name api.PkgName) api.PkgVersions {
+	endpoint := "https://rubygems.org/api/v1/versions/"
+	path := url.QueryEscape(string(name)) + ".json"
+
+	resp, err := http.Get(endpoint + path)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil
+	}
+
+	var outputStructs []rubygemsVersion
+	if err := json.NewDecoder(resp.Body).Decode(&outputStructs); err != nil {
+		return nil
+	}
+
+	// RubyGems lists versions from newest to oldest.
+	versions := api.PkgVersions{}
+	indices := map[string]int{}
+	for i := len(outputStructs) - 1; i >= 0; i-- {
+		v := outputStructs[i]
+		if j, ok := indices[v.Number]; ok {
+			versions[j].Downloads += v.DownloadsCount
+			continue
+		}
+		indices[v.Number] = len(versions)
+		versions = append(versions, api.PkgVersionInfo{
+			Version:     v.Number,
+			PublishedAt: parseRubygemsTime(v.CreatedAt),
+			Prerelease:  v.Prerelease,
+			Downloads:   v.DownloadsCount,
+		})
+	}
+	return versions
+}
+
+// rubygemsPageSize is the number of results RubyGems returns for each
+// page of search results.
+const rubygemsPageSize = 30
+
+// searchPage returns the given page (starting from 1) of search
+// results from RubyGems.
+func searchPage(query string, page int) []api.PkgInfo {
+	endpoint := "https://rubygems.org/api/v1/search.json"
+	queryParams := "?query=" + url.QueryEscape(query) +
+		"&page=" + strconv.Itoa(page)
+
+	resp, err := http.Get(endpoint + queryParams)
+	if err != nil {
+		util.Die("RubyGems: %s", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		util.Die("RubyGems: %s", err)
+	}
+
+	var outputStructs []rubygemsInfo
+	if err := json.Unmarshal(body, &outputStructs); err != nil {
+		util.Die("RubyGems response: %s", err)
+	}
+
+	results := []api.PkgInfo{}
+	for _, s := range outputStructs {
+		results = append(results, s.toPkgInfo())
+	}
+	return results
 }
 
 // getPath returns the appropriate --path for 'bundle install'. This
@@ -65,7 +225,8 @@ var RubyBackend = api.LanguageBackend{
 	Name:             "ruby-bundler",
 	Specfile:         "Gemfile",
 	Lockfile:         "Gemfile.lock",
-	FilenamePatterns: []string{"*.rb"},
+	FilenamePatterns: rubyPatterns,
+	Executables:      []string{"bundle"},
 	Quirks:           api.QuirksAddRemoveAlsoLocks,
 	GetPackageDir: func() string {
 		path := string(util.GetCmdOutput([]string{
@@ -78,95 +239,34 @@ var RubyBackend = api.LanguageBackend{
 			return path
 		}
 	},
+	PackageDirMarkers: []string{"ruby/*/specifications/*.gemspec"},
 	Search: func(query string) []api.PkgInfo {
-		endpoint := "https://rubygems.org/api/v1/search.json"
-		queryParams := "?query=" + url.QueryEscape(query)
-
-		resp, err := http.Get(endpoint + queryParams)
-		if err != nil {
-			util.Die("RubyGems: %s", err)
-		}
-		defer resp.Body.Close()
-
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			util.Die("RubyGems: %s", err)
-		}
-
-		var outputStructs []rubygemsInfo
-		if err := json.Unmarshal(body, &outputStructs); err != nil {
-			util.Die("RubyGems response: %s", err)
-		}
-
-		results := []api.PkgInfo{}
-		for _, s := range outputStructs {
-			deps := []string{}
-			for _, group := range s.Dependencies {
-				for _, dep := range group {
-					deps = append(deps, dep.Name)
-				}
-			}
-			results = append(results, api.PkgInfo{
-				Name:             s.Name,
-				Description:      s.Info,
-				Version:          s.Version,
-				HomepageURL:      s.HomepageURI,
-				DocumentationURL: s.DocumentationURI,
-				SourceCodeURL:    s.SourceCodeURI,
-				BugTrackerURL:    s.BugTrackerURI,
-				Author:           s.Authors,
-				License:          strings.Join(s.Licenses, ", "),
-				Dependencies:     deps,
-			})
-		}
-		return results
+		return searchPage(query, 1)
+	},
+	SearchPaged: func(query string, offset int, limit int) []api.PkgInfo {
+		return api.FetchPages(offset, limit, rubygemsPageSize, func(page int) []api.PkgInfo {
+			return searchPage(query, page)
+		})
 	},
 	Info: func(name api.PkgName) api.PkgInfo {
 		endpoint := "https://rubygems.org/api/v1/gems/"
 		path := url.QueryEscape(string(name)) + ".json"
 
-		resp, err := http.Get(endpoint + path)
-		if err != nil {
-			util.Die("RubyGems: %s", err)
-		}
-		defer resp.Body.Close()
-
-		switch resp.StatusCode {
-		case 200:
-			break
-		case 404:
-			return api.PkgInfo{}
-		default:
-			util.Die("RubyGems: HTTP status %d", resp.StatusCode)
-		}
-
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			util.Die("RubyGems: %s", err)
+		info := getInfo(endpoint + path)
+		if info.Name != "" {
+			info.Versions = getVersions(name)
 		}
+		return info
+	},
+	VersionInfo: func(name api.PkgName, version api.PkgVersion) api.PkgInfo {
+		endpoint := "https://rubygems.org/api/v2/rubygems/"
+		path := url.QueryEscape(string(name)) + "/versions/" + url.QueryEscape(string(version)) + ".json"
 
-		var s rubygemsInfo
-		if err := json.Unmarshal(body, &s); err != nil {
-			util.Die("RubyGems response: %s", err)
-		}
-		deps := []string{}
-		for _, group := range s.Dependencies {
-			for _, dep := range group {
-				deps = append(deps, dep.Name)
-			}
-		}
-		return api.PkgInfo{
-			Name:             s.Name,
-			Description:      s.Info,
-			Version:          s.Version,
-			HomepageURL:      s.HomepageURI,
-			DocumentationURL: s.DocumentationURI,
-			SourceCodeURL:    s.SourceCodeURI,
-			BugTrackerURL:    s.BugTrackerURI,
-			Author:           s.Authors,
-			License:          strings.Join(s.Licenses, ", "),
-			Dependencies:     deps,
+		info := getInfo(endpoint + path)
+		if info.Name != "" {
+			info.Versions = getVersions(name)
 		}
+		return info
 	},
 	Add: func(pkgs map[api.PkgName]api.PkgSpec, projectName string) {
 		if !util.Exists("Gemfile") {
@@ -231,17 +331,8 @@ var RubyBackend = api.LanguageBackend{
 		}
 		return results
 	},
-	GuessRegexps: util.Regexps([]string{
-		`require\s*['"]([^'"]+)['"]`,
-	}),
-	Guess: func() (map[api.PkgName]bool, bool) {
-		guessedGems := util.GetCmdOutput([]string{
-			"ruby", "-e", util.GetResource("/ruby/guess-gems.rb"),
-		})
-		results := map[api.PkgName]bool{}
-		if err := json.Unmarshal(guessedGems, &results); err != nil {
-			util.Die("ruby: %s", err)
-		}
-		return results, true
-	},
+	Guess:            rubyGuess,
+	GuessFileImports: rubyGuessFileImports,
+	GuessFromImports: rubyGuessFromImports,
+	GuessExplain:     rubyGuessExplain,
 }
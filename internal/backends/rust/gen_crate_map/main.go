@@ -0,0 +1,75 @@
+// This command generates go source holding a mapping of:
+// rust identifiers -> most likely crate
+// rust identifiers -> other crates with the same identifier
+// and
+// crates -> downloads
+//
+// these are provided as the maps identToCrate, identToCrateCandidates and
+// crateToDownloads respectively.
+//
+// Crate names may contain "-", but the identifier used to refer to a crate in
+// Rust code always has "_" instead, so "serde-json" and "serde_json" would
+// both be used as serde_json. (crates.io treats such names as the same crate,
+// but older crates predate that.)
+//
+// The input is a crates.io name index with one JSON object per line, e.g.
+// {"n":"serde_json","d":1000} giving the crate name and its downloads.
+package main
+
+import (
+	"strings"
+
+	"github.com/replit/upm/internal/genmap"
+)
+
+type indexEntry struct {
+	Name      string `json:"n"`
+	Downloads int    `json:"d"`
+}
+
+func main() {
+	from, pkg, out := genmap.Flags()
+
+	entries := []*indexEntry{}
+	genmap.ReadIndex(from, func() interface{} {
+		e := &indexEntry{}
+		entries = append(entries, e)
+		return e
+	})
+
+	idents := genmap.Candidates{}
+	for _, e := range entries {
+		ident := strings.Replace(e.Name, "-", "_", -1)
+		idents.Add(ident, &genmap.Package{Name: e.Name, Count: e.Downloads})
+	}
+	sortedIdents := idents.Rank()
+
+	w := genmap.NewWriter(pkg)
+
+	// Every identifier is used by some crate, so the most
+	// downloaded one is always chosen.
+	chosen := map[string]*genmap.Package{}
+	w.BeginMap("identToCrate", "string", `
+identToCrate holds a map of the identifier of every known crate to the
+most downloaded crate with that identifier. This helps us guess which
+crates should be added for the given paths.
+`)
+	for _, ident := range sortedIdents {
+		chosen[ident] = idents[ident][0]
+		w.Entry(ident, chosen[ident].Name, "")
+	}
+	w.EndMap("identToCrate")
+
+	downloads := w.CandidatesMap("identToCrateCandidates", `
+identToCrateCandidates holds a map of every identifier in identToCrate
+which is used by more than one crate to the other crates which use it,
+most downloaded first. This is used to work out how confident a guess is.
+`, idents, sortedIdents, chosen)
+
+	w.CountMap("crateToDownloads", `
+crateToDownloads holds a map of every crate whose identifier is used by
+more than one crate to the number of times it has been downloaded.
+`, downloads)
+
+	w.WriteFile(out)
+}
@@ -0,0 +1,440 @@
+package rust
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/BurntSushi/toml"
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+// rustPatterns is the FilenamePatterns value for RustBackend.
+var rustPatterns = []string{"*.rs"}
+
+// rustGuessRegexps is the GuessRegexps value for RustBackend. Between
+// them, they match everything that findCrateRefs looks at.
+var rustGuessRegexps = util.Regexps([]string{
+	`\bextern\s+crate\s+(\w+)`,
+	`\buse\s+(?:::)?\s*\{?\s*(\w+)`,
+	`\b([a-z_]\w*)\s*::`,
+	`\bmod\s+(\w+)`,
+})
+
+// rustBuiltinCrates are the crates that come with Rust, the path roots
+// that refer to the current crate or module, and the primitive types,
+// whose associated items are used with paths like u8::MAX.
+var rustBuiltinCrates = map[string]bool{
+	"alloc":      true,
+	"core":       true,
+	"proc_macro": true,
+	"std":        true,
+	"test":       true,
+
+	"crate": true,
+	"self":  true,
+	"super": true,
+	"Self":  true,
+
+	"bool":  true,
+	"char":  true,
+	"f32":   true,
+	"f64":   true,
+	"i8":    true,
+	"i16":   true,
+	"i32":   true,
+	"i64":   true,
+	"i128":  true,
+	"isize": true,
+	"str":   true,
+	"u8":    true,
+	"u16":   true,
+	"u32":   true,
+	"u64":   true,
+	"u128":  true,
+	"usize": true,
+}
+
+// rustToken is a token of Rust source code, as returned by
+// rustTokenize: either an identifier or keyword, or punctuation, with
+// "::" as a single token.
+type rustToken struct {
+	text  string
+	ident bool
+	line  int
+}
+
+// rustStringLength returns the length of the string, byte string, raw
+// string or character literal at the start of src, or -1 if there
+// isn't one. A quote that doesn't start a character literal is a
+// lifetime.
+func rustStringLength(src string) int {
+	i := 0
+	if strings.HasPrefix(src, "b") {
+		i++
+	}
+	if strings.HasPrefix(src[i:], "r") {
+		j := i + 1
+		for j < len(src) && src[j] == '#' {
+			j++
+		}
+		if j == len(src) || src[j] != '"' {
+			return -1
+		}
+		end := strings.Index(src[j+1:], "\""+strings.Repeat("#", j-i-1))
+		if end == -1 {
+			return len(src)
+		}
+		return j + 1 + end + 1 + (j - i - 1)
+	}
+	if i == len(src) {
+		return -1
+	}
+	switch src[i] {
+	case '"':
+		for j := i + 1; j < len(src); j++ {
+			if src[j] == '\\' {
+				j++
+			} else if src[j] == '"' {
+				return j + 1
+			}
+		}
+		return len(src)
+	case '\'':
+		if strings.HasPrefix(src[i+1:], "\\") {
+			end := strings.IndexByte(src[i+2:], '\'')
+			if end == -1 {
+				return -1
+			}
+			return i + 2 + end + 1
+		}
+		// One character, which may be several bytes.
+		_, size := utf8.DecodeRuneInString(src[i+1:])
+		if size > 0 && strings.HasPrefix(src[i+1+size:], "'") {
+			return i + 1 + size + 1
+		}
+	}
+	return -1
+}
+
+// rustTokenize splits Rust source code into tokens, leaving out
+// whitespace, comments and literals.
+func rustTokenize(src string) []rustToken {
+	tokens := []rustToken{}
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+
+		case strings.HasPrefix(src[i:], "//"):
+			end := strings.IndexByte(src[i:], '\n')
+			if end == -1 {
+				end = len(src) - i
+			}
+			i += end
+
+		case strings.HasPrefix(src[i:], "/*"):
+			// Block comments nest in Rust.
+			n := util.SkipBlockComment(src[i:], "/*", "*/", true)
+			line += strings.Count(src[i:i+n], "\n")
+			i += n
+
+		case (c == '"' || c == '\'' || ((c == 'b' || c == 'r') && (i == 0 || !util.IsIdentByte(src[i-1])))) &&
+			rustStringLength(src[i:]) != -1:
+			n := rustStringLength(src[i:])
+			line += strings.Count(src[i:i+n], "\n")
+			i += n
+
+		case util.IsIdentByte(c):
+			start := i
+			for i < len(src) && util.IsIdentByte(src[i]) {
+				i++
+			}
+			tokens = append(tokens, rustToken{text: src[start:i], ident: true, line: line})
+
+		case strings.HasPrefix(src[i:], "::"):
+			tokens = append(tokens, rustToken{text: "::", line: line})
+			i += 2
+
+		default:
+			tokens = append(tokens, rustToken{text: string(c), line: line})
+			i++
+		}
+	}
+	return tokens
+}
+
+// rustCrateRef is a reference to a path root which may be a crate, as
+// found by findCrateRefs.
+type rustCrateRef struct {
+	// The identifier, e.g. "serde_json".
+	ident string
+
+	// The line of the first reference.
+	line int
+}
+
+// rustFileRefs is the result of findCrateRefs.
+type rustFileRefs struct {
+	// The roots of extern crate declarations, use declarations
+	// and qualified paths, in the order they first appear, other
+	// than those that are names brought into scope by a use
+	// declaration in the same file.
+	refs []rustCrateRef
+
+	// The names of the modules declared with mod.
+	mods []string
+}
+
+// parseUseTree parses a use tree starting at tokens[i], adding its
+// root to roots if top is true and the names it brings into scope to
+// bound. It returns the index just after the tree.
+func parseUseTree(tokens []rustToken, i int, top bool, roots *[]rustToken, bound map[string]bool) int {
+	if i < len(tokens) && tokens[i].text == "::" {
+		i++
+	}
+	if i < len(tokens) && tokens[i].text == "{" {
+		i++
+		for i < len(tokens) && tokens[i].text != "}" {
+			i = parseUseTree(tokens, i, top, roots, bound)
+			if i < len(tokens) && tokens[i].text == "," {
+				i++
+			} else {
+				break
+			}
+		}
+		if i < len(tokens) && tokens[i].text == "}" {
+			i++
+		}
+		return i
+	}
+	if i == len(tokens) || !tokens[i].ident {
+		if i < len(tokens) && tokens[i].text == "*" {
+			i++
+		}
+		return i
+	}
+
+	if top {
+		*roots = append(*roots, tokens[i])
+	}
+	last := tokens[i].text
+	i++
+	if i < len(tokens) && tokens[i].text == "::" {
+		return parseUseTree(tokens, i+1, false, roots, bound)
+	}
+	if i+1 < len(tokens) && tokens[i].text == "as" && tokens[i+1].ident {
+		last = tokens[i+1].text
+		i += 2
+	} else if top {
+		// Something like "use log;" brings the crate itself into
+		// scope, under its own name.
+		return i
+	}
+	if last != "self" && last != "_" {
+		bound[last] = true
+	}
+	return i
+}
+
+// findCrateRefs returns the possible references to crates in Rust
+// source code, together with the modules it declares.
+func findCrateRefs(src string) rustFileRefs {
+	tokens := rustTokenize(src)
+	roots := []rustToken{}
+	bound := map[string]bool{}
+	mods := []string{}
+
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		if !tok.ident {
+			continue
+		}
+		prevPath := i > 0 && tokens[i-1].text == "::"
+		switch {
+		case tok.text == "extern" && i+2 < len(tokens) && tokens[i+1].text == "crate" && tokens[i+2].ident:
+			roots = append(roots, tokens[i+2])
+			if i+4 < len(tokens) && tokens[i+3].text == "as" && tokens[i+4].ident {
+				bound[tokens[i+4].text] = true
+			}
+			i += 2
+
+		case tok.text == "use" && !prevPath:
+			i = parseUseTree(tokens, i+1, true, &roots, bound) - 1
+
+		case tok.text == "mod" && i+1 < len(tokens) && tokens[i+1].ident:
+			mods = append(mods, tokens[i+1].text)
+			i++
+
+		case !prevPath && i+1 < len(tokens) && tokens[i+1].text == "::":
+			// Crate names are lowercase by convention, so
+			// this skips types like Vec::new.
+			if tok.text[0] < 'A' || tok.text[0] > 'Z' {
+				roots = append(roots, tok)
+			}
+		}
+	}
+
+	refs := []rustCrateRef{}
+	seen := map[string]bool{}
+	for _, root := range roots {
+		if seen[root.text] || bound[root.text] {
+			continue
+		}
+		seen[root.text] = true
+		refs = append(refs, rustCrateRef{ident: root.text, line: root.line})
+	}
+	return rustFileRefs{refs: refs, mods: mods}
+}
+
+// rustCrateIdent returns the identifier used in Rust code for a crate
+// name, e.g. "serde_json" for "serde-json".
+func rustCrateIdent(name string) string {
+	return strings.Replace(name, "-", "_", -1)
+}
+
+// rustOwnCrates returns the identifiers of the crates in the project
+// itself: the package and library target in Cargo.toml, and those of
+// its workspace members.
+func rustOwnCrates() map[string]bool {
+	own := map[string]bool{}
+	var read func(dir string, workspace bool)
+	read = func(dir string, workspace bool) {
+		manifest := filepath.Join(dir, "Cargo.toml")
+		var specfile cargoToml
+		if _, err := toml.DecodeFile(manifest, &specfile); err != nil {
+			return
+		}
+		for _, name := range []string{specfile.Package.Name, specfile.Lib.Name} {
+			if name != "" {
+				own[rustCrateIdent(name)] = true
+			}
+		}
+		if !workspace {
+			return
+		}
+		for _, pattern := range specfile.Workspace.Members {
+			members, err := filepath.Glob(filepath.Join(dir, pattern))
+			if err != nil {
+				continue
+			}
+			for _, member := range members {
+				read(member, false)
+			}
+		}
+	}
+	read(".", true)
+	return own
+}
+
+// rustSourceFiles returns the Rust files of the project, leaving out
+// those in a target directory, where Cargo puts the build output,
+// including the sources that build scripts generate.
+func rustSourceFiles() []string {
+	files := []string{}
+	for _, file := range util.ListFilesRecursive(rustPatterns) {
+		inTarget := false
+		for _, dir := range strings.Split(filepath.ToSlash(filepath.Dir(file)), "/") {
+			inTarget = inTarget || dir == "target"
+		}
+		if !inTarget {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// rustLocalModules returns the names of the modules which may be
+// declared by the project itself, namely those declared with mod, the
+// basenames of the Rust files (without extension), and the names of
+// the directories containing them.
+func rustLocalModules(files []string, refs map[string]rustFileRefs) map[string]bool {
+	local := map[string]bool{}
+	for _, file := range files {
+		local[strings.TrimSuffix(filepath.Base(file), ".rs")] = true
+		local[filepath.Base(filepath.Dir(file))] = true
+		for _, mod := range refs[file].mods {
+			local[mod] = true
+		}
+	}
+	return local
+}
+
+// rustGuessExtraInput is the GuessExtraInput value for RustBackend.
+// The project's own crates, and the modules named after its files and
+// directories, are left out, so they are hashed too.
+func rustGuessExtraInput() []byte {
+	own := []string{}
+	for ident := range rustOwnCrates() {
+		own = append(own, ident)
+	}
+	sort.Strings(own)
+	files := rustSourceFiles()
+	return []byte(strings.Join(own, ",") + "\n" + strings.Join(files, "\n"))
+}
+
+// identCrate returns the reason for guessing the crate referred to by
+// an identifier, which is left for the caller to place. The second
+// return value is false if there is no such crate.
+func identCrate(ident string) (api.GuessReason, bool) {
+	crate, ok := identToCrate()[ident]
+	if !ok {
+		return api.GuessReason{}, false
+	}
+
+	confidence, alternatives := api.DownloadShare(
+		crate, identToCrateCandidates()[ident], crateToDownloads(),
+	)
+
+	return api.GuessReason{
+		Package:      api.PkgName(crate),
+		Module:       ident,
+		Source:       "crate-index",
+		Confidence:   confidence,
+		Alternatives: alternatives,
+	}, true
+}
+
+// rustGuessExplain implements GuessExplain for RustBackend. There is a
+// reason for each file that refers to a crate, at the first reference
+// in the file.
+func rustGuessExplain() []api.GuessReason {
+	files := rustSourceFiles()
+	refs := map[string]rustFileRefs{}
+	for _, file := range files {
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			util.Die("%s: %s", file, err)
+		}
+		refs[file] = findCrateRefs(string(contents))
+	}
+
+	local := rustLocalModules(files, refs)
+	own := rustOwnCrates()
+
+	reasons := []api.GuessReason{}
+	for _, file := range files {
+		for _, ref := range refs[file].refs {
+			if rustBuiltinCrates[ref.ident] || local[ref.ident] || own[ref.ident] {
+				continue
+			}
+			reason, ok := identCrate(ref.ident)
+			if !ok {
+				continue
+			}
+			reason.File = file
+			reason.Line = ref.line
+			reasons = append(reasons, reason)
+		}
+	}
+	return reasons
+}
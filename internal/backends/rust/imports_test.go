@@ -0,0 +1,245 @@
+package rust
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestFindCrateRefs(t *testing.T) {
+	tcs := []struct {
+		scenario string
+		src      string
+		expected rustFileRefs
+	}{
+		{
+			scenario: "Returns the roots of extern crate and use declarations",
+			src: `extern crate rand;
+use serde::{Deserialize, Serialize};
+use ::tokio::sync::Mutex;
+use {regex::Regex, log};
+use std::collections::HashMap;
+`,
+			expected: rustFileRefs{
+				refs: []rustCrateRef{
+					{ident: "rand", line: 1},
+					{ident: "serde", line: 2},
+					{ident: "tokio", line: 3},
+					{ident: "regex", line: 4},
+					{ident: "log", line: 4},
+					{ident: "std", line: 5},
+				},
+				mods: []string{},
+			},
+		},
+		{
+			scenario: "Returns the roots of qualified paths but not types or names in scope",
+			src: `use anyhow::Context;
+
+fn main() {
+    let v: serde_json::Value = serde_json::from_str("{}").unwrap();
+    let m = Vec::new();
+    let x = Context::context(x);
+    let y = foo::bar::baz();
+}
+`,
+			expected: rustFileRefs{
+				refs: []rustCrateRef{
+					{ident: "anyhow", line: 1},
+					{ident: "serde_json", line: 4},
+					{ident: "foo", line: 7},
+				},
+				mods: []string{},
+			},
+		},
+		{
+			scenario: "Leaves out names bound by use and extern crate aliases",
+			src: `extern crate gl_generator as gl;
+use chrono::offset;
+use self::util as helpers;
+
+fn f() {
+    gl::load();
+    offset::Local::now();
+    helpers::go();
+}
+`,
+			expected: rustFileRefs{
+				refs: []rustCrateRef{
+					{ident: "gl_generator", line: 1},
+					{ident: "chrono", line: 2},
+					{ident: "self", line: 3},
+				},
+				mods: []string{},
+			},
+		},
+		{
+			scenario: "Returns mod declarations",
+			src: `mod config;
+pub mod handlers {
+    use super::config;
+}
+`,
+			expected: rustFileRefs{
+				refs: []rustCrateRef{
+					{ident: "super", line: 3},
+				},
+				mods: []string{"config", "handlers"},
+			},
+		},
+		{
+			scenario: "Skips comments, strings and char literals",
+			src: `// use commented::out;
+/* /* nested */ use also::commented; */
+fn f<'a>(s: &'a str) -> char {
+    let a = "quoted::path";
+    let b = r#"raw::"path"#;
+    let c = b"bytes::path";
+    let d = '"';
+    let e = '\'';
+    real::call(s)
+}
+`,
+			expected: rustFileRefs{
+				refs: []rustCrateRef{
+					{ident: "real", line: 9},
+				},
+				mods: []string{},
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.scenario, func(t *testing.T) {
+			refs := findCrateRefs(tc.src)
+			if !reflect.DeepEqual(refs, tc.expected) {
+				t.Errorf("expected %+v, got %+v", tc.expected, refs)
+			}
+		})
+	}
+}
+
+// inProject runs f in a temporary project containing the given files.
+func inProject(t *testing.T, files map[string]string, f func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "upm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	f()
+}
+
+// workspaceFiles is a workspace whose members are crates that are
+// also on crates.io.
+var workspaceFiles = map[string]string{
+	"Cargo.toml": `[package]
+name = "my-app"
+
+[workspace]
+members = ["crates/*", "tools/gen"]
+`,
+	"crates/mylib/Cargo.toml": `[package]
+name = "mylib-core"
+
+[lib]
+name = "mylib"
+`,
+	"crates/tokio-util/Cargo.toml": `[package]
+name = "tokio-util"
+`,
+	"tools/gen/Cargo.toml": `[package]
+name = "gl_generator"
+
+[workspace]
+members = ["nested"]
+`,
+	"tools/gen/nested/Cargo.toml": `[package]
+name = "nested"
+`,
+	"src/main.rs": `use mylib::run;
+use tokio_util::codec;
+use serde::Deserialize;
+
+mod log;
+fn main() { gl_generator::go(); log::init(); regex::x(); }
+`,
+	"src/log.rs":       ``,
+	"src/regex/mod.rs": ``,
+}
+
+func TestRustOwnCrates(t *testing.T) {
+	inProject(t, workspaceFiles, func() {
+		own := []string{}
+		for ident := range rustOwnCrates() {
+			own = append(own, ident)
+		}
+		sort.Strings(own)
+		// Members of members aren't part of the workspace.
+		expected := []string{"gl_generator", "my_app", "mylib", "mylib_core", "tokio_util"}
+		if !reflect.DeepEqual(own, expected) {
+			t.Errorf("expected %v, got %v", expected, own)
+		}
+	})
+}
+
+func TestRustGuessExplain(t *testing.T) {
+	inProject(t, workspaceFiles, func() {
+		// The workspace members and the local log and regex
+		// modules shadow crates of the same names.
+		pkgs := []string{}
+		for _, reason := range rustGuessExplain() {
+			pkgs = append(pkgs, string(reason.Package))
+		}
+		expected := []string{"serde"}
+		if !reflect.DeepEqual(pkgs, expected) {
+			t.Errorf("expected %v, got %v", expected, pkgs)
+		}
+	})
+}
+
+func TestRustGuessExplainSkipsTarget(t *testing.T) {
+	files := map[string]string{}
+	for name, contents := range workspaceFiles {
+		files[name] = contents
+	}
+	// A build script's output, whose name would otherwise shadow
+	// serde, and whose imports would otherwise be guessed.
+	files["target/debug/build/my-app-0123456789abcdef/out/serde.rs"] = `use rand::Rng;`
+	inProject(t, files, func() {
+		pkgs := []string{}
+		for _, reason := range rustGuessExplain() {
+			pkgs = append(pkgs, string(reason.Package))
+		}
+		expected := []string{"serde"}
+		if !reflect.DeepEqual(pkgs, expected) {
+			t.Errorf("expected %v, got %v", expected, pkgs)
+		}
+
+		if input := string(rustGuessExtraInput()); strings.Contains(input, "target") {
+			t.Errorf("expected the build output not to be hashed, got %q", input)
+		}
+	})
+}
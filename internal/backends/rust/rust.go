@@ -6,13 +6,30 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/replit/upm/internal/api"
 	"github.com/replit/upm/internal/util"
 )
 
+// this generates a mapping of rust identifiers -> crates
+// identToCrate identToCrateCandidates crateToDownloads are provided
+//go:generate go run ./gen_crate_map -from crates_index.json -pkg rust -out crate_map.gen.go
+
 type cargoToml struct {
+	Package struct {
+		Name string `toml:"name"`
+	} `toml:"package"`
+	Lib struct {
+		Name string `toml:"name"`
+	} `toml:"lib"`
+	Workspace struct {
+		Members []string `toml:"members"`
+	} `toml:"workspace"`
 	Dependencies map[string]interface{} `toml:"dependencies"`
 }
 
@@ -41,6 +58,7 @@ type crate struct {
 	Documentation string `json:"documentation"`
 	Repository    string `json:"repository"`
 	NewestVersion string `json:"newest_version"`
+	Downloads     int    `json:"downloads"`
 	Versions      []int  `json:"versions"`
 }
 
@@ -48,39 +66,97 @@ type version struct {
 	Num         string `json:"num"`
 	PublishedBy user   `json:"published_by"`
 	License     string `json:"license"`
+	CreatedAt   string `json:"created_at"`
+	Yanked      bool   `json:"yanked"`
+	Downloads   int    `json:"downloads"`
+}
+
+func (v *version) createdAt() *time.Time {
+	t, err := time.Parse(time.RFC3339, v.CreatedAt)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+type crateDependenciesResult struct {
+	Dependencies []dependency `json:"dependencies"`
+}
+
+type dependency struct {
+	CrateID string `json:"crate_id"`
+	Kind    string `json:"kind"`
 }
 
 type user struct {
 	Name string `json:"name"`
 }
 
-func (c *crateInfoResult) toPkgInfo() api.PkgInfo {
-	var author string
-	var license string
-
-	for _, version := range c.Versions {
-		if version.Num == c.Crate.NewestVersion {
-			author = version.PublishedBy.Name
-			license = version.License
-			break
-		}
-	}
+// isPrerelease reports whether a semver version has a prerelease
+// suffix, like 1.0.0-beta.2.
+func isPrerelease(num string) bool {
+	num = strings.SplitN(num, "+", 2)[0]
+	return strings.Contains(num, "-")
+}
 
-	return api.PkgInfo{
+// toPkgInfo returns the information for the given version of the
+// crate, which should be one of c.Versions (unless there are none).
+func (c *crateInfoResult) toPkgInfo(num string) api.PkgInfo {
+	info := api.PkgInfo{
 		Name:             c.Crate.Name,
 		Description:      c.Crate.Description,
-		Version:          c.Crate.NewestVersion,
+		Version:          num,
 		HomepageURL:      c.Crate.Homepage,
 		DocumentationURL: c.Crate.Documentation,
 		SourceCodeURL:    c.Crate.Repository,
-		Author:           author,
-		License:          license,
+		Downloads:        c.Crate.Downloads,
 	}
+
+	// crates.io lists versions from newest to oldest.
+	for i := len(c.Versions) - 1; i >= 0; i-- {
+		version := &c.Versions[i]
+		info.Versions = append(info.Versions, api.PkgVersionInfo{
+			Version:     version.Num,
+			PublishedAt: version.createdAt(),
+			Prerelease:  isPrerelease(version.Num),
+			Yanked:      version.Yanked,
+			Downloads:   version.Downloads,
+		})
+
+		if version.Num == num {
+			info.Author = version.PublishedBy.Name
+			info.License = version.License
+			info.PublishedAt = version.createdAt()
+			info.Yanked = version.Yanked
+		}
+	}
+
+	return info
 }
 
 func search(query string) []api.PkgInfo {
+	// crates.io returns 10 results by default.
+	return searchPage(query, 1, 10)
+}
+
+// cratesMaxPerPage is the largest page size that crates.io allows.
+const cratesMaxPerPage = 100
+
+func searchPaged(query string, offset int, limit int) []api.PkgInfo {
+	perPage := limit
+	if perPage > cratesMaxPerPage {
+		perPage = cratesMaxPerPage
+	}
+	return api.FetchPages(offset, limit, perPage, func(page int) []api.PkgInfo {
+		return searchPage(query, page, perPage)
+	})
+}
+
+func searchPage(query string, page int, perPage int) []api.PkgInfo {
 	endpoint := "https://crates.io/api/v1/crates"
-	path := "?q=" + url.QueryEscape(query)
+	path := "?q=" + url.QueryEscape(query) +
+		"&page=" + strconv.Itoa(page) +
+		"&per_page=" + strconv.Itoa(perPage)
 
 	resp, err := http.Get(endpoint + path)
 	if err != nil {
@@ -103,13 +179,13 @@ func search(query string) []api.PkgInfo {
 		crateInfo := crateInfoResult{
 			Crate: crate,
 		}
-		pkgs = append(pkgs, crateInfo.toPkgInfo())
+		pkgs = append(pkgs, crateInfo.toPkgInfo(crate.NewestVersion))
 	}
 
 	return pkgs
 }
 
-func info(name api.PkgName) api.PkgInfo {
+func fetchCrateInfo(name api.PkgName) (crateInfoResult, bool) {
 	endpoint := "https://crates.io/api/v1/crates"
 	path := "/" + url.PathEscape(string(name))
 
@@ -123,18 +199,81 @@ func info(name api.PkgName) api.PkgInfo {
 	case 200:
 		break
 	case 404:
-		return api.PkgInfo{}
+		return crateInfoResult{}, false
 	default:
 		util.Die("crates.io: HTTP status %d", resp.StatusCode)
 	}
 
 	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		util.Die("crates.io: %s", err)
+	}
 	var crateInfo crateInfoResult
 	if err := json.Unmarshal(body, &crateInfo); err != nil {
 		util.Die("crates.io: %s", err)
 	}
 
-	return crateInfo.toPkgInfo()
+	return crateInfo, true
+}
+
+func fetchDependencies(name api.PkgName, num string) []string {
+	endpoint := "https://crates.io/api/v1/crates"
+	path := "/" + url.PathEscape(string(name)) + "/" + url.PathEscape(num) + "/dependencies"
+
+	resp, err := http.Get(endpoint + path)
+	if err != nil {
+		util.Die("crates.io: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		util.Die("crates.io: HTTP status %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		util.Die("crates.io: %s", err)
+	}
+	var crateDeps crateDependenciesResult
+	if err := json.Unmarshal(body, &crateDeps); err != nil {
+		util.Die("crates.io: %s", err)
+	}
+
+	deps := []string{}
+	for _, dep := range crateDeps.Dependencies {
+		// Skip dev-dependencies and build-dependencies.
+		if dep.Kind == "normal" {
+			deps = append(deps, dep.CrateID)
+		}
+	}
+	sort.Strings(deps)
+	return deps
+}
+
+func info(name api.PkgName) api.PkgInfo {
+	crateInfo, ok := fetchCrateInfo(name)
+	if !ok {
+		return api.PkgInfo{}
+	}
+
+	return crateInfo.toPkgInfo(crateInfo.Crate.NewestVersion)
+}
+
+func versionInfo(name api.PkgName, version api.PkgVersion) api.PkgInfo {
+	crateInfo, ok := fetchCrateInfo(name)
+	if !ok {
+		return api.PkgInfo{}
+	}
+
+	for _, v := range crateInfo.Versions {
+		if v.Num == string(version) {
+			info := crateInfo.toPkgInfo(v.Num)
+			info.Dependencies = fetchDependencies(name, v.Num)
+			return info
+		}
+	}
+
+	return api.PkgInfo{}
 }
 
 func listSpecfile() map[api.PkgName]api.PkgSpec {
@@ -217,12 +356,15 @@ var RustBackend = api.LanguageBackend{
 	Name:             "rust",
 	Specfile:         "Cargo.toml",
 	Lockfile:         "Cargo.lock",
-	FilenamePatterns: []string{"*.rs"},
+	FilenamePatterns: rustPatterns,
+	Executables:      []string{"cargo"},
 	GetPackageDir: func() string {
 		return "target"
 	},
-	Search: search,
-	Info:   info,
+	Search:      search,
+	SearchPaged: searchPaged,
+	Info:        info,
+	VersionInfo: versionInfo,
 	Add: func(pkgs map[api.PkgName]api.PkgSpec, projectName string) {
 		if !util.Exists("Cargo.toml") {
 			util.RunCmd([]string{"cargo", "init", "."})
@@ -250,10 +392,9 @@ var RustBackend = api.LanguageBackend{
 	Install: func() {
 		// Dependencies are installed at build time
 	},
-	ListSpecfile: listSpecfile,
-	ListLockfile: listLockfile,
-	Guess: func() (map[api.PkgName]bool, bool) {
-		util.NotImplemented()
-		return nil, false
-	},
+	ListSpecfile:    listSpecfile,
+	ListLockfile:    listLockfile,
+	GuessRegexps:    rustGuessRegexps,
+	GuessExtraInput: rustGuessExtraInput,
+	GuessExplain:    rustGuessExplain,
 }
@@ -0,0 +1,106 @@
+package backends
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/replit/upm/internal/util"
+)
+
+// extraProjectFilePatterns are globs for project files which can't be
+// found from the Specfile and Lockfile fields of the language
+// backends, because the name of the file depends on the project (the
+// .NET backend works out its specfile from the current directory).
+var extraProjectFilePatterns = []string{
+	"*.csproj",
+	"*.fsproj",
+}
+
+// WorkspaceProject is a project found by FindWorkspaceProjects.
+type WorkspaceProject struct {
+	// Dir is the directory of the project, relative to the
+	// current directory. It is "." for the current directory
+	// itself.
+	Dir string `json:"dir"`
+
+	// Files are the basenames of the specfiles and lockfiles
+	// found in Dir, sorted.
+	Files []string `json:"files"`
+}
+
+// projectFilePatterns returns globs matching the basenames of the
+// specfiles and lockfiles of every language backend.
+func projectFilePatterns() []string {
+	seen := map[string]bool{}
+	patterns := []string{}
+	for _, b := range languageBackends {
+		for _, file := range []string{b.Specfile, b.Lockfile} {
+			if file == "" || seen[file] {
+				continue
+			}
+			seen[file] = true
+			patterns = append(patterns, filepath.Base(file))
+		}
+	}
+	return append(patterns, extraProjectFilePatterns...)
+}
+
+// FindWorkspaceProjects returns every directory at or below the
+// current one which contains the specfile or lockfile of some
+// language backend, in lexical order. Directories named in
+// util.IgnoredPaths are not searched. If an I/O error occurs,
+// FindWorkspaceProjects terminates the process.
+func FindWorkspaceProjects() []WorkspaceProject {
+	patterns := projectFilePatterns()
+	projects := []WorkspaceProject{}
+	filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			util.Die("%s: %s", path, err)
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if path != "." {
+			if filepath.Base(path) == ".upm" {
+				return filepath.SkipDir
+			}
+			for _, name := range util.IgnoredPaths {
+				if filepath.Base(path) == name {
+					return filepath.SkipDir
+				}
+			}
+		}
+
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			util.Die("%s: %s", path, err)
+		}
+		files := []string{}
+		for _, entry := range entries {
+			if !entry.Mode().IsRegular() {
+				continue
+			}
+			for _, pattern := range patterns {
+				matched, err := filepath.Match(pattern, entry.Name())
+				if err != nil {
+					panic(err)
+				}
+				if matched {
+					files = append(files, entry.Name())
+					break
+				}
+			}
+		}
+		if len(files) > 0 {
+			sort.Strings(files)
+			projects = append(projects, WorkspaceProject{
+				Dir:   path,
+				Files: files,
+			})
+		}
+		return nil
+	})
+	return projects
+}
@@ -4,9 +4,14 @@ package cli
 import (
 	"fmt"
 	"os"
+	"runtime"
+	"strings"
+	"time"
 
 	"github.com/replit/upm/internal/backends"
 	"github.com/replit/upm/internal/config"
+	"github.com/replit/upm/internal/store"
+	"github.com/replit/upm/internal/table"
 	"github.com/replit/upm/internal/util"
 	"github.com/spf13/cobra"
 )
@@ -25,6 +30,140 @@ func parseOutputFormat(formatStr string) outputFormat {
 	}
 }
 
+// parseLayout takes "page", "wrap", or "truncate" and returns a
+// table.Layout enum value.
+func parseLayout(layoutStr string) table.Layout {
+	switch layoutStr {
+	case "page":
+		return table.LayoutPage
+	case "wrap":
+		return table.LayoutWrap
+	case "truncate":
+		return table.LayoutTruncate
+	default:
+		util.Die(`Error: invalid layout %#v (must be "page", "wrap", or "truncate")`, layoutStr)
+		return 0
+	}
+}
+
+// parseSortSpec takes the value of the --sort option, which is a
+// column header optionally prefixed by "-" to sort in descending
+// order and optionally suffixed by ":lexical", ":numeric", or
+// ":semver" to choose how cells are compared. It returns the header
+// and a comparator implementing the requested order.
+func parseSortSpec(spec string) (string, table.Comparator) {
+	descending := strings.HasPrefix(spec, "-")
+	spec = strings.TrimPrefix(spec, "-")
+
+	header := spec
+	kind := "lexical"
+	if i := strings.LastIndex(spec, ":"); i >= 0 {
+		header = spec[:i]
+		kind = spec[i+1:]
+	}
+
+	var less table.Comparator
+	switch kind {
+	case "lexical":
+		less = table.CompareLexical
+	case "numeric":
+		less = table.CompareNumeric
+	case "semver":
+		less = table.CompareSemver
+	default:
+		util.Die(`Error: invalid sort order %#v (must be "lexical", "numeric", or "semver")`, kind)
+	}
+
+	if descending {
+		ascending := less
+		less = func(a, b string) bool {
+			return ascending(b, a)
+		}
+	}
+	return header, less
+}
+
+// addTableFlags registers the options that control table rendering
+// on a command that supports --format=table.
+func addTableFlags(cmd *cobra.Command, opts *tableOptions) {
+	cmd.Flags().StringSliceVar(
+		&opts.columns, "columns", []string{},
+		"columns to show in table output (comma-separated)",
+	)
+	cmd.Flags().StringVar(
+		&opts.sort, "sort", "",
+		`column to sort table output by, e.g. "-version:semver"`,
+	)
+	cmd.Flags().StringVar(
+		&opts.layout, "layout", "page",
+		`how to fit wide tables ("page", "wrap", or "truncate")`,
+	)
+	cmd.Flags().BoolVar(
+		&opts.noPager, "no-pager", false, "never page wide tables through less",
+	)
+	cmd.Flags().BoolVar(
+		&opts.color, "color", false, "highlight table headers (respects NO_COLOR)",
+	)
+}
+
+// addAllLanguagesFlag registers the --all-languages option on a
+// command that can operate on more than one language backend. The
+// usage defaults to a generic description if empty.
+func addAllLanguagesFlag(cmd *cobra.Command, allLanguages *bool, usage string) {
+	if usage == "" {
+		usage = "operate on every language that applies to your project"
+	}
+	cmd.Flags().BoolVar(allLanguages, "all-languages", false, usage)
+}
+
+// recursiveAnnotation is the key of the annotation marking the
+// commands which support --recursive.
+const recursiveAnnotation = "recursive"
+
+// lockAnnotation is the key of the annotation marking the commands
+// which lock the project, so that they don't run at the same time as
+// each other. Its value is lockExclusive for commands that change the
+// project or the store, and lockShared for those that only read them.
+const lockAnnotation = "lock"
+
+// Values for lockAnnotation.
+const (
+	lockExclusive = "exclusive"
+	lockShared    = "shared"
+)
+
+// lockArgs returns the arguments for running 'upm lock' with the
+// given options in each project, for --recursive.
+func lockArgs(allLanguages bool, upgrade bool, forceLock bool, forceInstall bool) []string {
+	args := []string{"lock"}
+	if allLanguages {
+		args = append(args, "--all-languages")
+	}
+	if upgrade {
+		args = append(args, "--upgrade")
+	}
+	if forceLock {
+		args = append(args, "--force-lock")
+	}
+	if forceInstall {
+		args = append(args, "--force-install")
+	}
+	return args
+}
+
+// installArgs returns the arguments for running 'upm install' with
+// the given options in each project, for --recursive.
+func installArgs(allLanguages bool, force bool) []string {
+	args := []string{"install"}
+	if allLanguages {
+		args = append(args, "--all-languages")
+	}
+	if force {
+		args = append(args, "--force")
+	}
+	return args
+}
+
 // version is set at build time to a Git tag or the string
 // "development version" when not tagging a release.
 var version = "unknown version"
@@ -46,11 +185,20 @@ func DoCLI() {
 	var forceLock bool
 	var forceInstall bool
 	var forceGuess bool
+	var minConfidence float64
 	var all bool
 	var ignoredPackages []string
 	var ignoredPaths []string
 	var upgrade bool
 	var name string
+	var tableOpts tableOptions
+	var allLanguages bool
+	var timeout time.Duration
+	var searchOpts searchOptions
+	var page int
+	var recOpts recursiveOptions
+	var explain bool
+	var lockOpts lockOptions
 
 	cobra.EnableCommandSorting = false
 
@@ -59,12 +207,25 @@ func DoCLI() {
 		Version: getVersion(),
 	}
 	rootCmd.SetVersionTemplate(`{{.Version}}` + "\n")
+	rootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		if recOpts.recursive && cmd.Annotations[recursiveAnnotation] == "" {
+			util.Die("--recursive is not supported by 'upm %s'", cmd.Name())
+		}
+		// With --recursive, each project is locked by its own
+		// child process instead.
+		if lockMode := cmd.Annotations[lockAnnotation]; lockMode != "" && !recOpts.recursive {
+			if cmd.Flags().Changed("no-wait") && (cmd.Flags().Changed("wait") || cmd.Flags().Changed("wait-timeout")) {
+				util.Die("Error: --no-wait cannot be used with --wait or --wait-timeout")
+			}
+			store.Lock(lockMode == lockExclusive, lockOpts.wait && !lockOpts.noWait, lockOpts.waitTimeout)
+		}
+	}
 	// Not sorting the root command options because none of the
 	// documented ways to disable sorting work for it (the root
 	// command itself has the options sorted correctly, but they
 	// are alphabetized in the help strings for subcommands).
 	rootCmd.PersistentFlags().StringVarP(
-		&language, "lang", "l", "", "specify project language(s) manually",
+		&language, "lang", "l", "", "specify project language(s) manually (comma-separated)",
 	)
 	rootCmd.PersistentFlags().BoolVarP(
 		&config.Quiet, "quiet", "q", false, "don't show what commands are being run",
@@ -77,6 +238,26 @@ func DoCLI() {
 		&ignoredPaths, "ignored-paths", []string{},
 		"paths to ignore when guessing (comma-separated)",
 	)
+	rootCmd.PersistentFlags().BoolVarP(
+		&recOpts.recursive, "recursive", "r", false,
+		"run the command in every project below the current directory",
+	)
+	rootCmd.PersistentFlags().IntVar(
+		&recOpts.jobs, "jobs", runtime.NumCPU(),
+		"maximum number of projects to run at once with --recursive",
+	)
+	rootCmd.PersistentFlags().BoolVar(
+		&lockOpts.wait, "wait", true,
+		"wait for other upm processes using the project to finish (default)",
+	)
+	rootCmd.PersistentFlags().BoolVar(
+		&lockOpts.noWait, "no-wait", false,
+		"fail if another upm process is using the project",
+	)
+	rootCmd.PersistentFlags().DurationVar(
+		&lockOpts.waitTimeout, "wait-timeout", 0,
+		"fail if another upm process is still using the project after this long (e.g. 30s)",
+	)
 	rootCmd.PersistentFlags().BoolP(
 		"help", "h", false, "display command-line usage",
 	)
@@ -90,9 +271,14 @@ func DoCLI() {
 		Long:  "Ask which language your project is autodetected as",
 		Args:  cobra.NoArgs,
 		Run: func(cmd *cobra.Command, args []string) {
-			runWhichLanguage(language)
+			runWhichLanguage(language, allLanguages, explain)
 		},
 	}
+	cmdWhichLanguage.Flags().SortFlags = false
+	cmdWhichLanguage.Flags().BoolVar(
+		&explain, "explain", false, "show the evidence for each language",
+	)
+	addAllLanguagesFlag(cmdWhichLanguage, &allLanguages, "list every language that applies to your project")
 	rootCmd.AddCommand(cmdWhichLanguage)
 
 	cmdListLanguages := &cobra.Command{
@@ -112,19 +298,61 @@ func DoCLI() {
 		Run: func(cmd *cobra.Command, args []string) {
 			queries := args
 			outputFormat := parseOutputFormat(formatStr)
-			runSearch(language, queries, outputFormat)
+			if searchOpts.limit <= 0 {
+				util.Die("Error: --limit must be positive")
+			}
+			if cmd.Flags().Changed("page") {
+				if cmd.Flags().Changed("offset") {
+					util.Die("Error: --page and --offset cannot be used together")
+				}
+				if page <= 0 {
+					util.Die("Error: --page must be positive")
+				}
+				searchOpts.offset = (page - 1) * searchOpts.limit
+			}
+			if searchOpts.offset < 0 {
+				util.Die("Error: --offset must not be negative")
+			}
+			if allLanguages {
+				runSearchAllLanguages(queries, outputFormat, tableOpts, searchOpts, timeout)
+			} else {
+				runSearch(language, queries, outputFormat, tableOpts, searchOpts)
+			}
 		},
 	}
 	cmdSearch.Flags().SortFlags = false
 	cmdSearch.Flags().StringVarP(
 		&formatStr, "format", "f", "table", `output format ("table" or "json")`,
 	)
+	cmdSearch.Flags().IntVar(
+		&searchOpts.limit, "limit", 20, "maximum number of results (per language with --all-languages)",
+	)
+	cmdSearch.Flags().IntVar(
+		&searchOpts.offset, "offset", 0, "number of results to skip",
+	)
+	cmdSearch.Flags().IntVar(
+		&page, "page", 1, "page of results to show, counting from 1 (pages are --limit results long)",
+	)
+	cmdSearch.Flags().StringVar(
+		&searchOpts.license, "license", "", "only show packages whose license contains this string, where known",
+	)
+	cmdSearch.Flags().IntVar(
+		&searchOpts.minDownloads, "min-downloads", 0, "only show packages with at least this many downloads, where known",
+	)
+	cmdSearch.Flags().BoolVar(
+		&searchOpts.excludeDeprecated, "exclude-deprecated", false, "hide deprecated and yanked packages",
+	)
+	addAllLanguagesFlag(cmdSearch, &allLanguages, "search the package index of every language")
+	cmdSearch.Flags().DurationVar(
+		&timeout, "timeout", 30*time.Second, "give up on an index after this long (with --all-languages)",
+	)
+	addTableFlags(cmdSearch, &tableOpts)
 	rootCmd.AddCommand(cmdSearch)
 
 	var cmdInfo *cobra.Command
 	cmdInfo = &cobra.Command{
 		Aliases: []string{"show"},
-		Use:     "info PACKAGE",
+		Use:     "info PACKAGE[@VERSION]",
 		Short:   "Show package information from online registry",
 		Args:    cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
@@ -139,14 +367,32 @@ func DoCLI() {
 	)
 	rootCmd.AddCommand(cmdInfo)
 
+	cmdVersions := &cobra.Command{
+		Use:   "versions PACKAGE",
+		Short: "List published versions of a package from online registry",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			pkg := args[0]
+			outputFormat := parseOutputFormat(formatStr)
+			runVersions(language, pkg, outputFormat, tableOpts)
+		},
+	}
+	cmdVersions.Flags().SortFlags = false
+	cmdVersions.Flags().StringVarP(
+		&formatStr, "format", "f", "table", `output format ("table" or "json")`,
+	)
+	addTableFlags(cmdVersions, &tableOpts)
+	rootCmd.AddCommand(cmdVersions)
+
 	cmdAdd := &cobra.Command{
 		Use:   `add "PACKAGE[ SPEC]"...`,
 		Short: "Add packages to the specfile",
 		Run: func(cmd *cobra.Command, args []string) {
 			pkgSpecStrs := args
 			runAdd(language, pkgSpecStrs, upgrade, guess, forceGuess,
-				ignoredPackages, forceLock, forceInstall, name)
+				ignoredPackages, minConfidence, forceLock, forceInstall, name)
 		},
+		Annotations: map[string]string{lockAnnotation: lockExclusive},
 	}
 	cmdAdd.Flags().SortFlags = false
 	cmdAdd.Flags().BoolVarP(
@@ -164,6 +410,9 @@ func DoCLI() {
 	cmdAdd.Flags().BoolVar(
 		&forceGuess, "force-guess", false, "bypass cache when guessing dependencies",
 	)
+	cmdAdd.Flags().Float64Var(
+		&minConfidence, "min-confidence", 0, "only add guessed packages at least this certain (0 to 1)",
+	)
 	cmdAdd.Flags().StringVarP(
 		&name, "name", "n", "", "specify project name",
 	)
@@ -177,6 +426,7 @@ func DoCLI() {
 			pkgs := args
 			runRemove(language, pkgs, upgrade, forceLock, forceInstall)
 		},
+		Annotations: map[string]string{lockAnnotation: lockExclusive},
 	}
 	cmdRemove.Flags().SortFlags = false
 	cmdRemove.Flags().BoolVarP(
@@ -202,7 +452,15 @@ func DoCLI() {
 					upgrade = true
 				}
 			}
-			runLock(language, upgrade, forceLock, forceInstall)
+			if recOpts.recursive {
+				runRecursive(language, recOpts, lockArgs(allLanguages, upgrade, forceLock, forceInstall))
+				return
+			}
+			runLock(language, allLanguages, upgrade, forceLock, forceInstall)
+		},
+		Annotations: map[string]string{
+			recursiveAnnotation: "true",
+			lockAnnotation:      lockExclusive,
 		},
 	}
 	cmdLock.Flags().SortFlags = false
@@ -215,6 +473,7 @@ func DoCLI() {
 	cmdLock.Flags().BoolVarP(
 		&forceInstall, "force-install", "F", false, "reinstall packages even if up to date",
 	)
+	addAllLanguagesFlag(cmdLock, &allLanguages, "")
 	rootCmd.AddCommand(cmdLock)
 
 	cmdInstall := &cobra.Command{
@@ -222,13 +481,22 @@ func DoCLI() {
 		Short: "Install packages from the lockfile",
 		Args:  cobra.NoArgs,
 		Run: func(cmd *cobra.Command, args []string) {
-			runInstall(language, forceInstall)
+			if recOpts.recursive {
+				runRecursive(language, recOpts, installArgs(allLanguages, forceInstall))
+				return
+			}
+			runInstall(language, allLanguages, forceInstall)
+		},
+		Annotations: map[string]string{
+			recursiveAnnotation: "true",
+			lockAnnotation:      lockExclusive,
 		},
 	}
 	cmdInstall.Flags().SortFlags = false
 	cmdInstall.Flags().BoolVarP(
 		&forceInstall, "force", "F", false, "reinstall packages even if up to date",
 	)
+	addAllLanguagesFlag(cmdInstall, &allLanguages, "")
 	rootCmd.AddCommand(cmdInstall)
 
 	cmdList := &cobra.Command{
@@ -238,8 +506,9 @@ func DoCLI() {
 		Args:  cobra.NoArgs,
 		Run: func(cmd *cobra.Command, args []string) {
 			outputFormat := parseOutputFormat(formatStr)
-			runList(language, all, outputFormat)
+			runList(language, allLanguages, all, outputFormat, tableOpts)
 		},
+		Annotations: map[string]string{lockAnnotation: lockShared},
 	}
 	cmdInstall.Flags().SortFlags = false
 	cmdList.Flags().BoolVarP(
@@ -248,16 +517,39 @@ func DoCLI() {
 	cmdList.Flags().StringVarP(
 		&formatStr, "format", "f", "table", `output format ("table" or "json")`,
 	)
+	addAllLanguagesFlag(cmdList, &allLanguages, "")
+	addTableFlags(cmdList, &tableOpts)
 	rootCmd.AddCommand(cmdList)
 
+	cmdStatus := &cobra.Command{
+		Use:   "status",
+		Short: "Show whether the lockfile and installed packages are up to date",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			outputFormat := parseOutputFormat(formatStr)
+			runStatus(language, allLanguages, outputFormat, tableOpts)
+		},
+		Annotations: map[string]string{lockAnnotation: lockShared},
+	}
+	cmdStatus.Flags().SortFlags = false
+	cmdStatus.Flags().StringVarP(
+		&formatStr, "format", "f", "table", `output format ("table" or "json")`,
+	)
+	addAllLanguagesFlag(cmdStatus, &allLanguages, "")
+	addTableFlags(cmdStatus, &tableOpts)
+	rootCmd.AddCommand(cmdStatus)
+
 	cmdGuess := &cobra.Command{
 		Use:   "guess",
 		Short: "Guess what packages are needed by your project",
 		Args:  cobra.NoArgs,
 		Run: func(cmd *cobra.Command, args []string) {
 			util.AddIngoredPaths(ignoredPaths)
-			runGuess(language, all, forceGuess, ignoredPackages)
+			outputFormat := parseOutputFormat(formatStr)
+			runGuess(language, allLanguages, all, forceGuess, ignoredPackages,
+				explain, outputFormat, tableOpts)
 		},
+		Annotations: map[string]string{lockAnnotation: lockExclusive},
 	}
 	cmdGuess.Flags().SortFlags = false
 	cmdGuess.Flags().BoolVarP(
@@ -266,6 +558,14 @@ func DoCLI() {
 	cmdGuess.Flags().BoolVarP(
 		&forceGuess, "force", "f", false, "bypass cache",
 	)
+	cmdGuess.Flags().BoolVar(
+		&explain, "explain", false, "show the file, line and module behind each package",
+	)
+	cmdGuess.Flags().StringVar(
+		&formatStr, "format", "table", `output format for --explain ("table" or "json")`,
+	)
+	addAllLanguagesFlag(cmdGuess, &allLanguages, "")
+	addTableFlags(cmdGuess, &tableOpts)
 	rootCmd.AddCommand(cmdGuess)
 
 	cmdShowSpecfile := &cobra.Command{
@@ -298,6 +598,29 @@ func DoCLI() {
 	}
 	rootCmd.AddCommand(cmdShowPackageDir)
 
+	cmdWorkspace := &cobra.Command{
+		Use:   "workspace",
+		Short: "Work with the projects in a monorepo",
+		Args:  cobra.NoArgs,
+	}
+	rootCmd.AddCommand(cmdWorkspace)
+
+	cmdWorkspaceList := &cobra.Command{
+		Use:   "list",
+		Short: "List the projects below the current directory",
+		Long:  "List every directory below the current one that has a specfile or lockfile",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			outputFormat := parseOutputFormat(formatStr)
+			runWorkspaceList(outputFormat, tableOpts)
+		},
+	}
+	cmdWorkspaceList.Flags().StringVarP(
+		&formatStr, "format", "f", "table", `output format ("table" or "json")`,
+	)
+	addTableFlags(cmdWorkspaceList, &tableOpts)
+	cmdWorkspace.AddCommand(cmdWorkspaceList)
+
 	specialArgs := map[string](func()){}
 	for _, helpFlag := range []string{"-help", "-?"} {
 		specialArgs[helpFlag] = func() {
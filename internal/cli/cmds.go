@@ -1,12 +1,19 @@
 package cli
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/replit/upm/internal/api"
 	"github.com/replit/upm/internal/backends"
@@ -38,10 +45,80 @@ func (s *subroutineSilencer) restore() {
 	config.Quiet = s.origQuiet
 }
 
+// printTable applies the --columns and --sort options to a table and
+// then prints it according to the remaining table options. If --sort
+// was not given, the table is left in its existing order.
+func printTable(t table.Table, opts tableOptions) {
+	if len(opts.columns) > 0 {
+		for _, column := range opts.columns {
+			if !t.HasColumn(column) {
+				util.Die(
+					"no such column: %s (available: %s)",
+					column, strings.Join(t.Headers(), ", "),
+				)
+			}
+		}
+		t.SelectColumns(opts.columns...)
+	}
+
+	if opts.sort != "" {
+		header, less := parseSortSpec(opts.sort)
+		if !t.HasColumn(header) {
+			util.Die(
+				"no such column: %s (available: %s)",
+				header, strings.Join(t.Headers(), ", "),
+			)
+		}
+		t.SortByFunc(header, less)
+	}
+
+	t.PrintWith(table.PrintOptions{
+		Layout:  parseLayout(opts.layout),
+		NoPager: opts.noPager,
+		Color:   opts.color,
+	})
+}
+
 // runWhichLanguage implements 'upm which-language'.
-func runWhichLanguage(language string) {
-	b := backends.GetBackend(language)
-	fmt.Println(b.Name)
+func runWhichLanguage(language string, allLanguages bool, explain bool) {
+	if explain {
+		explainDetection(language)
+	}
+	for _, b := range backends.GetBackends(language, allLanguages) {
+		fmt.Println(b.Name)
+	}
+}
+
+// explainDetection implements 'upm which-language --explain', by
+// printing the score of each language backend that applies to the
+// project along with the evidence for it. The backends that are
+// actually chosen are printed afterwards by runWhichLanguage.
+func explainDetection(language string) {
+	detections := backends.ExplainDetection(language)
+	if len(detections) == 0 {
+		fmt.Println("no evidence for any language")
+		fmt.Println()
+		return
+	}
+	for _, d := range detections {
+		fmt.Printf("%s: %d\n", d.Backend.Name, d.Score)
+		for _, e := range d.Evidence {
+			fmt.Printf("  %+3d  %s\n", e.Score, e.Description)
+		}
+		fmt.Println()
+	}
+}
+
+// forEachBackend calls f for each of the given language backends. If
+// there is more than one, the name of each backend is logged before
+// calling f for it, so that the output can be told apart.
+func forEachBackend(bs []api.LanguageBackend, f func(b api.LanguageBackend)) {
+	for _, b := range bs {
+		if len(bs) > 1 {
+			util.Log("==> " + b.Name)
+		}
+		f(b)
+	}
 }
 
 // runListLanguages implements 'upm list-languages'.
@@ -51,8 +128,125 @@ func runListLanguages() {
 	}
 }
 
+// filtered returns true if any of the options that filter search
+// results were given.
+func (opts searchOptions) filtered() bool {
+	return opts.license != "" || opts.minDownloads > 0 || opts.excludeDeprecated
+}
+
+// matches returns true if the package passes the filters given in
+// the options. Not every index reports licenses or download counts,
+// so a filter is skipped for packages where the value is unknown.
+func (opts searchOptions) matches(pkg api.PkgInfo) bool {
+	if opts.license != "" && pkg.License != "" && !strings.Contains(
+		strings.ToLower(pkg.License), strings.ToLower(opts.license),
+	) {
+		return false
+	}
+	if pkg.Downloads > 0 && pkg.Downloads < opts.minDownloads {
+		return false
+	}
+	if opts.excludeDeprecated && (pkg.Deprecated || pkg.Yanked) {
+		return false
+	}
+	return true
+}
+
+// warnUnsupported warns about the filters given in the options that
+// had no effect on the search results from a backend, because none of
+// the packages had the value they filter on.
+func (opts searchOptions) warnUnsupported(b api.LanguageBackend, pkgs []api.PkgInfo) {
+	if len(pkgs) == 0 {
+		return
+	}
+	hasLicense, hasDownloads := false, false
+	for _, pkg := range pkgs {
+		hasLicense = hasLicense || pkg.License != ""
+		hasDownloads = hasDownloads || pkg.Downloads > 0
+	}
+	if opts.license != "" && !hasLicense {
+		util.Log(fmt.Sprintf("%s: search results have no licenses, so --license was ignored", b.Name))
+	}
+	if opts.minDownloads > 0 && !hasDownloads {
+		util.Log(fmt.Sprintf("%s: search results have no download counts, so --min-downloads was ignored", b.Name))
+	}
+}
+
+// args returns command-line arguments for 'upm search' that
+// reproduce the options.
+func (opts searchOptions) args() []string {
+	args := []string{
+		"--limit=" + strconv.Itoa(opts.limit),
+		"--offset=" + strconv.Itoa(opts.offset),
+	}
+	if opts.license != "" {
+		args = append(args, "--license="+opts.license)
+	}
+	if opts.minDownloads > 0 {
+		args = append(args, "--min-downloads="+strconv.Itoa(opts.minDownloads))
+	}
+	if opts.excludeDeprecated {
+		args = append(args, "--exclude-deprecated")
+	}
+	return args
+}
+
+// searchPageSize is the number of results requested at a time when
+// looking for results that pass the search filters.
+const searchPageSize = 50
+
+// maxFilteredResults is the most results that will be looked through
+// to find ones that pass the search filters, to avoid paging through
+// an entire index.
+const maxFilteredResults = 500
+
+// search returns the results of searching the backend's index for
+// the query, filtered and paged according to the options. Backends
+// that support paging are asked for only as many results as needed.
+func search(b api.LanguageBackend, query string, opts searchOptions) []api.PkgInfo {
+	if b.SearchPaged != nil && !opts.filtered() {
+		return b.SearchPaged(query, opts.offset, opts.limit)
+	}
+
+	results := []api.PkgInfo{}
+	seen := []api.PkgInfo{}
+	if b.SearchPaged != nil {
+		// The index can't filter for us, so keep fetching
+		// until we have enough results that pass.
+		for offset := 0; len(results) < opts.offset+opts.limit && offset < maxFilteredResults; offset += searchPageSize {
+			page := b.SearchPaged(query, offset, searchPageSize)
+			seen = append(seen, page...)
+			for _, pkg := range page {
+				if opts.matches(pkg) {
+					results = append(results, pkg)
+				}
+			}
+			if len(page) < searchPageSize {
+				break
+			}
+		}
+	} else {
+		seen = b.Search(query)
+		for _, pkg := range seen {
+			if opts.matches(pkg) {
+				results = append(results, pkg)
+			}
+		}
+	}
+	opts.warnUnsupported(b, seen)
+
+	if opts.offset >= len(results) {
+		return []api.PkgInfo{}
+	}
+	results = results[opts.offset:]
+	if len(results) > opts.limit {
+		results = results[:opts.limit]
+	}
+	return results
+}
+
 // runSearch implements 'upm search'.
-func runSearch(language string, args []string, outputFormat outputFormat) {
+func runSearch(language string, args []string, outputFormat outputFormat, tableOpts tableOptions, searchOpts searchOptions) {
 	query := strings.Join(args, " ")
 	b := backends.GetBackend(language)
 
@@ -60,12 +254,94 @@ func runSearch(language string, args []string, outputFormat outputFormat) {
 	if strings.TrimSpace(query) == "" {
 		results = []api.PkgInfo{}
 	} else {
-		results = b.Search(query)
+		results = search(b, query, searchOpts)
 	}
 
-	// Output a reasonable number of results.
-	if len(results) > 20 {
-		results = results[:20]
+	switch outputFormat {
+	case outputFormatTable:
+		if len(results) == 0 {
+			util.Log("no search results")
+			return
+		}
+		t := table.FromStructs(results)
+		printTable(t, tableOpts)
+
+	case outputFormatJSON:
+		outputB, err := json.Marshal(results)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println(string(outputB))
+	}
+}
+
+// searchBackend runs 'upm search' for one language backend in a
+// child process, so that failures (which exit the process) don't
+// affect any other searches. It returns false, after reporting the
+// problem, if the search failed or took longer than timeout.
+func searchBackend(b api.LanguageBackend, args []string, searchOpts searchOptions, timeout time.Duration) ([]api.PkgInfo, bool) {
+	exe, err := os.Executable()
+	if err != nil {
+		util.Die("%s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmdArgs := []string{"--lang", b.Name, "--quiet", "search", "--format=json"}
+	cmdArgs = append(cmdArgs, searchOpts.args()...)
+	cmdArgs = append(cmdArgs, "--")
+	cmd := exec.CommandContext(ctx, exe, append(cmdArgs, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	outputB, err := cmd.Output()
+
+	if ctx.Err() == context.DeadlineExceeded {
+		util.Log(fmt.Sprintf("%s: search timed out after %s", b.Name, timeout))
+		return nil, false
+	}
+	if err != nil {
+		// Only the first line, so we don't print a whole
+		// stack trace if the child panicked.
+		msg := strings.SplitN(strings.TrimSpace(stderr.String()), "\n", 2)[0]
+		if msg == "" {
+			msg = err.Error()
+		}
+		util.Log(fmt.Sprintf("%s: search failed: %s", b.Name, msg))
+		return nil, false
+	}
+
+	var results []api.PkgInfo
+	if err := json.Unmarshal(outputB, &results); err != nil {
+		util.Log(fmt.Sprintf("%s: search failed: %s", b.Name, err))
+		return nil, false
+	}
+	return results, true
+}
+
+// runSearchAllLanguages implements 'upm search --all-languages'. The
+// package index of every language is searched concurrently, and the
+// results are listed in the same order as 'upm list-languages'.
+// Indices that fail are reported and skipped.
+func runSearchAllLanguages(args []string, outputFormat outputFormat, tableOpts tableOptions, searchOpts searchOptions, timeout time.Duration) {
+	bs := backends.GetRegistryBackends()
+	resultsByBackend := make([][]api.PkgInfo, len(bs))
+
+	var wg sync.WaitGroup
+	for i := range bs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resultsByBackend[i], _ = searchBackend(bs[i], args, searchOpts, timeout)
+		}(i)
+	}
+	wg.Wait()
+
+	results := []searchResult{}
+	for i, b := range bs {
+		for _, pkg := range resultsByBackend[i] {
+			results = append(results, searchResult{Backend: b.Name, PkgInfo: pkg})
+		}
 	}
 
 	switch outputFormat {
@@ -75,7 +351,7 @@ func runSearch(language string, args []string, outputFormat outputFormat) {
 			return
 		}
 		t := table.FromStructs(results)
-		t.Print()
+		printTable(t, tableOpts)
 
 	case outputFormatJSON:
 		outputB, err := json.Marshal(results)
@@ -95,9 +371,25 @@ type infoLine struct {
 // runInfo implements 'upm info'.
 func runInfo(language string, pkg string, outputFormat outputFormat) {
 	b := backends.GetBackend(language)
-	info := b.Info(api.PkgName(pkg))
-	if info.Name == "" {
-		util.Die("no such package: %s", pkg)
+
+	var info api.PkgInfo
+	if name, version, ok := splitPkgVersion(pkg); ok {
+		if b.VersionInfo == nil {
+			util.Die(
+				"language backend %s does not support "+
+					"looking up specific versions",
+				b.Name,
+			)
+		}
+		info = b.VersionInfo(name, version)
+		if info.Name == "" {
+			util.Die("no such package version: %s", pkg)
+		}
+	} else {
+		info = b.Info(api.PkgName(pkg))
+		if info.Name == "" {
+			util.Die("no such package: %s", pkg)
+		}
 	}
 
 	switch outputFormat {
@@ -107,19 +399,7 @@ func runInfo(language string, pkg string, outputFormat outputFormat) {
 		rows := []infoLine{}
 		for i := 0; i < infoT.NumField(); i++ {
 			field := infoT.Field(i).Tag.Get("pretty")
-			var value string
-			switch infoV.Field(i).Kind() {
-			case reflect.String:
-				value = infoV.Field(i).String()
-			case reflect.Slice:
-				parts := []string{}
-				length := infoV.Field(i).Len()
-				for j := 0; j < length; j++ {
-					str := infoV.Field(i).Index(j).String()
-					parts = append(parts, str)
-				}
-				value = strings.Join(parts, ", ")
-			}
+			value := table.FormatValue(infoV.Field(i))
 			if value == "" {
 				continue
 			}
@@ -156,6 +436,47 @@ func runInfo(language string, pkg string, outputFormat outputFormat) {
 	}
 }
 
+// splitPkgVersion splits an argument of the form NAME@VERSION, as
+// accepted by 'upm info'. A leading @ is part of the name, since NPM
+// uses it for scoped packages, so "@babel/core@7.0.0" splits into
+// "@babel/core" and "7.0.0". The last return value is false if there
+// is no version.
+func splitPkgVersion(arg string) (api.PkgName, api.PkgVersion, bool) {
+	i := strings.LastIndex(arg, "@")
+	if i <= 0 || i == len(arg)-1 {
+		return api.PkgName(arg), "", false
+	}
+	return api.PkgName(arg[:i]), api.PkgVersion(arg[i+1:]), true
+}
+
+// runVersions implements 'upm versions'.
+func runVersions(language string, pkg string, outputFormat outputFormat, tableOpts tableOptions) {
+	b := backends.GetBackend(language)
+	info := b.Info(api.PkgName(pkg))
+	if info.Name == "" {
+		util.Die("no such package: %s", pkg)
+	}
+	if len(info.Versions) == 0 {
+		util.Die(
+			"language backend %s does not provide versions for %s",
+			b.Name, info.Name,
+		)
+	}
+
+	switch outputFormat {
+	case outputFormatTable:
+		t := table.FromStructs(info.Versions)
+		printTable(t, tableOpts)
+
+	case outputFormatJSON:
+		outputB, err := json.Marshal(info.Versions)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println(string(outputB))
+	}
+}
+
 // deleteLockfile deletes the project's lockfile, if one exists.
 func deleteLockfile(b api.LanguageBackend) {
 	if util.Exists(b.Lockfile) {
@@ -190,19 +511,30 @@ func maybeInstall(b api.LanguageBackend, forceInstall bool) {
 		if !util.Exists(b.Lockfile) {
 			return
 		}
-		if forceInstall || store.HasLockfileChanged(b) {
+		if forceInstall || store.HasLockfileChanged(b) || hasPackageDirDrifted(b) {
 			b.Install()
 		}
 	} else {
 		if !util.Exists(b.Specfile) {
 			return
 		}
-		if forceInstall || store.HasSpecfileChanged(b) {
+		if forceInstall || store.HasSpecfileChanged(b) || hasPackageDirDrifted(b) {
 			b.Install()
 		}
 	}
 }
 
+// hasPackageDirDrifted returns true, after saying so, if the
+// installed packages have changed since the last install, as by
+// store.HasPackageDirChanged.
+func hasPackageDirDrifted(b api.LanguageBackend) bool {
+	if !store.HasPackageDirChanged(b) {
+		return false
+	}
+	util.Log(store.PackageDir(b) + " has changed since packages were last installed")
+	return true
+}
+
 // pkgNameAndSpec is a tuple of a PkgName and a PkgSpec. It's used to
 // put both of them as a value in the same map entry.
 type pkgNameAndSpec struct {
@@ -214,8 +546,16 @@ type pkgNameAndSpec struct {
 func runAdd(
 	language string, args []string, upgrade bool,
 	guess bool, forceGuess bool, ignoredPackages []string,
+	minConfidence float64,
 	forceLock bool, forceInstall bool, name string) {
 
+	if minConfidence < 0 || minConfidence > 1 {
+		util.Die("--min-confidence must be between 0 and 1")
+	}
+	if minConfidence > 0 && !guess {
+		util.Die("--min-confidence only makes sense with --guess")
+	}
+
 	b := backends.GetBackend(language)
 
 	// Map from normalized package names to the corresponding
@@ -236,7 +576,13 @@ func runAdd(
 	}
 
 	if guess {
-		guessed := store.GuessWithCache(b, forceGuess)
+		var guessed map[api.PkgName]bool
+		var reasons []api.GuessReason
+		if minConfidence > 0 {
+			guessed, reasons = guessWithReasons(b, forceGuess)
+		} else {
+			guessed = store.GuessWithCache(b, forceGuess)
+		}
 
 		// Map from normalized package names to original
 		// names.
@@ -249,9 +595,20 @@ func runAdd(
 			delete(guessedNorm, b.NormalizePackageName(api.PkgName(pkg)))
 		}
 
-		for name, _ := range guessed {
-			if _, ok := normPkgs[b.NormalizePackageName(name)]; !ok {
-				normPkgs[b.NormalizePackageName(name)] = pkgNameAndSpec{
+		if minConfidence > 0 {
+			ambiguous := []api.GuessReason{}
+			for norm, reason := range bestGuessReasons(b, reasons) {
+				if _, ok := guessedNorm[norm]; ok && float64(reason.Confidence) < minConfidence {
+					ambiguous = append(ambiguous, reason)
+					delete(guessedNorm, norm)
+				}
+			}
+			logAmbiguousGuesses(ambiguous, minConfidence)
+		}
+
+		for norm, name := range guessedNorm {
+			if _, ok := normPkgs[norm]; !ok {
+				normPkgs[norm] = pkgNameAndSpec{
 					name: name,
 					spec: "",
 				}
@@ -294,6 +651,47 @@ func runAdd(
 	store.Write()
 }
 
+// bestGuessReasons returns the most confident of the given reasons
+// for each package guessed by a language backend, by normalized
+// package name.
+func bestGuessReasons(b api.LanguageBackend, reasons []api.GuessReason) map[api.PkgName]api.GuessReason {
+	best := map[api.PkgName]api.GuessReason{}
+	for _, reason := range reasons {
+		norm := b.NormalizePackageName(reason.Package)
+		if cur, ok := best[norm]; !ok || reason.Confidence > cur.Confidence {
+			best[norm] = reason
+		}
+	}
+	return best
+}
+
+// logAmbiguousGuesses tells the user about guessed packages that
+// weren't added by 'upm add --guess' because they are below the
+// --min-confidence threshold, together with the alternatives, so
+// that the user can add the right one.
+func logAmbiguousGuesses(ambiguous []api.GuessReason, minConfidence float64) {
+	if len(ambiguous) == 0 {
+		return
+	}
+	sort.Slice(ambiguous, func(i, j int) bool {
+		return ambiguous[i].Package < ambiguous[j].Package
+	})
+	util.Log(fmt.Sprintf(
+		"not adding guessed packages with confidence below %s:",
+		api.Confidence(minConfidence),
+	))
+	for _, reason := range ambiguous {
+		msg := fmt.Sprintf(
+			"  %s for %s (confidence %s)",
+			reason.Package, reason.Module, reason.Confidence,
+		)
+		if len(reason.Alternatives) > 0 {
+			msg += ", or: " + strings.Join(reason.Alternatives, ", ")
+		}
+		util.Log(msg)
+	}
+}
+
 // runRemove implements 'upm remove'.
 func runRemove(language string, args []string, upgrade bool,
 	forceLock bool, forceInstall bool) {
@@ -352,140 +750,252 @@ func runRemove(language string, args []string, upgrade bool,
 }
 
 // runLock implements 'upm lock'.
-func runLock(language string, upgrade bool, forceLock bool, forceInstall bool) {
-	b := backends.GetBackend(language)
+func runLock(language string, allLanguages bool, upgrade bool, forceLock bool, forceInstall bool) {
+	forEachBackend(backends.GetBackends(language, allLanguages), func(b api.LanguageBackend) {
+		if upgrade {
+			deleteLockfile(b)
+		}
 
-	if upgrade {
-		deleteLockfile(b)
-	}
+		didLock := maybeLock(b, forceLock)
 
-	didLock := maybeLock(b, forceLock)
+		if !(didLock && b.QuirksDoesLockAlsoInstall()) {
+			maybeInstall(b, forceInstall)
+		}
 
-	if !(didLock && b.QuirksDoesLockAlsoInstall()) {
-		maybeInstall(b, forceInstall)
-	}
+		store.UpdateFileHashes(b)
+	})
 
-	store.UpdateFileHashes(b)
 	store.Write()
 }
 
 // runInstall implements 'upm install'.
-func runInstall(language string, force bool) {
-	b := backends.GetBackend(language)
+func runInstall(language string, allLanguages bool, force bool) {
+	forEachBackend(backends.GetBackends(language, allLanguages), func(b api.LanguageBackend) {
+		maybeInstall(b, force)
 
-	maybeInstall(b, force)
+		store.UpdateFileHashes(b)
+	})
 
-	store.UpdateFileHashes(b)
 	store.Write()
 }
 
+// fileStatus returns the status of a specfile or lockfile for 'upm
+// status', given whether it has changed according to the store and
+// what it will next be used for.
+func fileStatus(filename string, changed bool, nextStep string) string {
+	switch {
+	case !util.Exists(filename):
+		return "missing"
+	case changed:
+		return "changed since last " + nextStep
+	default:
+		return "up to date"
+	}
+}
+
+// packageDirStatus returns the status of the installed packages for
+// 'upm status'.
+func packageDirStatus(b api.LanguageBackend) string {
+	switch {
+	case b.PackageDirMarkers == nil:
+		return "not checked"
+	case !util.Exists(store.PackageDir(b)):
+		return "missing"
+	case store.HasPackageDirChanged(b):
+		return "changed since last install"
+	default:
+		return "up to date"
+	}
+}
+
+// runStatus implements 'upm status'.
+func runStatus(language string, allLanguages bool, outputFormat outputFormat, tableOpts tableOptions) {
+	bs := backends.GetBackends(language, allLanguages)
+	entries := []statusEntry{}
+	for _, b := range bs {
+		tag := ""
+		if len(bs) > 1 {
+			tag = b.Name
+		}
+		entries = append(entries, statusEntry{
+			Backend: tag,
+			Kind:    "specfile",
+			Path:    b.Specfile,
+			Status:  fileStatus(b.Specfile, store.HasSpecfileChanged(b), "lock"),
+		})
+		if b.Lockfile != b.Specfile {
+			entries = append(entries, statusEntry{
+				Backend: tag,
+				Kind:    "lockfile",
+				Path:    b.Lockfile,
+				Status:  fileStatus(b.Lockfile, store.HasLockfileChanged(b), "install"),
+			})
+		}
+		entries = append(entries, statusEntry{
+			Backend: tag,
+			Kind:    "packages",
+			Path:    store.PackageDir(b),
+			Status:  packageDirStatus(b),
+		})
+	}
+
+	switch outputFormat {
+	case outputFormatTable:
+		t := table.FromStructs(entries)
+		printTable(t, tableOpts)
+
+	case outputFormatJSON:
+		outputB, err := json.Marshal(entries)
+		if err != nil {
+			panic("couldn't marshal json")
+		}
+		fmt.Println(string(outputB))
+
+	default:
+		util.Panicf("unknown output format %d", outputFormat)
+	}
+}
+
 // listSpecfileJSONEntry represents one entry in the JSON list emitted
-// by 'upm list'.
+// by 'upm list'. The backend is only given if there is more than one.
 type listSpecfileJSONEntry struct {
-	Name string `json:"name"`
-	Spec string `json:"spec"`
+	Backend string `json:"backend,omitempty"`
+	Name    string `json:"name"`
+	Spec    string `json:"spec"`
 }
 
 // listLockfileJSONEntry represents one entry in the JSON list emitted
-// by 'upm list -a'.
+// by 'upm list -a'. The backend is only given if there is more than
+// one.
 type listLockfileJSONEntry struct {
+	Backend string `json:"backend,omitempty"`
 	Name    string `json:"name"`
 	Version string `json:"version"`
 }
 
 // runList implements 'upm list'.
-func runList(language string, all bool, outputFormat outputFormat) {
-	b := backends.GetBackend(language)
-	if !all {
-		var results map[api.PkgName]api.PkgSpec = nil
-		fileExists := util.Exists(b.Specfile)
-		if fileExists {
-			results = b.ListSpecfile()
-		}
-		switch outputFormat {
-		case outputFormatTable:
-			switch {
-			case !fileExists:
-				util.Log("no specfile")
-				return
-			case len(results) == 0:
-				util.Log("no packages in specfile")
-				return
-			}
-			t := table.New("name", "spec")
-			for name, spec := range results {
-				t.AddRow(string(name), string(spec))
+func runList(language string, allLanguages bool, all bool, outputFormat outputFormat, tableOpts tableOptions) {
+	bs := backends.GetBackends(language, allLanguages)
+	multi := len(bs) > 1
+
+	// logMissing reports a missing or empty specfile or
+	// lockfile, for table output only.
+	logMissing := func(b api.LanguageBackend, msg string) {
+		if outputFormat != outputFormatTable {
+			return
+		}
+		if multi {
+			msg = b.Name + ": " + msg
+		}
+		util.Log(msg)
+	}
+
+	headers := []string{"name", "spec"}
+	if all {
+		headers = []string{"name", "version"}
+	}
+	if multi {
+		headers = append([]string{"backend"}, headers...)
+	}
+	t := table.New(headers...)
+	numRows := 0
+	specEntries := []listSpecfileJSONEntry{}
+	lockEntries := []listLockfileJSONEntry{}
+
+	for _, b := range bs {
+		tag := ""
+		if multi {
+			tag = b.Name
+		}
+		addRow := func(cells ...string) {
+			if multi {
+				cells = append([]string{tag}, cells...)
 			}
-			t.SortBy("name")
-			t.Print()
+			t.AddRow(cells...)
+			numRows++
+		}
 
-		case outputFormatJSON:
-			j := []listSpecfileJSONEntry{}
+		if !all {
+			if !util.Exists(b.Specfile) {
+				logMissing(b, "no specfile")
+				continue
+			}
+			results := b.ListSpecfile()
+			if len(results) == 0 {
+				logMissing(b, "no packages in specfile")
+			}
 			for name, spec := range results {
-				j = append(j, listSpecfileJSONEntry{
-					Name: string(name),
-					Spec: string(spec),
+				addRow(string(name), string(spec))
+				specEntries = append(specEntries, listSpecfileJSONEntry{
+					Backend: tag,
+					Name:    string(name),
+					Spec:    string(spec),
 				})
 			}
-			outputB, err := json.Marshal(j)
-			if err != nil {
-				panic("couldn't marshal json")
-			}
-			fmt.Println(string(outputB))
-
-		default:
-			util.Panicf("unknown output format %d", outputFormat)
-		}
-	} else {
-		var results map[api.PkgName]api.PkgVersion = nil
-		fileExists := util.Exists(b.Lockfile)
-		if fileExists {
-			results = b.ListLockfile()
-		}
-		switch outputFormat {
-		case outputFormatTable:
-			switch {
-			case !fileExists:
-				util.Log("no lockfile")
-				return
-			case len(results) == 0:
-				util.Log("no packages in lockfile")
-				return
+		} else {
+			if !util.Exists(b.Lockfile) {
+				logMissing(b, "no lockfile")
+				continue
 			}
-			t := table.New("name", "version")
-			for name, version := range results {
-				t.AddRow(string(name), string(version))
+			results := b.ListLockfile()
+			if len(results) == 0 {
+				logMissing(b, "no packages in lockfile")
 			}
-			t.SortBy("name")
-			t.Print()
-
-		case outputFormatJSON:
-			j := []listLockfileJSONEntry{}
 			for name, version := range results {
-				j = append(j, listLockfileJSONEntry{
+				addRow(string(name), string(version))
+				lockEntries = append(lockEntries, listLockfileJSONEntry{
+					Backend: tag,
 					Name:    string(name),
 					Version: string(version),
 				})
 			}
-			outputB, err := json.Marshal(j)
-			if err != nil {
-				panic("couldn't marshal json")
-			}
-			fmt.Println(string(outputB))
+		}
+	}
 
-		default:
-			util.Panicf("unknown output format %d", outputFormat)
+	switch outputFormat {
+	case outputFormatTable:
+		if numRows == 0 {
+			return
+		}
+		t.SortBy("name")
+		if multi {
+			// Sorting is stable, so packages stay in
+			// order within each backend.
+			t.SortBy("backend")
 		}
+		printTable(t, tableOpts)
+
+	case outputFormatJSON:
+		var j interface{} = specEntries
+		if all {
+			j = lockEntries
+		}
+		outputB, err := json.Marshal(j)
+		if err != nil {
+			panic("couldn't marshal json")
+		}
+		fmt.Println(string(outputB))
+
+	default:
+		util.Panicf("unknown output format %d", outputFormat)
 	}
 }
 
-// runGuess implements 'upm guess'.
-func runGuess(
-	language string, all bool,
-	forceGuess bool, ignoredPackages []string) {
+// guessPackages returns the sorted names of the packages guessed for
+// the project by a language backend, as for 'upm guess'.
+func guessPackages(
+	b api.LanguageBackend, all bool,
+	forceGuess bool, ignoredPackages []string) []string {
 
-	b := backends.GetBackend(language)
-	pkgs := store.GuessWithCache(b, forceGuess)
+	return filterGuessed(b, store.GuessWithCache(b, forceGuess), all, ignoredPackages)
+}
+
+// filterGuessed returns the sorted names of the given guessed
+// packages, leaving out the ignored packages and, unless all is true,
+// the packages already in the specfile.
+func filterGuessed(
+	b api.LanguageBackend, pkgs map[api.PkgName]bool,
+	all bool, ignoredPackages []string) []string {
 
 	// Map from normalized to original names.
 	normPkgs := map[api.PkgName]api.PkgName{}
@@ -510,14 +1020,127 @@ func runGuess(
 		lines = append(lines, string(pkg))
 	}
 	sort.Strings(lines)
+	return lines
+}
+
+// guessWithReasons returns the packages guessed for the project by a
+// language backend, together with their reasons. If the backend
+// implements GuessExplain, then the packages are those of the
+// reasons, so that the project is only scanned once. Otherwise, the
+// packages come from store.GuessWithCache, and there are no reasons.
+func guessWithReasons(b api.LanguageBackend, forceGuess bool) (map[api.PkgName]bool, []api.GuessReason) {
+	if b.GuessExplain == nil {
+		return store.GuessWithCache(b, forceGuess), nil
+	}
+	reasons := b.GuessExplain()
+	return api.GuessedPackages(reasons), reasons
+}
+
+// guessReasons returns the reasons for the packages guessed for the
+// project by a language backend, as for 'upm guess --explain', sorted
+// by package. A package without any reason, for example because the
+// backend doesn't implement GuessExplain, is given with only its
+// name, and full confidence since nothing is known against it.
+func guessReasons(
+	b api.LanguageBackend, all bool,
+	forceGuess bool, ignoredPackages []string) []api.GuessReason {
+
+	guessed, allReasons := guessWithReasons(b, forceGuess)
+	pkgs := filterGuessed(b, guessed, all, ignoredPackages)
+	wanted := map[api.PkgName]bool{}
+	for _, pkg := range pkgs {
+		wanted[b.NormalizePackageName(api.PkgName(pkg))] = true
+	}
+
+	reasons := []api.GuessReason{}
+	explained := map[api.PkgName]bool{}
+	for _, reason := range allReasons {
+		name := b.NormalizePackageName(reason.Package)
+		if wanted[name] {
+			reasons = append(reasons, reason)
+			explained[name] = true
+		}
+	}
+	for _, pkg := range pkgs {
+		if !explained[b.NormalizePackageName(api.PkgName(pkg))] {
+			reasons = append(reasons, api.GuessReason{
+				Package:    api.PkgName(pkg),
+				Confidence: 1,
+			})
+		}
+	}
+
+	sort.SliceStable(reasons, func(i, j int) bool {
+		return reasons[i].Package < reasons[j].Package
+	})
+	return reasons
+}
+
+// runGuess implements 'upm guess'. If there is more than one
+// language backend, each line is prefixed by the name of the
+// backend and a tab. If explain is true, the reasons for each
+// package are shown in the given output format instead.
+func runGuess(
+	language string, allLanguages bool, all bool,
+	forceGuess bool, ignoredPackages []string,
+	explain bool, outputFormat outputFormat, tableOpts tableOptions) {
+
+	bs := backends.GetBackends(language, allLanguages)
+	if explain {
+		runGuessExplain(bs, all, forceGuess, ignoredPackages, outputFormat, tableOpts)
+		store.Write()
+		return
+	}
 
-	for _, line := range lines {
-		fmt.Println(line)
+	for _, b := range bs {
+		for _, line := range guessPackages(b, all, forceGuess, ignoredPackages) {
+			if len(bs) > 1 {
+				line = b.Name + "\t" + line
+			}
+			fmt.Println(line)
+		}
 	}
 
 	store.Write()
 }
 
+// runGuessExplain implements 'upm guess --explain'.
+func runGuessExplain(
+	bs []api.LanguageBackend, all bool,
+	forceGuess bool, ignoredPackages []string,
+	outputFormat outputFormat, tableOpts tableOptions) {
+
+	entries := []guessReasonEntry{}
+	for _, b := range bs {
+		tag := ""
+		if len(bs) > 1 {
+			tag = b.Name
+		}
+		for _, reason := range guessReasons(b, all, forceGuess, ignoredPackages) {
+			entries = append(entries, guessReasonEntry{
+				Backend:     tag,
+				GuessReason: reason,
+			})
+		}
+	}
+
+	switch outputFormat {
+	case outputFormatTable:
+		t := table.FromStructs(entries)
+		printTable(t, tableOpts)
+
+	case outputFormatJSON:
+		outputB, err := json.Marshal(entries)
+		if err != nil {
+			panic("couldn't marshal json")
+		}
+		fmt.Println(string(outputB))
+
+	default:
+		util.Panicf("unknown output format %d", outputFormat)
+	}
+}
+
 // runShowSpecfile implements 'upm show-specfile'.
 func runShowSpecfile(language string) {
 	fmt.Println(backends.GetBackend(language).Specfile)
@@ -534,3 +1157,126 @@ func runShowPackageDir(language string) {
 	dir := b.GetPackageDir()
 	fmt.Println(dir)
 }
+
+// runWorkspaceList implements 'upm workspace list'.
+func runWorkspaceList(outputFormat outputFormat, tableOpts tableOptions) {
+	projects := backends.FindWorkspaceProjects()
+
+	switch outputFormat {
+	case outputFormatTable:
+		if len(projects) == 0 {
+			util.Log("no projects found")
+			return
+		}
+		t := table.New("dir", "files")
+		for _, p := range projects {
+			t.AddRow(p.Dir, strings.Join(p.Files, ", "))
+		}
+		printTable(t, tableOpts)
+
+	case outputFormatJSON:
+		outputB, err := json.Marshal(projects)
+		if err != nil {
+			panic("couldn't marshal json")
+		}
+		fmt.Println(string(outputB))
+
+	default:
+		util.Panicf("unknown output format %d", outputFormat)
+	}
+}
+
+// projectEnv returns the environment for a child process of UPM
+// which should operate on the project in dir. The project gets its
+// own store, unless UPM_STORE is a relative path, in which case it
+// is taken relative to each project anyway.
+func projectEnv(dir string) []string {
+	env := []string{}
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, "UPM_PROJECT=") {
+			continue
+		}
+		if strings.HasPrefix(kv, "UPM_STORE=") &&
+			filepath.IsAbs(strings.TrimPrefix(kv, "UPM_STORE=")) {
+			continue
+		}
+		env = append(env, kv)
+	}
+	return append(env, "UPM_PROJECT="+dir)
+}
+
+// runRecursive implements --recursive, by running UPM with the given
+// arguments in every project found by backends.FindWorkspaceProjects.
+// At most opts.jobs projects are run at once, each in a child
+// process. The output of each project is printed once it has
+// finished, in the same order as 'upm workspace list'. If any of
+// them failed, runRecursive terminates the process after they have
+// all finished.
+func runRecursive(language string, opts recursiveOptions, args []string) {
+	projects := backends.FindWorkspaceProjects()
+	if len(projects) == 0 {
+		util.Die("no projects found")
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		util.Die("%s", err)
+	}
+
+	cmdArgs := []string{}
+	if language != "" {
+		cmdArgs = append(cmdArgs, "--lang", language)
+	}
+	if config.Quiet {
+		cmdArgs = append(cmdArgs, "--quiet")
+	}
+	cmdArgs = append(cmdArgs, args...)
+
+	jobs := opts.jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	sem := make(chan struct{}, jobs)
+
+	outputs := make([]bytes.Buffer, len(projects))
+	errs := make([]error, len(projects))
+	done := make([]chan struct{}, len(projects))
+	for i := range projects {
+		done[i] = make(chan struct{})
+		go func(i int) {
+			defer close(done[i])
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			dir, err := filepath.Abs(projects[i].Dir)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			cmd := exec.Command(exe, cmdArgs...)
+			cmd.Env = projectEnv(dir)
+			cmd.Stdout = &outputs[i]
+			cmd.Stderr = &outputs[i]
+			errs[i] = cmd.Run()
+		}(i)
+	}
+
+	failed := []string{}
+	for i, p := range projects {
+		<-done[i]
+		util.Log("==> " + p.Dir)
+		os.Stdout.Write(outputs[i].Bytes())
+		if errs[i] != nil {
+			if outputs[i].Len() == 0 {
+				util.Log(errs[i].Error())
+			}
+			failed = append(failed, p.Dir)
+		}
+	}
+
+	if len(failed) > 0 {
+		util.Die("failed in %d of %d projects: %s",
+			len(failed), len(projects), strings.Join(failed, ", "))
+	}
+	util.Log(fmt.Sprintf("done in %d projects", len(projects)))
+}
@@ -1,5 +1,11 @@
 package cli
 
+import (
+	"time"
+
+	"github.com/replit/upm/internal/api"
+)
+
 // outputFormat is an enum representing the argument of the --format
 // option.
 type outputFormat int
@@ -12,3 +18,101 @@ const (
 	// --format=json
 	outputFormatJSON
 )
+
+// tableOptions holds the values of the command-line options that
+// control how tables are rendered for --format=table.
+type tableOptions struct {
+
+	// --columns, the headers of the columns to show, in order.
+	// Empty means all columns.
+	columns []string
+
+	// --sort, in the format accepted by parseSortSpec. Empty
+	// means the default order for the command.
+	sort string
+
+	// --no-pager
+	noPager bool
+
+	// --layout, in the format accepted by parseLayout.
+	layout string
+
+	// --color
+	color bool
+}
+
+// searchOptions holds the values of the command-line options that
+// control which results 'upm search' returns.
+type searchOptions struct {
+
+	// --limit, the maximum number of results.
+	limit int
+
+	// --offset, the number of results to skip. --page is
+	// converted to an offset.
+	offset int
+
+	// --license, a case-insensitive substring of the license.
+	// Empty means any license.
+	license string
+
+	// --min-downloads. Zero means no minimum.
+	minDownloads int
+
+	// --exclude-deprecated
+	excludeDeprecated bool
+}
+
+// searchResult represents one row of the output of 'upm search
+// --all-languages', which is a package together with the language
+// backend whose index it was found in.
+type searchResult struct {
+	Backend string `json:"backend" pretty:"Backend"`
+	api.PkgInfo
+}
+
+// guessReasonEntry represents one row of the output of 'upm guess
+// --explain'. The backend is only given if there is more than one.
+type guessReasonEntry struct {
+	Backend string `json:"backend,omitempty" pretty:"Backend"`
+	api.GuessReason
+}
+
+// recursiveOptions holds the values of the command-line options that
+// control running a command in every project of a workspace.
+type recursiveOptions struct {
+
+	// --recursive
+	recursive bool
+
+	// --jobs, the maximum number of projects to run the command
+	// in at once.
+	jobs int
+}
+
+// lockOptions holds the values of the command-line options that
+// control what happens when another upm process is using the project.
+type lockOptions struct {
+
+	// --wait, which is true by default.
+	wait bool
+
+	// --no-wait
+	noWait bool
+
+	// --wait-timeout, or zero to wait as long as it takes.
+	waitTimeout time.Duration
+}
+
+// statusEntry represents one row of the output of 'upm status', which
+// is the state of the specfile, lockfile or installed packages of a
+// language backend.
+type statusEntry struct {
+	Backend string `json:"backend,omitempty" pretty:"Backend"`
+
+	// "specfile", "lockfile", or "packages".
+	Kind string `json:"kind" pretty:"Kind"`
+
+	Path   string `json:"path" pretty:"Path"`
+	Status string `json:"status" pretty:"Status"`
+}
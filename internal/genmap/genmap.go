@@ -0,0 +1,269 @@
+// Package genmap contains what the commands that generate the module
+// maps of the language backends, like gen_pypi_map, have in common:
+// reading an index dump, ranking the packages that provide each
+// module, and writing the maps out as go source.
+package genmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// Flags parses the command-line flags that every generator takes, and
+// returns the index dump to read, the name of the go package to
+// generate and the file to write it to.
+func Flags() (string, string, string) {
+	from := flag.String("from", "", "a json file to generate the map from")
+	pkg := flag.String("pkg", "", "the pkg name for the output source")
+	out := flag.String("out", "", "the destination file for the generated code")
+	flag.Parse()
+	return *from, *pkg, *out
+}
+
+// ReadIndex reads an index dump with one JSON object per line. Each
+// object is decoded into the value returned by calling entry, which
+// should be a pointer to a new struct.
+func ReadIndex(path string, entry func() interface{}) {
+	injson, err := os.Open(path)
+	if err != nil {
+		panic(err)
+	}
+	defer injson.Close()
+
+	dec := json.NewDecoder(injson)
+	for dec.More() {
+		if err := dec.Decode(entry()); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Package is a package in an index dump, with a count of how popular
+// it is, e.g. its downloads.
+type Package struct {
+	Name  string
+	Count int
+
+	// The number of modules the package was added for.
+	modules int
+}
+
+// Candidates maps each module (or require path, or whatever is
+// analogous for the language) to the packages that provide it.
+type Candidates map[string][]*Package
+
+// Add records that pkg provides mod.
+func (c Candidates) Add(mod string, pkg *Package) {
+	c[mod] = append(c[mod], pkg)
+	pkg.modules++
+}
+
+// Rank sorts the packages that provide each module, most popular
+// first, and returns the modules in order.
+func (c Candidates) Rank() []string {
+	mods := []string{}
+	for mod, pkgs := range c {
+		sort.Slice(pkgs, func(i, j int) bool {
+			if pkgs[i].Count != pkgs[j].Count {
+				return pkgs[i].Count > pkgs[j].Count
+			}
+			return pkgs[i].Name < pkgs[j].Name
+		})
+		mods = append(mods, mod)
+	}
+	sort.Strings(mods)
+	return mods
+}
+
+// Rules say how to choose the package for a module, out of the
+// packages that provide it.
+type Rules struct {
+	// Match returns true if the package is named after the
+	// module, following the naming conventions of the registry,
+	// in which case it's chosen however popular it is.
+	// MatchComment describes such a match in the generated
+	// source, e.g. "exact match".
+	Match        func(pkg string, mod string) bool
+	MatchComment string
+
+	// Otherwise, the most popular package is chosen if it has at
+	// least MinCount, and either it's the only one or it's 10x
+	// more popular than the next.
+	MinCount int
+
+	// If PerModule is true, then the popularity of a package is
+	// divided by the number of modules it provides when it's
+	// compared with the next, so that a package bundling many
+	// modules doesn't win them all.
+	PerModule bool
+
+	// How packages and their counts are described in the
+	// generated source, e.g. "gem", "download" and "dls".
+	Noun      string
+	CountNoun string
+	CountAbbr string
+}
+
+// Choose returns the package chosen for a module by the rules, along
+// with a comment explaining why, or false if none is. The candidates
+// must have been ranked.
+func (c Candidates) Choose(mod string, rules Rules) (*Package, string, bool) {
+	pkgs := c[mod]
+	for _, candidate := range pkgs {
+		if rules.Match(candidate.Name, mod) {
+			return candidate, rules.MatchComment, true
+		}
+	}
+
+	if len(pkgs) == 0 || pkgs[0].Count < rules.MinCount {
+		return nil, "", false
+	}
+
+	if len(pkgs) == 1 {
+		return pkgs[0], fmt.Sprintf(
+			"only one %s matched %s: %d",
+			rules.Noun, rules.CountAbbr, pkgs[0].Count,
+		), true
+	}
+
+	// if the top package is 10x more popular than the next, we'll
+	// go with it, as for the PyPI map.
+	first, second := pkgs[0].Count, pkgs[1].Count*10
+	if rules.PerModule {
+		first /= pkgs[0].modules
+		second /= pkgs[1].modules
+	}
+	if first > second {
+		return pkgs[0], fmt.Sprintf(
+			"high %s stats %s: %d second best: %d",
+			rules.CountNoun, rules.CountAbbr, pkgs[0].Count, pkgs[1].Count,
+		), true
+	}
+
+	return nil, "", false
+}
+
+// Writer accumulates the generated source. Each map is written as a
+// function which fills in a cached map the first time it's called, so
+// that the maps don't take up memory unless a guess is made.
+type Writer struct {
+	buf bytes.Buffer
+}
+
+// NewWriter returns a Writer for a go package.
+func NewWriter(pkg string) *Writer {
+	w := &Writer{}
+	fmt.Fprintf(&w.buf, "package %s\n", pkg)
+	return w
+}
+
+// BeginMap starts a map from strings to valueType, e.g. "string",
+// with the given doc comment, whose lines are written with "//" in
+// front of them.
+func (w *Writer) BeginMap(name string, valueType string, doc string) {
+	fmt.Fprintf(&w.buf, "\n")
+	for _, line := range strings.Split(strings.TrimSpace(doc), "\n") {
+		fmt.Fprintf(&w.buf, "%s\n", strings.TrimSpace("// "+line))
+	}
+	fmt.Fprintf(&w.buf, "var %sCached = map[string]%s{}\n\n", name, valueType)
+	fmt.Fprintf(&w.buf, "func %s() map[string]%s {\n", name, valueType)
+	fmt.Fprintf(&w.buf, "if len(%sCached) == 0 {\n", name)
+	fmt.Fprintf(&w.buf, "%sCached = map[string]%s{\n", name, valueType)
+}
+
+// Entry adds an entry to the current map, with an optional comment.
+func (w *Writer) Entry(key string, value interface{}, comment string) {
+	fmt.Fprintf(&w.buf, "\t%#v: %#v,", key, value)
+	if comment != "" {
+		fmt.Fprintf(&w.buf, " // %s", comment)
+	}
+	fmt.Fprintf(&w.buf, "\n")
+}
+
+// EndMap finishes the current map.
+func (w *Writer) EndMap(name string) {
+	fmt.Fprintf(&w.buf, "}\n}\nreturn %sCached\n}\n", name)
+}
+
+// ChosenMap writes a map from each module to the package chosen for
+// it by the rules, and returns the choices.
+func (w *Writer) ChosenMap(name string, doc string, c Candidates, mods []string, rules Rules) map[string]*Package {
+	chosen := map[string]*Package{}
+	w.BeginMap(name, "string", doc)
+	for _, mod := range mods {
+		if pkg, comment, ok := c.Choose(mod, rules); ok {
+			chosen[mod] = pkg
+			w.Entry(mod, pkg.Name, comment)
+		}
+	}
+	w.EndMap(name)
+	return chosen
+}
+
+// CandidatesMap writes a map from each chosen module that's provided
+// by more than one package to the other packages, most popular first.
+// The names are comma separated, since go's compiler seems to vomit
+// when you create too many slices. It returns the counts of all the
+// packages involved, chosen or not, for CountMap.
+func (w *Writer) CandidatesMap(name string, doc string, c Candidates, mods []string, chosen map[string]*Package) map[string]int {
+	counts := map[string]int{}
+	w.BeginMap(name, "string", strings.TrimSpace(doc)+`
+
+The names are comma separated to keep the go compiler happy.`)
+	for _, mod := range mods {
+		pkg, ok := chosen[mod]
+		if !ok {
+			continue
+		}
+
+		others := []string{}
+		for _, candidate := range c[mod] {
+			if candidate.Name != pkg.Name {
+				others = append(others, candidate.Name)
+				counts[candidate.Name] = candidate.Count
+			}
+		}
+		if len(others) == 0 {
+			continue
+		}
+		counts[pkg.Name] = pkg.Count
+		w.Entry(mod, strings.Join(others, ","), "")
+	}
+	w.EndMap(name)
+	return counts
+}
+
+// CountMap writes a map from package names to their counts, in order.
+func (w *Writer) CountMap(name string, doc string, counts map[string]int) {
+	names := []string{}
+	for pkg := range counts {
+		names = append(names, pkg)
+	}
+	sort.Strings(names)
+
+	w.BeginMap(name, "int", doc)
+	for _, pkg := range names {
+		w.Entry(pkg, counts[pkg], "")
+	}
+	w.EndMap(name)
+}
+
+// WriteFile writes the generated source to a file and formats it.
+func (w *Writer) WriteFile(path string) {
+	if err := ioutil.WriteFile(path, w.buf.Bytes(), 0666); err != nil {
+		panic(err)
+	}
+
+	output, err := exec.Command("gofmt", "-w", "-s", path).CombinedOutput()
+	if err != nil {
+		fmt.Println(string(output))
+		panic(err)
+	}
+}
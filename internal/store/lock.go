@@ -0,0 +1,114 @@
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/replit/upm/internal/util"
+)
+
+// lockFile is the open lock file, once Lock has been called. It is
+// kept so that the file (and hence the lock) isn't closed until the
+// process exits.
+var lockFile *os.File
+
+// getLockLocation returns the file path of the lock file, which lives
+// alongside the JSON store.
+func getLockLocation() string {
+	return filepath.Join(filepath.Dir(getStoreLocation()), "lock")
+}
+
+// lockHolder returns a description of the process that most recently
+// took the lock in the given lock file, for messages.
+func lockHolder(filename string) string {
+	bytes, err := ioutil.ReadFile(filename)
+	if err == nil {
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(bytes))); err == nil {
+			return fmt.Sprintf("another upm process (PID %d)", pid)
+		}
+	}
+	return "another upm process"
+}
+
+// lockPollInterval is how often pollLock tries to take the lock.
+const lockPollInterval = 100 * time.Millisecond
+
+// pollLock tries to take a lock on f until it succeeds, returning
+// true, or until the timeout passes, returning false.
+func pollLock(f *os.File, exclusive bool, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(lockPollInterval)
+		ok, err := tryLock(f, exclusive)
+		if err != nil {
+			util.Die("%s: %s", f.Name(), err)
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Lock takes an advisory lock on the project, so that concurrent upm
+// processes don't run package managers or write the store at the same
+// time. Commands that change the project should take an exclusive
+// lock, and commands that only read it a shared lock. If another
+// process holds a conflicting lock, then Lock waits for it to be
+// released if wait is true, and otherwise terminates the process
+// with an error naming the process holding it. A timeout other than
+// zero limits how long Lock waits before giving up with the same
+// error. The lock is held until the process exits.
+//
+// A shared lock is not taken at all if the store directory doesn't
+// exist yet, so that read-only commands don't create it.
+func Lock(exclusive bool, wait bool, timeout time.Duration) {
+	filename := getLockLocation()
+	directory := filepath.Dir(filename)
+	if exclusive {
+		if err := os.MkdirAll(directory, 0777); err != nil {
+			util.Die("%s: %s", directory, err)
+		}
+	} else if !util.Exists(directory) {
+		return
+	}
+
+	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		util.Die("%s: %s", filename, err)
+	}
+
+	ok, err := tryLock(f, exclusive)
+	if err != nil {
+		util.Die("%s: %s", filename, err)
+	}
+	if !ok {
+		holder := lockHolder(filename)
+		if !wait {
+			util.Die("%s is locked by %s; try again when it finishes, or pass --wait-timeout to wait for it", directory, holder)
+		}
+		util.Log(fmt.Sprintf("waiting for %s to finish...", holder))
+		if timeout == 0 {
+			if err := waitLock(f, exclusive); err != nil {
+				util.Die("%s: %s", filename, err)
+			}
+		} else if !pollLock(f, exclusive, timeout) {
+			util.Die("%s is still locked by %s after %s; try again when it finishes, or pass a longer --wait-timeout", directory, lockHolder(filename), timeout)
+		}
+	}
+
+	// Record our PID so that other processes can say who they
+	// are waiting for. With a shared lock this may overwrite the
+	// PID of another reader, which is fine since either one is
+	// holding the lock.
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+
+	lockFile = f
+}
@@ -0,0 +1,38 @@
+//go:build !windows
+// +build !windows
+
+package store
+
+import (
+	"os"
+	"syscall"
+)
+
+// tryLock takes a lock on f without blocking. It returns false if
+// another process holds a conflicting lock.
+func tryLock(f *os.File, exclusive bool) (bool, error) {
+	err := syscall.Flock(int(f.Fd()), flockHow(exclusive)|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// waitLock takes a lock on f, blocking until it is available.
+func waitLock(f *os.File, exclusive bool) error {
+	for {
+		err := syscall.Flock(int(f.Fd()), flockHow(exclusive))
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+// flockHow returns the flock operation for a shared or exclusive
+// lock.
+func flockHow(exclusive bool) int {
+	if exclusive {
+		return syscall.LOCK_EX
+	}
+	return syscall.LOCK_SH
+}
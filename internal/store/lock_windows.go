@@ -0,0 +1,14 @@
+package store
+
+import "os"
+
+// tryLock is not implemented on Windows, where locking always
+// succeeds immediately.
+func tryLock(f *os.File, exclusive bool) (bool, error) {
+	return true, nil
+}
+
+// waitLock is not implemented on Windows. See tryLock.
+func waitLock(f *os.File, exclusive bool) error {
+	return nil
+}
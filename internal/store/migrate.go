@@ -0,0 +1,58 @@
+package store
+
+import "strings"
+
+// migrations maps each old store version to a function which upgrades
+// a store of that version to the next one, in place. Stores are
+// migrated in their raw JSON form, so that these functions don't
+// depend on the store struct, which only describes currentVersion.
+// When currentVersion is incremented, a migration from the previous
+// version should be added here if at all possible, so that users
+// don't have to re-lock, re-install and re-guess everything.
+var migrations = map[int]func(raw map[string]interface{}){
+	// Version 3 prefixes hashes with the name of their
+	// algorithm. Version 2 hashes are all MD5.
+	2: func(raw map[string]interface{}) {
+		languages, _ := raw["languages"].(map[string]interface{})
+		for _, language := range languages {
+			language, ok := language.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			for _, key := range []string{
+				"specfileHash",
+				"lockfileHash",
+				"guessedImportsHash",
+			} {
+				h, ok := language[key].(string)
+				if ok && h != "" && !strings.Contains(h, ":") {
+					language[key] = "md5:" + h
+				}
+			}
+		}
+	},
+}
+
+// migrate upgrades the raw JSON of a store to currentVersion, in
+// place, by applying each of the necessary migrations in turn. It
+// returns false if that isn't possible (because the store is from a
+// newer version of UPM, or too old to have a migration), in which
+// case the store should be thrown away.
+func migrate(raw map[string]interface{}) bool {
+	version := 0
+	if v, ok := raw["version"].(float64); ok {
+		version = int(v)
+	}
+	if version > currentVersion {
+		return false
+	}
+	for ; version < currentVersion; version++ {
+		m, ok := migrations[version]
+		if !ok {
+			return false
+		}
+		m(raw)
+	}
+	raw["version"] = currentVersion
+	return true
+}
@@ -19,7 +19,7 @@ var st *store
 
 // currentVersion is the current store schema version. See the Version
 // field in the store struct.
-const currentVersion = 2
+const currentVersion = 3
 
 // getStoreLocation returns the file path of the JSON store.
 func getStoreLocation() string {
@@ -32,7 +32,8 @@ func getStoreLocation() string {
 }
 
 // read reads the store from disk and writes it into the global
-// variable st. If there is an error, it terminates the process.
+// variable st, migrating it from an older version if necessary. If
+// there is an error, it terminates the process.
 func read() {
 	st = &store{}
 	defer func() {
@@ -49,12 +50,20 @@ func read() {
 		util.Die("%s: %s", filename, err)
 	}
 
-	if err = json.Unmarshal(bytes, st); err != nil {
+	var raw map[string]interface{}
+	if err = json.Unmarshal(bytes, &raw); err != nil {
 		util.Die("%s: %s", filename, err)
 	}
 
-	if st.Version != currentVersion {
-		st = &store{}
+	if !migrate(raw) {
+		return
+	}
+
+	if bytes, err = json.Marshal(raw); err != nil {
+		util.Panicf("store.read: %s", err)
+	}
+	if err = json.Unmarshal(bytes, st); err != nil {
+		util.Die("%s: %s", filename, err)
 	}
 }
 
@@ -107,7 +116,7 @@ func Write() {
 func HasSpecfileChanged(b api.LanguageBackend) bool {
 	readMaybe()
 	initLanguage(b.Name)
-	return hashFile(b.Specfile) != st.Languages[b.Name].SpecfileHash
+	return !fileMatchesHash(b.Specfile, st.Languages[b.Name].SpecfileHash)
 }
 
 // HasLockfileChanged returns false if the lockfile exists and has not
@@ -117,12 +126,39 @@ func HasSpecfileChanged(b api.LanguageBackend) bool {
 func HasLockfileChanged(b api.LanguageBackend) bool {
 	readMaybe()
 	initLanguage(b.Name)
-	return hashFile(b.Lockfile) != st.Languages[b.Name].LockfileHash
+	return !fileMatchesHash(b.Lockfile, st.Languages[b.Name].LockfileHash)
+}
+
+// HasPackageDirChanged returns true if packages were installed last
+// time UpdateFileHashes was called, but the contents of the package
+// dir no longer match what they were then (for example, because it
+// was deleted). It always returns false if the backend doesn't
+// specify PackageDirMarkers.
+func HasPackageDirChanged(b api.LanguageBackend) bool {
+	readMaybe()
+	initLanguage(b.Name)
+	old := st.Languages[b.Name].PackageDirHash
+	if old == "" || b.PackageDirMarkers == nil {
+		return false
+	}
+	return hashPackageDir(b, storedPackageDir(b), old.algorithm()) != old
+}
+
+// storedPackageDir returns the package dir that was hashed the last
+// time UpdateFileHashes was called, or PackageDir(b) if there wasn't
+// one.
+func storedPackageDir(b api.LanguageBackend) string {
+	if dir := st.Languages[b.Name].PackageDir; dir != "" {
+		return dir
+	}
+	return PackageDir(b)
 }
 
 // GuessWithCache returns b.Guess(), but re-uses a cached return value
-// if possible. The cache is used if the matches of b.GuessRegexps
-// against b.FilenamePatterns has not changed since the last time
+// if possible. If the backend provides GuessFileImports, then the
+// cache is per file, as described for guessFilesWithCache. Otherwise,
+// the cache is used if the matches of b.GuessRegexps against
+// b.FilenamePatterns has not changed since the last time
 // GuessWithCache was invoked. (This is only possible if the backend
 // specifies b.GuessRegexps, which is not always the case. If the
 // backend does specify b.GuessRegexps, then the return value of this
@@ -131,15 +167,23 @@ func HasLockfileChanged(b api.LanguageBackend) bool {
 func GuessWithCache(b api.LanguageBackend, forceGuess bool) map[api.PkgName]bool {
 	readMaybe()
 	initLanguage(b.Name)
+	if b.GuessFileImports != nil {
+		return guessFilesWithCache(b, forceGuess)
+	}
 	old := st.Languages[b.Name].GuessedImportsHash
 	var new hash = "n/a"
+	changed := true
 	// If no regexps, then we can't hash imports. Skip reading and
 	// writing the hash.
 	if len(b.GuessRegexps) > 0 {
-		new = hashImports(b)
+		matches := importMatches(b)
+		// The old hash may have been written with another
+		// algorithm by an older version of UPM.
+		changed = hashImports(matches, old.algorithm()) != old
+		new = hashImports(matches, defaultHashAlgorithm)
 		st.Languages[b.Name].GuessedImportsHash = new
 	}
-	if forceGuess || new != old {
+	if forceGuess || changed {
 		var pkgs map[api.PkgName]bool
 		success := true
 		if new != "" {
@@ -185,11 +229,81 @@ func GuessWithCache(b api.LanguageBackend, forceGuess bool) map[api.PkgName]bool
 	}
 }
 
-// UpdateFileHashes caches the current states of the specfile and
-// lockfile. Neither file need exist.
+// guessFilesWithCache implements GuessWithCache for backends that
+// provide GuessFileImports. The imports of each project file are
+// cached along with its hash, and only files which are new or have
+// changed are passed to b.GuessFileImports. The packages are then
+// worked out from the imports of all the files. If forceGuess is
+// true, then every file is analyzed again.
+func guessFilesWithCache(b api.LanguageBackend, forceGuess bool) map[api.PkgName]bool {
+	old := st.Languages[b.Name].GuessedFiles
+	files := util.ListFilesRecursive(b.FilenamePatterns)
+
+	guessed := map[string]*storeGuessedFile{}
+	changed := []string{}
+	for _, file := range files {
+		if cached := old[file]; cached != nil && !forceGuess && fileMatchesHash(file, cached.Hash) {
+			guessed[file] = cached
+		} else {
+			changed = append(changed, file)
+		}
+	}
+
+	if len(changed) > 0 {
+		results := b.GuessFileImports(changed)
+		for _, file := range changed {
+			// Files that couldn't be analyzed are left
+			// out of the cache, so that they're tried
+			// again next time.
+			if imports, ok := results[file]; ok {
+				guessed[file] = &storeGuessedFile{
+					Hash:    hashFile(file),
+					Imports: imports,
+				}
+			}
+		}
+	}
+
+	st.Languages[b.Name].GuessedFiles = guessed
+	st.Languages[b.Name].GuessedImports = nil
+	st.Languages[b.Name].GuessedImportsHash = ""
+
+	seen := map[string]bool{}
+	imports := []string{}
+	for _, file := range files {
+		if guessed[file] == nil {
+			continue
+		}
+		for _, imp := range guessed[file].Imports {
+			if !seen[imp] {
+				seen[imp] = true
+				imports = append(imports, imp)
+			}
+		}
+	}
+	return b.GuessFromImports(imports)
+}
+
+// UpdateFileHashes caches the current states of the specfile,
+// lockfile and package dir. None of them need exist. If none of them
+// has changed, the package dir isn't looked up again, since that may
+// run the package manager.
 func UpdateFileHashes(b api.LanguageBackend) {
 	readMaybe()
 	initLanguage(b.Name)
-	st.Languages[b.Name].SpecfileHash = hashFile(b.Specfile)
-	st.Languages[b.Name].LockfileHash = hashFile(b.Lockfile)
+	lang := st.Languages[b.Name]
+	unchanged := lang.PackageDirHash != "" && lang.PackageDir != "" &&
+		!HasSpecfileChanged(b) && !HasLockfileChanged(b) &&
+		!HasPackageDirChanged(b)
+	lang.SpecfileHash = hashFile(b.Specfile)
+	lang.LockfileHash = hashFile(b.Lockfile)
+	if unchanged {
+		return
+	}
+	lang.PackageDir = ""
+	lang.PackageDirHash = ""
+	if b.PackageDirMarkers != nil {
+		lang.PackageDir = PackageDir(b)
+		lang.PackageDirHash = hashPackageDir(b, lang.PackageDir, defaultHashAlgorithm)
+	}
 }
@@ -0,0 +1,320 @@
+package store
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/replit/upm/internal/api"
+	"github.com/replit/upm/internal/util"
+)
+
+func TestMigrateFromVersion2(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestMigrateFromVersion2")
+	if err != nil {
+		t.Errorf("failed to create a temp directory %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	specfile := filepath.Join(dir, "package.json")
+	contents := []byte(`{"dependencies": {}}`)
+	if err := ioutil.WriteFile(specfile, contents, 0666); err != nil {
+		t.Errorf("failed to write specfile: %v", err)
+	}
+	sum := md5.Sum(contents)
+	md5Hash := hex.EncodeToString(sum[:])
+
+	storefile := filepath.Join(dir, "store.json")
+	old := `{"version":2,"languages":{"nodejs-npm":{"specfileHash":"` + md5Hash + `"}}}`
+	if err := ioutil.WriteFile(storefile, []byte(old), 0666); err != nil {
+		t.Errorf("failed to write store: %v", err)
+	}
+	os.Setenv("UPM_STORE", storefile)
+	defer os.Unsetenv("UPM_STORE")
+	st = nil
+
+	b := api.LanguageBackend{
+		Name:     "nodejs-npm",
+		Specfile: specfile,
+		Lockfile: filepath.Join(dir, "package-lock.json"),
+	}
+
+	if HasSpecfileChanged(b) {
+		t.Errorf("specfile should be unchanged after migration")
+	}
+	if st.Version != currentVersion {
+		t.Errorf("expected version %d but got %d", currentVersion, st.Version)
+	}
+	if st.Languages["nodejs-npm"].SpecfileHash != hash("md5:"+md5Hash) {
+		t.Errorf("expected md5 hash to be prefixed but got %q", st.Languages["nodejs-npm"].SpecfileHash)
+	}
+
+	UpdateFileHashes(b)
+	if h := st.Languages["nodejs-npm"].SpecfileHash; h.algorithm() != "sha256" {
+		t.Errorf("expected sha256 hash but got %q", h)
+	}
+	if HasSpecfileChanged(b) {
+		t.Errorf("specfile should be unchanged after rehashing")
+	}
+
+	if err := ioutil.WriteFile(specfile, []byte(`{}`), 0666); err != nil {
+		t.Errorf("failed to write specfile: %v", err)
+	}
+	if !HasSpecfileChanged(b) {
+		t.Errorf("specfile should have changed")
+	}
+}
+
+func TestMigrateUnknownVersion(t *testing.T) {
+	for _, version := range []float64{1, currentVersion + 1} {
+		raw := map[string]interface{}{"version": version}
+		if migrate(raw) {
+			t.Errorf("store version %v should not be migrated", version)
+		}
+	}
+}
+
+func TestHasPackageDirChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestHasPackageDirChanged")
+	if err != nil {
+		t.Errorf("failed to create a temp directory %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Setenv("UPM_STORE", filepath.Join(dir, "store.json"))
+	defer os.Unsetenv("UPM_STORE")
+	st = nil
+	packageDirs = map[string]string{}
+
+	pkgDir := filepath.Join(dir, "node_modules")
+	b := api.LanguageBackend{
+		Name:              "nodejs-npm",
+		Specfile:          filepath.Join(dir, "package.json"),
+		Lockfile:          filepath.Join(dir, "package-lock.json"),
+		GetPackageDir:     func() string { return pkgDir },
+		PackageDirMarkers: []string{".package-lock.json"},
+	}
+
+	write := func(file string, contents string) {
+		path := filepath.Join(pkgDir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Errorf("failed to create directory for: %s err: %v", path, err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0666); err != nil {
+			t.Errorf("failed to write: %s err: %v", path, err)
+		}
+	}
+
+	if HasPackageDirChanged(b) {
+		t.Errorf("package dir should be unchanged before first install")
+	}
+
+	write("left-pad/package.json", "{}")
+	write(".package-lock.json", `{"packages": {}}`)
+	UpdateFileHashes(b)
+	if HasPackageDirChanged(b) {
+		t.Errorf("package dir should be unchanged after install")
+	}
+
+	write(".package-lock.json", `{"packages": {"left-pad": {}}}`)
+	if !HasPackageDirChanged(b) {
+		t.Errorf("package dir should have changed after marker was rewritten")
+	}
+
+	UpdateFileHashes(b)
+	os.RemoveAll(pkgDir)
+	if !HasPackageDirChanged(b) {
+		t.Errorf("package dir should have changed after it was deleted")
+	}
+}
+
+func TestUpdateFileHashesReusesPackageDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestUpdateFileHashesReusesPackageDir")
+	if err != nil {
+		t.Errorf("failed to create a temp directory %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Setenv("UPM_STORE", filepath.Join(dir, "store.json"))
+	defer os.Unsetenv("UPM_STORE")
+	st = nil
+	packageDirs = map[string]string{}
+
+	pkgDir := filepath.Join(dir, "venv")
+	calls := 0
+	b := api.LanguageBackend{
+		Name:     "python3-poetry",
+		Specfile: filepath.Join(dir, "pyproject.toml"),
+		Lockfile: filepath.Join(dir, "poetry.lock"),
+		GetPackageDir: func() string {
+			calls++
+			return pkgDir
+		},
+		PackageDirMarkers: []string{"lib/python*/site-packages/*.dist-info"},
+	}
+	marker := filepath.Join(pkgDir, "lib/python3.8/site-packages/flask-1.1.1.dist-info")
+	if err := os.MkdirAll(marker, 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	UpdateFileHashes(b)
+	packageDirs = map[string]string{}
+	UpdateFileHashes(b)
+	if HasPackageDirChanged(b) || calls != 1 {
+		t.Errorf("package dir should be looked up once while nothing changes, got %d", calls)
+	}
+
+	os.RemoveAll(marker)
+	UpdateFileHashes(b)
+	if HasPackageDirChanged(b) || calls != 2 {
+		t.Errorf("package dir should be looked up again after it changed, got %d", calls)
+	}
+}
+
+func TestGuessFilesWithCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestGuessFilesWithCache")
+	if err != nil {
+		t.Errorf("failed to create a temp directory %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.Chdir(dir); err != nil {
+		t.Errorf("failed to change to directory: %s err: %v", dir, err)
+	}
+	os.Setenv("UPM_STORE", filepath.Join(dir, "store.json"))
+	defer os.Unsetenv("UPM_STORE")
+	st = nil
+
+	write := func(file string, contents string) {
+		if err := ioutil.WriteFile(file, []byte(contents), 0666); err != nil {
+			t.Errorf("failed to write: %s err: %v", file, err)
+		}
+	}
+
+	// Each file holds the name of one import, or "!" for a
+	// syntax error.
+	analyzed := []string{}
+	b := api.LanguageBackend{
+		Name:             "test",
+		FilenamePatterns: []string{"*.txt"},
+		GuessFileImports: func(files []string) map[string][]string {
+			analyzed = append(analyzed, files...)
+			imports := map[string][]string{}
+			for _, file := range files {
+				contents, _ := ioutil.ReadFile(file)
+				if string(contents) != "!" {
+					imports[file] = []string{string(contents)}
+				}
+			}
+			return imports
+		},
+		GuessFromImports: func(imports []string) map[api.PkgName]bool {
+			pkgs := map[api.PkgName]bool{}
+			for _, imp := range imports {
+				pkgs[api.PkgName(imp)] = true
+			}
+			return pkgs
+		},
+	}
+
+	guess := func(expectedAnalyzed []string, expectedPkgs ...string) {
+		t.Helper()
+		analyzed = []string{}
+		pkgs := GuessWithCache(b, false)
+		if len(analyzed) != len(expectedAnalyzed) {
+			t.Errorf("expected to analyze %v but analyzed %v", expectedAnalyzed, analyzed)
+		} else {
+			for i := range analyzed {
+				if analyzed[i] != expectedAnalyzed[i] {
+					t.Errorf("expected to analyze %v but analyzed %v", expectedAnalyzed, analyzed)
+				}
+			}
+		}
+		if len(pkgs) != len(expectedPkgs) {
+			t.Errorf("expected packages %v but got %v", expectedPkgs, pkgs)
+		}
+		for _, pkg := range expectedPkgs {
+			if !pkgs[api.PkgName(pkg)] {
+				t.Errorf("expected packages %v but got %v", expectedPkgs, pkgs)
+			}
+		}
+	}
+
+	write("a.txt", "flask")
+	write("b.txt", "numpy")
+	guess([]string{"a.txt", "b.txt"}, "flask", "numpy")
+	guess([]string{}, "flask", "numpy")
+
+	write("b.txt", "pandas")
+	write("c.txt", "!")
+	guess([]string{"b.txt", "c.txt"}, "flask", "pandas")
+
+	// c.txt is analyzed again until it can be.
+	guess([]string{"c.txt"}, "flask", "pandas")
+
+	os.Remove("a.txt")
+	write("c.txt", "requests")
+	guess([]string{"c.txt"}, "pandas", "requests")
+}
+
+func TestGuessWithCacheExtraInput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestGuessWithCacheExtraInput")
+	if err != nil {
+		t.Errorf("failed to create a temp directory %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.Chdir(dir); err != nil {
+		t.Errorf("failed to change to directory: %s err: %v", dir, err)
+	}
+	os.Setenv("UPM_STORE", filepath.Join(dir, "store.json"))
+	defer os.Unsetenv("UPM_STORE")
+	st = nil
+
+	// Imports of the project's own package, named in own, are
+	// left out.
+	own := "app"
+	guesses := 0
+	b := api.LanguageBackend{
+		Name:             "test",
+		FilenamePatterns: []string{"*.txt"},
+		GuessRegexps:     util.Regexps([]string{`import (\w+)`}),
+		GuessExtraInput: func() []byte {
+			return []byte(own)
+		},
+		Guess: func() (map[api.PkgName]bool, bool) {
+			guesses++
+			pkgs := map[api.PkgName]bool{}
+			for _, match := range util.SearchRecursive(regexp.MustCompile(`import (\w+)`), []string{"*.txt"}) {
+				if match[1] != own {
+					pkgs[api.PkgName(match[1])] = true
+				}
+			}
+			return pkgs, true
+		},
+	}
+
+	if err := ioutil.WriteFile("a.txt", []byte("import app\nimport http"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	for i, tc := range []struct {
+		own     string
+		guesses int
+		pkgs    int
+	}{
+		{"app", 1, 1},
+		{"app", 1, 1},
+		{"http", 2, 1},
+		{"other", 3, 2},
+	} {
+		own = tc.own
+		pkgs := GuessWithCache(b, false)
+		if guesses != tc.guesses || len(pkgs) != tc.pkgs {
+			t.Errorf("step %d: expected %d guesses and %d packages, got %d and %v", i, tc.guesses, tc.pkgs, guesses, pkgs)
+		}
+	}
+}
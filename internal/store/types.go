@@ -1,6 +1,7 @@
 package store
 
-// hash is used in the store to represent a serializable MD5 hash.
+// hash is used in the store to represent a serializable hash. It is
+// prefixed by the name of the algorithm, e.g. "sha256:e3b0c442...".
 type hash string
 
 type storeLanguage struct {
@@ -15,6 +16,17 @@ type storeLanguage struct {
 	// computed.
 	LockfileHash hash `json:"lockfileHash,omitempty"`
 
+	// The hash of the contents of the package dir after the
+	// last install, or an empty string to indicate that the
+	// directory didn't exist or the backend doesn't specify
+	// PackageDirMarkers.
+	PackageDirHash hash `json:"packageDirHash,omitempty"`
+
+	// The package dir whose contents PackageDirHash is the hash
+	// of, so that checking it doesn't need b.GetPackageDir(),
+	// which may run the package manager.
+	PackageDir string `json:"packageDir,omitempty"`
+
 	// The last return value of b.Guess(), converted to a slice.
 	// This is only set if the language backend provides
 	// GuessRegexps.
@@ -23,14 +35,31 @@ type storeLanguage struct {
 	// The hash of the last sequence of matches for GuessRegexps
 	// against the project code.
 	GuessedImportsHash hash `json:"guessedImportsHash,omitempty"`
+
+	// Map from the paths of project files to their last return
+	// values from b.GuessFileImports(). This is only set if the
+	// language backend provides GuessFileImports.
+	GuessedFiles map[string]*storeGuessedFile `json:"guessedFiles,omitempty"`
+}
+
+// storeGuessedFile is the cached result of guessing the imports of one
+// project file.
+type storeGuessedFile struct {
+
+	// The hash of the file when it was analyzed.
+	Hash hash `json:"hash"`
+
+	// The imports found in the file.
+	Imports []string `json:"imports,omitempty"`
 }
 
 // store represents the JSON written (by default) to .upm/store.json.
 type store struct {
 
 	// The version of the store file. This gets incremented every
-	// time we make a backwards-incompatible change, and causes
-	// the store to be invalidated.
+	// time we make a backwards-incompatible change. Older stores
+	// are upgraded by the functions in migrations, or
+	// invalidated if there is no migration for their version.
 	Version int `json:"version,omitempty"`
 
 	// Map from backend names to per-backend data.
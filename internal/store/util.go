@@ -2,34 +2,99 @@ package store
 
 import (
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/replit/upm/internal/api"
 	"github.com/replit/upm/internal/util"
 )
 
-// hashFile computes the MD5 hash of the contents of the given file.
-// It returns the empty string if the file does not exist.
-func hashFile(filename string) hash {
+// hashAlgorithms maps the algorithm prefixes used in the store to the
+// hash functions they name. Hashes are always written with
+// defaultHashAlgorithm, but hashes written by older versions of UPM
+// can still be checked against.
+var hashAlgorithms = map[string]func([]byte) []byte{
+	"md5": func(b []byte) []byte {
+		sum := md5.Sum(b)
+		return sum[:]
+	},
+	"sha256": func(b []byte) []byte {
+		sum := sha256.Sum256(b)
+		return sum[:]
+	},
+}
+
+// defaultHashAlgorithm is the algorithm used for new hashes.
+const defaultHashAlgorithm = "sha256"
+
+// hashBytes computes the hash of the given bytes with the named
+// algorithm, which must be a key of hashAlgorithms.
+func hashBytes(algorithm string, bytes []byte) hash {
+	f, ok := hashAlgorithms[algorithm]
+	if !ok {
+		util.Panicf("unknown hash algorithm %q", algorithm)
+	}
+	return hash(algorithm + ":" + hex.EncodeToString(f(bytes)))
+}
+
+// algorithm returns the algorithm that h was computed with, or
+// defaultHashAlgorithm if h is empty or the algorithm is unknown.
+func (h hash) algorithm() string {
+	if i := strings.Index(string(h), ":"); i != -1 {
+		if _, ok := hashAlgorithms[string(h[:i])]; ok {
+			return string(h[:i])
+		}
+	}
+	return defaultHashAlgorithm
+}
+
+// readFileMaybe returns the contents of the given file. The second
+// return value is false if the file does not exist.
+func readFileMaybe(filename string) ([]byte, bool) {
 	bytes, err := ioutil.ReadFile(filename)
 	if os.IsNotExist(err) {
-		return ""
+		return nil, false
 	} else if err != nil {
 		util.Die("%s: %s", filename, err)
 	}
-	sum := md5.Sum(bytes)
-	return hash(hex.EncodeToString(sum[:]))
+	return bytes, true
 }
 
-// hashImports computes the MD5 hash of the matches of b.GuessRegexps
-// against b.FilenamePatterns within the project. It is guaranteed to
-// be deterministic as long as the project files do not change in such
-// a way as to change what any of the regexps match against. If there
-// are no regexp matches, then as a special case the empty string is
-// returned.
-func hashImports(b api.LanguageBackend) hash {
+// hashFile computes the hash of the contents of the given file with
+// defaultHashAlgorithm. It returns the empty string if the file does
+// not exist.
+func hashFile(filename string) hash {
+	bytes, ok := readFileMaybe(filename)
+	if !ok {
+		return ""
+	}
+	return hashBytes(defaultHashAlgorithm, bytes)
+}
+
+// fileMatchesHash returns true if h is the hash of the contents of the
+// given file, using whichever algorithm h was computed with, or if h
+// is empty and the file does not exist.
+func fileMatchesHash(filename string, h hash) bool {
+	bytes, ok := readFileMaybe(filename)
+	if !ok {
+		return h == ""
+	}
+	return hashBytes(h.algorithm(), bytes) == h
+}
+
+// importMatches returns the matches of b.GuessRegexps against
+// b.FilenamePatterns within the project, concatenated, followed by
+// b.GuessExtraInput() if there are any. It is guaranteed to be
+// deterministic as long as the project files do not change in such a
+// way as to change what any of the regexps match against, or the
+// extra input.
+func importMatches(b api.LanguageBackend) []byte {
 	bytes := []byte{}
 	for _, r := range b.GuessRegexps {
 		// Rely on lexical ordering of filepath.Walk to
@@ -44,9 +109,90 @@ func hashImports(b api.LanguageBackend) hash {
 			}
 		}
 	}
-	if len(bytes) == 0 {
+	// Without imports, nothing is guessed whatever else there
+	// is, so the extra input is left out to keep the hash empty.
+	if len(bytes) > 0 && b.GuessExtraInput != nil {
+		bytes = append(bytes, 0)
+		bytes = append(bytes, b.GuessExtraInput()...)
+	}
+	return bytes
+}
+
+// hashImports computes the hash of the result of importMatches with
+// the given algorithm. If there are no regexp matches, then as a
+// special case the empty string is returned.
+func hashImports(matches []byte, algorithm string) hash {
+	if len(matches) == 0 {
+		return ""
+	}
+	return hashBytes(algorithm, matches)
+}
+
+// packageDirs maps backend names to the return values of their
+// GetPackageDir, which may run the package manager, so it's called at
+// most once per backend.
+var packageDirs = map[string]string{}
+
+// PackageDir returns b.GetPackageDir(), calling it only the first
+// time.
+func PackageDir(b api.LanguageBackend) string {
+	dir, ok := packageDirs[b.Name]
+	if !ok {
+		dir = b.GetPackageDir()
+		packageDirs[b.Name] = dir
+	}
+	return dir
+}
+
+// packageDirFingerprint returns a description of the contents of the
+// package dir, which changes when packages are installed or removed:
+// the names of its entries, and the paths and sizes of the files
+// matching b.PackageDirMarkers. It returns nil if the package dir
+// does not exist.
+func packageDirFingerprint(b api.LanguageBackend, dir string) []byte {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		util.Die("%s: %s", dir, err)
+	}
+
+	lines := []string{}
+	for _, entry := range entries {
+		lines = append(lines, entry.Name())
+	}
+	for _, pattern := range b.PackageDirMarkers {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			panic(err)
+		}
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				util.Die("%s: %s", match, err)
+			}
+			rel, _ := filepath.Rel(dir, match)
+			if info.IsDir() {
+				lines = append(lines, rel)
+			} else {
+				lines = append(lines, fmt.Sprintf("%s %d", rel, info.Size()))
+			}
+		}
+	}
+	sort.Strings(lines)
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// hashPackageDir computes the hash of packageDirFingerprint with the
+// given algorithm. It returns the empty string if the package dir
+// does not exist, or if b doesn't specify PackageDirMarkers.
+func hashPackageDir(b api.LanguageBackend, dir string, algorithm string) hash {
+	if b.PackageDirMarkers == nil {
+		return ""
+	}
+	fingerprint := packageDirFingerprint(b, dir)
+	if fingerprint == nil {
 		return ""
 	}
-	sum := md5.Sum(bytes)
-	return hash(hex.EncodeToString(sum[:]))
+	return hashBytes(algorithm, fingerprint)
 }
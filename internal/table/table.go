@@ -9,8 +9,11 @@ import (
 	"os/exec"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/hashicorp/go-version"
 	"github.com/replit/upm/internal/util"
 	"golang.org/x/crypto/ssh/terminal"
 )
@@ -32,20 +35,36 @@ func New(headers ...string) Table {
 // FromStructs creates a new table from the given slice of structs.
 // The table headers are generated from the struct field reflection
 // metadata: each struct field must have a reflection metadata key
-// "pretty" whose value is the header to display. The only allowed
-// field types in the struct are string and []string. The strings are
-// used as table cells directly, while the slices are concatenated
-// with commas first.
+// "pretty" whose value is the header to display. Fields of embedded
+// structs are included as if they were declared directly. The cells
+// are formatted with FormatValue, and columns which are empty in
+// every row are omitted.
 func FromStructs(structs interface{}) Table {
 	sv := reflect.ValueOf(structs)
 	st := reflect.TypeOf(structs).Elem()
 
+	fields := []reflect.StructField{}
+	for _, field := range reflect.VisibleFields(st) {
+		if field.Anonymous || field.Tag.Get("pretty") == "" {
+			continue
+		}
+		fields = append(fields, field)
+	}
+
+	cells := make([][]string, sv.Len())
+	for j := range cells {
+		for _, field := range fields {
+			rfield := sv.Index(j).FieldByIndex(field.Index)
+			cells[j] = append(cells[j], FormatValue(rfield))
+		}
+	}
+
 	indices := []int{}
 	headers := []string{}
-	for i := 0; i < st.NumField(); i++ {
+	for i, field := range fields {
 		nonempty := false
-		for j := 0; j < sv.Len(); j++ {
-			if sv.Index(j).Field(i).Len() > 0 {
+		for j := range cells {
+			if cells[j][i] != "" {
 				nonempty = true
 				break
 			}
@@ -54,34 +73,72 @@ func FromStructs(structs interface{}) Table {
 			continue
 		}
 		indices = append(indices, i)
-		header := st.Field(i).Tag.Get("pretty")
-		headers = append(headers, header)
+		headers = append(headers, field.Tag.Get("pretty"))
 	}
 
 	t := Table{headers: headers}
-	for j := 0; j < sv.Len(); j++ {
+	for j := range cells {
 		row := []string{}
 		for _, i := range indices {
-			var value string
-			rfield := sv.Index(j).Field(i)
-			switch rfield.Kind() {
-			case reflect.String:
-				value = rfield.String()
-			case reflect.Slice:
-				parts := []string{}
-				for j := 0; j < rfield.Len(); j++ {
-					str := rfield.Index(j).String()
-					parts = append(parts, str)
-				}
-				value = strings.Join(parts, ", ")
-			}
-			row = append(row, value)
+			row = append(row, cells[j][i])
 		}
 		t.AddRow(row...)
 	}
 	return t
 }
 
+// dateFormat is the layout used by FormatValue for times.
+const dateFormat = "2006-01-02"
+
+// FormatValue returns the representation of a struct field value
+// used as a cell by FromStructs. Strings are used directly, slices
+// are formatted elementwise and concatenated with commas, booleans
+// become "yes" or the empty string, integers are printed in decimal
+// (with zero as the empty string), times are printed as dates, and
+// nil pointers are the empty string. Other types must implement
+// fmt.Stringer.
+func FormatValue(v reflect.Value) string {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+
+	if t, ok := v.Interface().(time.Time); ok {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(dateFormat)
+	}
+	if s, ok := v.Interface().(fmt.Stringer); ok {
+		return s.String()
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		if v.Bool() {
+			return "yes"
+		}
+		return ""
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Int() == 0 {
+			return ""
+		}
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Slice:
+		parts := []string{}
+		for i := 0; i < v.Len(); i++ {
+			parts = append(parts, FormatValue(v.Index(i)))
+		}
+		return strings.Join(parts, ", ")
+	}
+
+	panic(fmt.Sprintf("table: cannot format value of type %s", v.Type()))
+}
+
 // AddRow adds a row at the end of a table. The length of the row must
 // be the same as the number of headers in the table, or a panic will
 // be generated.
@@ -100,6 +157,7 @@ func (t *Table) AddRow(row ...string) {
 type tableSorter struct {
 	table Table
 	index int
+	less  Comparator
 }
 
 // Len implements sort.Interface. It returns the number of rows in the
@@ -117,28 +175,138 @@ func (ts *tableSorter) Swap(i, j int) {
 // Less implements sort.Interface. It compares the given rows by
 // looking at the sort column.
 func (ts *tableSorter) Less(i, j int) bool {
-	return ts.table.rows[i][ts.index] < ts.table.rows[j][ts.index]
+	return ts.less(ts.table.rows[i][ts.index], ts.table.rows[j][ts.index])
 }
 
-// SortBy sorts a table by the column with the given header. The
-// header must exist in the table, or a panic is generated. Since
-// tables cannot have duplicate headers, any column can be specified
-// unambiguously.
-func (t *Table) SortBy(header string) {
-	var index int
-	found := false
+// Headers returns the headers of the table, in order.
+func (t *Table) Headers() []string {
+	return append([]string{}, t.headers...)
+}
+
+// HasColumn returns true if the table has a column with the given
+// header. Headers are matched case-insensitively, so that "name"
+// matches the "Name" column generated by FromStructs.
+func (t *Table) HasColumn(header string) bool {
+	_, ok := t.columnIndex(header)
+	return ok
+}
+
+// columnIndex returns the index of the column with the given header,
+// preferring an exact match over a case-insensitive one. The second
+// return value is false if there is no such column.
+func (t *Table) columnIndex(header string) (int, bool) {
 	for i := range t.headers {
 		if t.headers[i] == header {
-			index = i
-			found = true
-			break
+			return i, true
 		}
 	}
+	for i := range t.headers {
+		if strings.EqualFold(t.headers[i], header) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// SelectColumns removes all columns from the table except for the
+// ones with the given headers, and reorders the remaining columns to
+// match the order of the arguments. Every header must exist in the
+// table (see HasColumn), or a panic is generated.
+func (t *Table) SelectColumns(headers ...string) {
+	indices := []int{}
+	for _, header := range headers {
+		index, ok := t.columnIndex(header)
+		if !ok {
+			util.Panicf("no such header: %s", header)
+		}
+		indices = append(indices, index)
+	}
+
+	newHeaders := []string{}
+	for _, index := range indices {
+		newHeaders = append(newHeaders, t.headers[index])
+	}
+	newRows := [][]string{}
+	for _, row := range t.rows {
+		newRow := []string{}
+		for _, index := range indices {
+			newRow = append(newRow, row[index])
+		}
+		newRows = append(newRows, newRow)
+	}
+	t.headers = newHeaders
+	t.rows = newRows
+}
+
+// SortBy sorts a table lexically by the column with the given
+// header. The header must exist in the table, or a panic is
+// generated. Since tables cannot have duplicate headers, any column
+// can be specified unambiguously.
+func (t *Table) SortBy(header string) {
+	t.SortByFunc(header, CompareLexical)
+}
+
+// SortByFunc is like SortBy, but compares cells using the given
+// function instead of lexically. The sort is stable, so rows which
+// compare equal keep their relative order.
+func (t *Table) SortByFunc(header string, less Comparator) {
+	index, found := t.columnIndex(header)
 	if !found {
 		util.Panicf("no such header: %s", header)
 	}
-	sorter := &tableSorter{table: *t, index: index}
-	sort.Sort(sorter)
+	sorter := &tableSorter{table: *t, index: index, less: less}
+	sort.Stable(sorter)
+}
+
+// CompareLexical is a Comparator that orders cells by their string
+// values.
+func CompareLexical(a, b string) bool {
+	return a < b
+}
+
+// parseNumber parses a cell as a number, ignoring thousands
+// separators. The second return value is false if the cell isn't a
+// number.
+func parseNumber(cell string) (float64, bool) {
+	cell = strings.TrimSpace(cell)
+	cell = strings.Replace(cell, ",", "", -1)
+	cell = strings.Replace(cell, "_", "", -1)
+	n, err := strconv.ParseFloat(cell, 64)
+	return n, err == nil
+}
+
+// CompareNumeric is a Comparator that orders cells numerically.
+// Cells which are not numbers sort after all the ones which are, and
+// are ordered lexically amongst themselves.
+func CompareNumeric(a, b string) bool {
+	na, aOK := parseNumber(a)
+	nb, bOK := parseNumber(b)
+	switch {
+	case aOK && bOK:
+		return na < nb
+	case aOK != bOK:
+		return aOK
+	default:
+		return a < b
+	}
+}
+
+// CompareSemver is a Comparator that orders cells as version
+// numbers, so that for example "1.10.0" sorts after "1.9.2" and
+// "2.0.0-beta" sorts before "2.0.0". Cells which are not versions
+// sort after all the ones which are, and are ordered lexically
+// amongst themselves.
+func CompareSemver(a, b string) bool {
+	va, errA := version.NewVersion(strings.TrimSpace(a))
+	vb, errB := version.NewVersion(strings.TrimSpace(b))
+	switch {
+	case errA == nil && errB == nil:
+		return va.LessThan(vb)
+	case (errA == nil) != (errB == nil):
+		return errA == nil
+	default:
+		return a < b
+	}
 }
 
 // printOrPage either prints text to stdout or invokes the 'less'
@@ -191,42 +359,213 @@ func printOrPage(text string, width int) {
 	}
 }
 
+// ellipsis is appended to cells that are cut short by
+// LayoutTruncate.
+const ellipsis = "…"
+
+// minColumnWidth is the narrowest that LayoutWrap and LayoutTruncate
+// will shrink a column.
+const minColumnWidth = 10
+
+// columnGap is the whitespace printed between adjacent columns.
+const columnGap = "   "
+
+// runeLen returns the number of runes in a string, which is what we
+// use as its display width.
+func runeLen(s string) int {
+	return len([]rune(s))
+}
+
+// fitWidths shrinks the widest of the given column widths, one
+// character at a time, until their total is no more than available
+// or no column can be shrunk any further. A column is never shrunk
+// below minColumnWidth or the width of its header, whichever is
+// larger.
+func fitWidths(widths []int, headers []string, available int) []int {
+	fitted := append([]int{}, widths...)
+	total := 0
+	for _, width := range fitted {
+		total += width
+	}
+	for total > available {
+		widest := -1
+		for j := range fitted {
+			floor := minColumnWidth
+			if headerLen := runeLen(headers[j]); headerLen > floor {
+				floor = headerLen
+			}
+			if fitted[j] <= floor {
+				continue
+			}
+			if widest == -1 || fitted[j] > fitted[widest] {
+				widest = j
+			}
+		}
+		if widest == -1 {
+			break
+		}
+		fitted[widest]--
+		total--
+	}
+	return fitted
+}
+
+// truncateCell cuts a cell short with an ellipsis so that it is no
+// wider than width.
+func truncateCell(cell string, width int) string {
+	runes := []rune(cell)
+	if len(runes) <= width {
+		return cell
+	}
+	if width <= runeLen(ellipsis) {
+		return string(runes[:width])
+	}
+	return string(runes[:width-runeLen(ellipsis)]) + ellipsis
+}
+
+// wrapCell splits a cell into lines no wider than width, breaking at
+// spaces where possible and in the middle of words otherwise. It
+// always returns at least one line.
+func wrapCell(cell string, width int) []string {
+	if width <= 0 || runeLen(cell) <= width {
+		return []string{cell}
+	}
+	lines := []string{}
+	line := []rune{}
+	for _, word := range strings.Fields(cell) {
+		wordRunes := []rune(word)
+		if len(line) > 0 && len(line)+1+len(wordRunes) > width {
+			lines = append(lines, string(line))
+			line = []rune{}
+		}
+		if len(line) > 0 {
+			line = append(line, ' ')
+		}
+		line = append(line, wordRunes...)
+		for len(line) > width {
+			lines = append(lines, string(line[:width]))
+			line = line[width:]
+		}
+	}
+	if len(line) > 0 || len(lines) == 0 {
+		lines = append(lines, string(line))
+	}
+	return lines
+}
+
+// colorEnabled returns true if the header row should be highlighted,
+// given the options passed to PrintWith.
+func colorEnabled(opts PrintOptions) bool {
+	if !opts.Color {
+		return false
+	}
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	return terminal.IsTerminal(1)
+}
+
 // Print writes the table to stdout, aligning columns by inserting
 // whitespace. If the table is too wide for the current terminal, and
 // the 'less' utility is installed, Print invokes it with the -S
 // option to truncate long lines and allow horizontal scrolling.
 func (t *Table) Print() {
-	lines := []string{}
+	t.PrintWith(PrintOptions{})
+}
+
+// PrintWith is like Print, but allows the caller to configure what
+// happens when the table is too wide for the terminal, and whether
+// the header row is highlighted.
+func (t *Table) PrintWith(opts PrintOptions) {
 	widths := make([]int, len(t.headers))
 	for j := range t.headers {
-		widths[j] = len([]rune(t.headers[j]))
+		widths[j] = runeLen(t.headers[j])
 	}
 	for i := range t.rows {
 		for j := range t.rows[i] {
-			if len([]rune(t.rows[i][j])) > widths[j] {
-				widths[j] = len([]rune(t.rows[i][j]))
+			if runeLen(t.rows[i][j]) > widths[j] {
+				widths[j] = runeLen(t.rows[i][j])
 			}
 		}
 	}
-	fields := make([]string, len(t.headers))
-	for j := range t.headers {
-		padding := widths[j] - len([]rune(t.headers[j]))
-		fields[j] = t.headers[j] + strings.Repeat(" ", padding)
+
+	totalWidth := runeLen(columnGap) * (len(widths) - 1)
+	for _, width := range widths {
+		totalWidth += width
+	}
+
+	if opts.Layout != LayoutPage {
+		termWidth, _, err := terminal.GetSize(1)
+		if err == nil && totalWidth > termWidth {
+			gaps := runeLen(columnGap) * (len(widths) - 1)
+			widths = fitWidths(widths, t.headers, termWidth-gaps)
+		}
+	}
+
+	// layoutCell returns the lines that a cell occupies once
+	// fitted into the width of column j.
+	layoutCell := func(cell string, j int) []string {
+		switch opts.Layout {
+		case LayoutWrap:
+			return wrapCell(cell, widths[j])
+		case LayoutTruncate:
+			return []string{truncateCell(cell, widths[j])}
+		default:
+			return []string{cell}
+		}
+	}
+
+	// formatRow returns the lines of text for one row of the
+	// table, padding each cell to the width of its column.
+	formatRow := func(row []string) []string {
+		cellLines := make([][]string, len(row))
+		height := 1
+		for j := range row {
+			cellLines[j] = layoutCell(row[j], j)
+			if len(cellLines[j]) > height {
+				height = len(cellLines[j])
+			}
+		}
+		lines := []string{}
+		for k := 0; k < height; k++ {
+			fields := make([]string, len(row))
+			for j := range row {
+				var cell string
+				if k < len(cellLines[j]) {
+					cell = cellLines[j][k]
+				}
+				padding := widths[j] - runeLen(cell)
+				if padding < 0 {
+					padding = 0
+				}
+				fields[j] = cell + strings.Repeat(" ", padding)
+			}
+			lines = append(lines, strings.Join(fields, columnGap))
+		}
+		return lines
 	}
-	lines = append(lines, strings.Join(fields, "   "))
+
+	lines := []string{}
+	header := formatRow(t.headers)
+	if colorEnabled(opts) {
+		for i := range header {
+			header[i] = "\x1b[1m" + header[i] + "\x1b[0m"
+		}
+	}
+	lines = append(lines, header...)
+	fields := make([]string, len(t.headers))
 	for j := range t.headers {
 		fields[j] = strings.Repeat("-", widths[j])
 	}
-	lines = append(lines, strings.Join(fields, "   "))
+	lines = append(lines, strings.Join(fields, columnGap))
 	for i := range t.rows {
-		for j := range t.rows[i] {
-			padding := widths[j] - len([]rune(t.rows[i][j]))
-			fields[j] = t.rows[i][j] + strings.Repeat(" ", padding)
-		}
-		lines = append(lines, strings.Join(fields, "   "))
+		lines = append(lines, formatRow(t.rows[i])...)
+	}
+
+	text := strings.Join(lines, "\n") + "\n"
+	if opts.Layout == LayoutPage && !opts.NoPager {
+		printOrPage(text, totalWidth)
+	} else {
+		fmt.Print(text)
 	}
-	// A bit of a hack; we should really compute this directly
-	// from the widths array, but this is simple.
-	totalWidth := len([]rune(lines[1]))
-	printOrPage(strings.Join(lines, "\n")+"\n", totalWidth)
 }
@@ -0,0 +1,141 @@
+package table
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSortByFunc(t *testing.T) {
+	tcs := []struct {
+		scenario string
+		less     Comparator
+		cells    []string
+		expected []string
+	}{
+		{
+			scenario: "Lexical order",
+			less:     CompareLexical,
+			cells:    []string{"10", "9", "100"},
+			expected: []string{"10", "100", "9"},
+		},
+		{
+			scenario: "Numeric order puts non-numbers last",
+			less:     CompareNumeric,
+			cells:    []string{"1,000", "n/a", "9", "100"},
+			expected: []string{"9", "100", "1,000", "n/a"},
+		},
+		{
+			scenario: "Semver order handles prereleases",
+			less:     CompareSemver,
+			cells:    []string{"1.10.0", "2.0.0", "1.9.2", "2.0.0-beta.1", "latest"},
+			expected: []string{"1.9.2", "1.10.0", "2.0.0-beta.1", "2.0.0", "latest"},
+		},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.scenario, func(t *testing.T) {
+			table := New("cell")
+			for _, cell := range tc.cells {
+				table.AddRow(cell)
+			}
+			table.SortByFunc("cell", tc.less)
+
+			actual := []string{}
+			for _, row := range table.rows {
+				actual = append(actual, row[0])
+			}
+			if !reflect.DeepEqual(tc.expected, actual) {
+				t.Errorf("expected %v but got %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestSelectColumns(t *testing.T) {
+	table := New("Name", "Description", "Version")
+	table.AddRow("flask", "A web framework", "1.1.1")
+
+	if !table.HasColumn("version") {
+		t.Errorf("expected case-insensitive match for column version")
+	}
+
+	table.SelectColumns("version", "Name")
+
+	if !reflect.DeepEqual([]string{"Version", "Name"}, table.headers) {
+		t.Errorf("unexpected headers %v", table.headers)
+	}
+	if !reflect.DeepEqual([][]string{{"1.1.1", "flask"}}, table.rows) {
+		t.Errorf("unexpected rows %v", table.rows)
+	}
+}
+
+func TestFitWidths(t *testing.T) {
+	headers := []string{"Name", "Description", "Version"}
+	widths := []int{12, 70, 7}
+
+	actual := fitWidths(widths, headers, 60)
+	expected := []int{12, 41, 7}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v but got %v", expected, actual)
+	}
+
+	// Columns are never shrunk below the minimum width.
+	actual = fitWidths(widths, headers, 5)
+	expected = []int{minColumnWidth, len("Description"), 7}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v but got %v", expected, actual)
+	}
+}
+
+func TestWrapAndTruncateCell(t *testing.T) {
+	wrapped := wrapCell("nose extends unittest to make testing easier", 16)
+	expected := []string{"nose extends", "unittest to make", "testing easier"}
+	if !reflect.DeepEqual(expected, wrapped) {
+		t.Errorf("expected %q but got %q", expected, wrapped)
+	}
+
+	wrapped = wrapCell("supercalifragilistic", 8)
+	expected = []string{"supercal", "ifragili", "stic"}
+	if !reflect.DeepEqual(expected, wrapped) {
+		t.Errorf("expected %q but got %q", expected, wrapped)
+	}
+
+	truncated := truncateCell("nose extends unittest", 10)
+	if truncated != "nose exte…" {
+		t.Errorf("unexpected truncation %q", truncated)
+	}
+}
+
+func TestFromStructs(t *testing.T) {
+	type version struct {
+		Version string `pretty:"Version"`
+	}
+	type pkg struct {
+		Name       string     `pretty:"Name"`
+		Keywords   []string   `pretty:"Keywords"`
+		Deprecated bool       `pretty:"Deprecated"`
+		Downloads  int        `pretty:"Downloads"`
+		Published  *time.Time `pretty:"Published"`
+		Versions   []version  `pretty:"Versions"`
+	}
+
+	published := time.Date(2019, time.July, 4, 12, 0, 0, 0, time.UTC)
+	table := FromStructs([]pkg{
+		{Name: "flask", Keywords: []string{"web", "wsgi"}, Downloads: 1200, Published: &published},
+		{Name: "nose", Deprecated: true},
+	})
+
+	expectedHeaders := []string{"Name", "Keywords", "Deprecated", "Downloads", "Published"}
+	if !reflect.DeepEqual(expectedHeaders, table.headers) {
+		t.Errorf("expected headers %v but got %v", expectedHeaders, table.headers)
+	}
+	expectedRows := [][]string{
+		{"flask", "web, wsgi", "", "1200", "2019-07-04"},
+		{"nose", "", "yes", "", ""},
+	}
+	if !reflect.DeepEqual(expectedRows, table.rows) {
+		t.Errorf("expected rows %q but got %q", expectedRows, table.rows)
+	}
+}
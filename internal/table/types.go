@@ -4,8 +4,48 @@ package table
 // of header cells and a list of rows. Each row must be the same
 // length as the list of header cells. Tables can be formatted nicely
 // to stdout. Construct a table with the New or FromStructs functions,
-// and then use the AddRow, SortBy, and Print methods.
+// and then use the AddRow, SelectColumns, SortBy, and Print methods.
 type Table struct {
 	headers []string
 	rows    [][]string
 }
+
+// Layout is an enum describing what Print does with a table that is
+// too wide for the terminal.
+type Layout int
+
+// Values for Layout.
+const (
+	// Print the table as-is, piping it through 'less -S' if
+	// possible (unless paging is disabled).
+	LayoutPage Layout = iota
+
+	// Shrink the widest columns until the table fits, wrapping
+	// the contents of long cells onto multiple lines.
+	LayoutWrap
+
+	// Shrink the widest columns until the table fits, cutting
+	// long cells short with an ellipsis.
+	LayoutTruncate
+)
+
+// PrintOptions controls the behavior of PrintWith. The zero value
+// gives the behavior of Print.
+type PrintOptions struct {
+
+	// What to do if the table is too wide for the terminal.
+	Layout Layout
+
+	// If true, never invoke a pager, even if the table is too
+	// wide for the terminal.
+	NoPager bool
+
+	// If true, highlight the header row. This is ignored if
+	// stdout is not a terminal or if NO_COLOR is set in the
+	// environment (see https://no-color.org/).
+	Color bool
+}
+
+// Comparator reports whether the cell a should sort before the cell
+// b. It is used with SortByFunc.
+type Comparator func(a, b string) bool
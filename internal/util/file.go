@@ -25,6 +25,7 @@ var IgnoredPaths = []string{
 	".cache",
 	".cask",
 	".config",
+	".dart_tool",
 	".git",
 	".hg",
 	".local",
@@ -85,14 +86,13 @@ func PatternExists(pattern string) bool {
 	}
 }
 
-// SearchRecursive does a recursive regexp search in the current
-// directory. Only files whose basenames match one of the globs in
-// patterns will be searched. The return value is a list of matches as
-// would be returned by regexp.FindAllStringSubmatch. Matches are
-// returned in a deterministic order. If an I/O error occurs,
-// SearchRecursive terminates the process.
-func SearchRecursive(r *regexp.Regexp, patterns []string) [][]string {
-	matches := [][]string{}
+// ListFilesRecursive returns the paths of the regular files in the
+// current directory and its subdirectories whose basenames match one
+// of the globs in patterns, in lexical order. Directories named in
+// IgnoredPaths are not searched. If an I/O error occurs,
+// ListFilesRecursive terminates the process.
+func ListFilesRecursive(patterns []string) []string {
+	files := []string{}
 	filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			Die("%s: %s", path, err)
@@ -102,34 +102,44 @@ func SearchRecursive(r *regexp.Regexp, patterns []string) [][]string {
 				return filepath.SkipDir
 			}
 		}
-		didMatch := false
+		if !info.Mode().IsRegular() {
+			return nil
+		}
 		for _, pattern := range patterns {
 			matched, err := filepath.Match(pattern, filepath.Base(path))
 			if err != nil {
 				panic(err)
 			}
 			if matched {
-				didMatch = true
+				files = append(files, path)
 				break
 			}
 		}
-		if !didMatch {
-			return nil
-		}
-		if info.Mode().IsRegular() {
-			contentsB, err := ioutil.ReadFile(path)
-			if err != nil {
-				Die("%s: %s", path, err)
-			}
-			contents := string(contentsB)
-
-			matches = append(matches,
-				r.FindAllStringSubmatch(contents, -1)...,
-			)
-		}
 		return nil
 	})
+	return files
+}
 
+// SearchRecursive does a recursive regexp search in the current
+// directory. Only files whose basenames match one of the globs in
+// patterns will be searched, as by ListFilesRecursive. The return
+// value is a list of matches as would be returned by
+// regexp.FindAllStringSubmatch. Matches are returned in a
+// deterministic order. If an I/O error occurs, SearchRecursive
+// terminates the process.
+func SearchRecursive(r *regexp.Regexp, patterns []string) [][]string {
+	matches := [][]string{}
+	for _, path := range ListFilesRecursive(patterns) {
+		contentsB, err := ioutil.ReadFile(path)
+		if err != nil {
+			Die("%s: %s", path, err)
+		}
+		contents := string(contentsB)
+
+		matches = append(matches,
+			r.FindAllStringSubmatch(contents, -1)...,
+		)
+	}
 	return matches
 }
 
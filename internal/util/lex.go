@@ -0,0 +1,70 @@
+package util
+
+import "strings"
+
+// IsIdentByte returns true if c can be part of an identifier made of
+// ASCII letters, digits and underscores, as in most languages. The
+// lexers of the language backends work a byte at a time rather than
+// decoding UTF-8, so every non-ASCII byte is allowed too, as if it
+// belonged to a letter.
+func IsIdentByte(c byte) bool {
+	return c == '_' ||
+		'a' <= c && c <= 'z' ||
+		'A' <= c && c <= 'Z' ||
+		'0' <= c && c <= '9' ||
+		c >= 0x80
+}
+
+// SkipBlockComment returns the length of the block comment at the
+// start of src, which begins with open and ends with close, e.g. "/*"
+// and "*/". If nested is true, then block comments within it must be
+// closed too. An unterminated comment runs to the end of src.
+func SkipBlockComment(src string, open string, close string, nested bool) int {
+	if !nested {
+		end := strings.Index(src[len(open):], close)
+		if end == -1 {
+			return len(src)
+		}
+		return len(open) + end + len(close)
+	}
+	i, depth := 0, 0
+	for i < len(src) {
+		if strings.HasPrefix(src[i:], open) {
+			depth++
+			i += len(open)
+		} else if strings.HasPrefix(src[i:], close) {
+			depth--
+			i += len(close)
+			if depth == 0 {
+				break
+			}
+		} else {
+			i++
+		}
+	}
+	return i
+}
+
+// SkipCTrivia returns the offset of the first byte at or after i in
+// src that isn't whitespace or part of a "//" or "/* */" comment, as
+// in the languages that borrow C's comment syntax. If nested is true,
+// then block comments nest.
+func SkipCTrivia(src string, i int, nested bool) int {
+	for i < len(src) {
+		switch {
+		case strings.ContainsRune(" \t\r\n\f", rune(src[i])):
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			end := strings.IndexByte(src[i:], '\n')
+			if end == -1 {
+				return len(src)
+			}
+			i += end
+		case strings.HasPrefix(src[i:], "/*"):
+			i += SkipBlockComment(src[i:], "/*", "*/", nested)
+		default:
+			return i
+		}
+	}
+	return i
+}
@@ -0,0 +1,36 @@
+package util
+
+import "testing"
+
+func TestSkipBlockComment(t *testing.T) {
+	tcs := []struct {
+		src      string
+		nested   bool
+		expected int
+	}{
+		{"/* a */ b", false, 7},
+		{"/* /* a */ b */ c", false, 10},
+		{"/* /* a */ b */ c", true, 15},
+		{"/* a", false, 4},
+		{"/* /* a */", true, 10},
+	}
+
+	for _, tc := range tcs {
+		if n := SkipBlockComment(tc.src, "/*", "*/", tc.nested); n != tc.expected {
+			t.Errorf("expected %d for %q, got %d", tc.expected, tc.src, n)
+		}
+	}
+}
+
+func TestSkipCTrivia(t *testing.T) {
+	src := "  // line\n\t/* block /* nested */ */ x"
+	if i := SkipCTrivia(src, 0, true); src[i:] != "x" {
+		t.Errorf("expected to stop at x, got %q", src[i:])
+	}
+	if i := SkipCTrivia(src, 0, false); src[i:] != "*/ x" {
+		t.Errorf("expected to stop at the unmatched */, got %q", src[i:])
+	}
+	if i := SkipCTrivia("// only", 0, false); i != len("// only") {
+		t.Errorf("expected the end, got %d", i)
+	}
+}
@@ -2,8 +2,9 @@
 # using pipreqs. It takes no arguments, and dumps a list of package
 # names (strings) to stdout in JSON format. The script works in both
 # Python 2 and Python 3. It expects pipreqs.py to be on the
-# PYTHONPATH. UPM accomplishes this by writing it into the same
-# directory as this script.
+# PYTHONPATH. UPM no longer runs it, but it is the reference that the
+# import scanner in internal/backends/python is checked against, as
+# described in TestExternalImports.
 
 from __future__ import print_function
 import json